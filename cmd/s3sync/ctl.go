@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/daemon"
+	"github.com/jvkec/aws-s3sync/internal/output"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "control a running daemon",
+	Long: `talks to the control api of a running 's3sync daemon' to show the status of its jobs, trigger,
+pause and resume them, or make it reload its configuration.`,
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the jobs of the daemon",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		jobs, err := controlClient(cmd).Status(cmd.Context())
+		if err != nil {
+			exitWithError("error querying daemon", err)
+		}
+		renderJobStatus(jobs)
+	},
+}
+
+var ctlTriggerCmd = &cobra.Command{
+	Use:   "trigger [job]",
+	Short: "run a job, or every job, now",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runJobControl(cmd, args, "triggered", (*daemon.ControlClient).Trigger)
+	},
+}
+
+var ctlPauseCmd = &cobra.Command{
+	Use:   "pause [job]",
+	Short: "stop scheduling a job, or every job",
+	Long:  `stops scheduling a job, or every job, until it is resumed. a running job finishes its run.`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runJobControl(cmd, args, "paused", (*daemon.ControlClient).Pause)
+	},
+}
+
+var ctlResumeCmd = &cobra.Command{
+	Use:   "resume [job]",
+	Short: "schedule a paused job, or every job, again",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runJobControl(cmd, args, "resumed", (*daemon.ControlClient).Resume)
+	},
+}
+
+var ctlReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "make the daemon read its configuration again",
+	Long: `makes the daemon read its configuration again. jobs are added, changed and removed by name
+and keep their state; the control address only changes when the daemon restarts.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		jobs, err := controlClient(cmd).Reload(cmd.Context())
+		if err != nil {
+			exitWithError("error reloading daemon", err)
+		}
+		renderJobStatus(jobs)
+	},
+}
+
+// controlclient returns a client for the control address given by flag or configuration
+func controlClient(cmd *cobra.Command) *daemon.ControlClient {
+	address, _ := cmd.Flags().GetString("control")
+	if address == "" {
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+		address = controlAddress(configManager, cfg)
+	}
+
+	client, err := daemon.NewControlClient(address)
+	if err != nil {
+		exitWithError("", usageError("%v", err))
+	}
+	return client
+}
+
+// runjobcontrol applies a job control to the named job, or every job, and reports the jobs it changed
+func runJobControl(cmd *cobra.Command, args []string, verb string, control func(*daemon.ControlClient, context.Context, string) ([]string, error)) {
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+
+	done, err := control(controlClient(cmd), cmd.Context(), name)
+	if err != nil {
+		exitWithError("error controlling daemon", err)
+	}
+
+	message := fmt.Sprintf("✅ %s %s", verb, strings.Join(done, ", "))
+	if len(done) == 0 {
+		message = fmt.Sprintf("nothing %s", verb)
+	}
+	renderStatus(statusRecord{Status: "ok", Message: fmt.Sprintf("%s %d jobs", verb, len(done))}, message)
+}
+
+// renderjobstatus prints the status of the daemon's jobs
+func renderJobStatus(jobs []daemon.JobStatus) {
+	rows := make([][]string, 0, len(jobs))
+	for _, job := range jobs {
+		rows = append(rows, []string{job.Name, jobState(job), formatJobTime(job.LastRun), formatJobTime(job.NextRun), job.LastError})
+	}
+
+	render(output.View{
+		Data:    jobs,
+		Columns: []string{"job", "state", "last run", "next run", "last error"},
+		Rows:    rows,
+		Text: func(w io.Writer) {
+			for _, job := range jobs {
+				printJobStatus(w, job)
+			}
+		},
+	})
+}
+
+// printjobstatus writes the status of one job in plain text
+func printJobStatus(w io.Writer, job daemon.JobStatus) {
+	fmt.Fprintf(w, "%s (%s): %s\n", job.Name, jobState(job), job.Description)
+	fmt.Fprintf(w, "  schedule: %s, next run %s\n", job.Schedule, formatJobTime(job.NextRun))
+	if !job.LastRun.IsZero() {
+		fmt.Fprintf(w, "  last run: %s, took %s\n", formatJobTime(job.LastRun), time.Duration(job.Duration*float64(time.Second)).Round(time.Millisecond))
+	}
+	if !job.LastSuccess.IsZero() && job.LastSuccess != job.LastRun {
+		fmt.Fprintf(w, "  last success: %s\n", formatJobTime(job.LastSuccess))
+	}
+	var result sync.Summary
+	if len(job.LastResult) > 0 && json.Unmarshal(job.LastResult, &result) == nil {
+		fmt.Fprintf(w, "  last result: %d succeeded, %d failed, %d bytes transferred\n", result.Succeeded, result.Failed, result.BytesTransferred)
+	}
+	if job.LastError != "" {
+		fmt.Fprintf(w, "  last error: %s (%d failures in a row)\n", job.LastError, job.Failures)
+	}
+	if job.Running {
+		fmt.Fprintf(w, "  running since %s, %d transfers in progress\n", formatJobTime(*job.RunStarted), len(job.Transfers))
+		for _, transfer := range job.Transfers {
+			fmt.Fprintf(w, "    %s %s (%d bytes)\n", transfer.Operation, transfer.Path, transfer.Size)
+		}
+	}
+}
+
+// jobstate summarizes whether a job runs, waits, is paused or failing
+func jobState(job daemon.JobStatus) string {
+	switch {
+	case job.Running:
+		return "running"
+	case job.Paused:
+		return "paused"
+	case job.Failures > 0:
+		return "failing"
+	default:
+		return "idle"
+	}
+}
+
+// formatjobtime formats a job time, or a dash if it is unset
+func formatJobTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
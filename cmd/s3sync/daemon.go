@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/daemon"
+	"github.com/jvkec/aws-s3sync/internal/hooks"
+	"github.com/jvkec/aws-s3sync/internal/metrics"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "run the configured sync jobs on their schedules",
+	Long: `runs the sync jobs configured under daemon.jobs in one long-lived process. each job syncs a
+local directory with a bucket prefix in one direction (push, pull or sync), either every
+interval or at the times of a cron expression:
+
+  daemon:
+    jitter: 10s
+    min_backoff: 30s
+    max_backoff: 30m
+    jobs:
+      - name: photos
+        local_path: /home/me/photos
+        bucket: my-backups
+        prefix: photos
+        direction: push
+        interval: 15m
+      - name: documents
+        local_path: /home/me/documents
+        bucket: my-backups
+        prefix: documents
+        direction: sync
+        cron: "0 * * * *"
+
+jobs run one at a time, each delayed by a random jitter. a failed job is retried after a
+backoff that doubles with every failure, up to max_backoff. the outcome of each job is kept in
+daemon/state.json next to the config file, and only one daemon runs per config directory.
+
+the daemon serves a control api on daemon/control.sock next to the config file, or on the
+socket path or loopback host:port set as daemon.control. 's3sync ctl' uses it to show the
+status of the jobs and to trigger, pause and resume them or reload the configuration. the
+control api and daemon.metrics_addr (or --metrics-addr) serve prometheus metrics on /metrics.
+
+every job runs the hooks configured under hooks with its name in the event; see 's3sync help hooks'.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+
+		jobs, err := daemonJobs(cfg)
+		if err != nil {
+			exitWithError("", configError(err))
+		}
+
+		lock, err := daemon.AcquireLock(filepath.Join(daemonDir(configManager), "daemon.lock"))
+		if err != nil {
+			exitWithError("", err)
+		}
+		defer lock.Release()
+
+		statePath := filepath.Join(daemonDir(configManager), "state.json")
+		state, err := daemon.LoadState(statePath)
+		if err != nil {
+			exitWithError("", err)
+		}
+
+		scheduler := &daemon.Scheduler{
+			Jobs:       jobs,
+			Jitter:     cfg.Daemon.Jitter,
+			MinBackoff: cfg.Daemon.MinBackoff,
+			MaxBackoff: cfg.Daemon.MaxBackoff,
+			State:      state,
+			StatePath:  statePath,
+			OnStart: func(job daemon.Job) {
+				renderer.Printf("🕒 running job %s\n", job.Name)
+			},
+			OnFinish: func(job daemon.Job, state daemon.JobState, err error) {
+				if err != nil {
+					reportJobError(job.Name, err)
+					renderer.Printf("🔁 retrying job %s at %s (%d failures)\n", job.Name, state.NextRun.Format("2006-01-02 15:04:05"), state.Failures)
+					return
+				}
+				renderer.Printf("⏰ next run of job %s at %s\n", job.Name, state.NextRun.Format("2006-01-02 15:04:05"))
+			},
+		}
+
+		if once, _ := cmd.Flags().GetBool("once"); once {
+			if err := scheduler.RunOnce(cmd.Context()); err != nil {
+				exitWithError("error running jobs", err)
+			}
+			return
+		}
+
+		// the control api reads the configuration again on reload
+		listener, err := daemon.ListenControl(controlAddress(configManager, cfg))
+		if err != nil {
+			exitWithError("error starting control api", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Handler())
+		mux.Handle("/", daemon.NewControlHandler(scheduler, func() error {
+			cfg, err := configManager.LoadConfig()
+			if err != nil {
+				return err
+			}
+			jobs, err := daemonJobs(cfg)
+			if err != nil {
+				return err
+			}
+			renderer.Printf("🔄 configuration reloaded with %d jobs\n", len(jobs))
+			return scheduler.Reload(jobs, cfg.Daemon.Jitter, cfg.Daemon.MinBackoff, cfg.Daemon.MaxBackoff)
+		}))
+		server := &http.Server{Handler: mux}
+		go server.Serve(listener)
+		defer server.Close()
+
+		metricsAddr := cfg.Daemon.MetricsAddr
+		if cmd.Flags().Changed("metrics-addr") {
+			metricsAddr, _ = cmd.Flags().GetString("metrics-addr")
+		}
+		stopMetrics, err := startMetrics(metricsAddr)
+		if err != nil {
+			exitWithError("", err)
+		}
+		defer stopMetrics()
+
+		renderer.Printf("🚀 daemon started with %d jobs, control api on %s\n", len(jobs), listener.Addr())
+		if err := scheduler.Run(cmd.Context()); err != nil && !errors.Is(err, context.Canceled) {
+			exitWithError("daemon stopped", err)
+		}
+	},
+}
+
+// daemondir returns the directory holding the daemon lock, state and control socket
+func daemonDir(configManager *config.ConfigManager) string {
+	return filepath.Join(filepath.Dir(configManager.GetConfigPath()), "daemon")
+}
+
+// controladdress returns the configured control api address, by default a socket in the daemon directory
+func controlAddress(configManager *config.ConfigManager, cfg *config.Config) string {
+	if cfg.Daemon.Control != "" {
+		return cfg.Daemon.Control
+	}
+	return filepath.Join(daemonDir(configManager), "control.sock")
+}
+
+// daemonjobs validates the configured jobs and turns them into scheduled runs of the push,
+// pull or sync pipeline
+func daemonJobs(cfg *config.Config) ([]daemon.Job, error) {
+	if len(cfg.Daemon.Jobs) == 0 {
+		return nil, errors.New("no daemon jobs configured, add them under daemon.jobs in the config file")
+	}
+
+	// hooks are checked up front rather than failing every run
+	if _, err := hooks.New(cfg.Hooks); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]daemon.Job, 0, len(cfg.Daemon.Jobs))
+	names := make(map[string]bool)
+	for i, jobConfig := range cfg.Daemon.Jobs {
+		if jobConfig.Name == "" {
+			return nil, fmt.Errorf("daemon job %d has no name", i+1)
+		}
+		if names[jobConfig.Name] {
+			return nil, fmt.Errorf("daemon job %s is configured twice", jobConfig.Name)
+		}
+		names[jobConfig.Name] = true
+
+		job, err := daemonJob(cfg, jobConfig)
+		if err != nil {
+			return nil, fmt.Errorf("daemon job %s: %w", jobConfig.Name, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// daemonjob builds the scheduled run of one configured job
+func daemonJob(cfg *config.Config, jobConfig config.JobConfig) (daemon.Job, error) {
+	job := daemon.Job{Name: jobConfig.Name}
+
+	if jobConfig.LocalPath == "" {
+		return job, errors.New("local_path is required")
+	}
+	localPath, err := filepath.Abs(jobConfig.LocalPath)
+	if err != nil {
+		return job, fmt.Errorf("invalid local_path: %w", err)
+	}
+
+	bucketName := jobConfig.Bucket
+	if bucketName == "" {
+		bucketName = cfg.Sync.DefaultBucket
+	}
+	if bucketName == "" {
+		return job, errors.New("bucket is required (no default bucket configured)")
+	}
+
+	direction := sync.Direction(jobConfig.Direction)
+	if _, err := sync.DirectionOps(direction); err != nil {
+		return job, err
+	}
+
+	opts := syncOptions{conflict: sync.ConflictNewer}
+	if jobConfig.Conflict != "" {
+		if opts.conflict, err = sync.ParseConflictPolicy(jobConfig.Conflict); err != nil {
+			return job, err
+		}
+	}
+	if opts.conflict == sync.ConflictPrompt {
+		return job, errors.New("conflict policy prompt needs a terminal, use newer, local, remote, keep-both or skip")
+	}
+
+	switch {
+	case jobConfig.Interval > 0 && jobConfig.Cron != "":
+		return job, errors.New("set either interval or cron, not both")
+	case jobConfig.Interval > 0:
+		job.Schedule = daemon.Every(jobConfig.Interval)
+	case jobConfig.Cron != "":
+		if job.Schedule, err = daemon.ParseCron(jobConfig.Cron); err != nil {
+			return job, err
+		}
+	default:
+		return job, errors.New("interval or cron is required")
+	}
+
+	job.Description = fmt.Sprintf("%s %s with s3://%s", direction, localPath, path.Join(bucketName, jobConfig.Prefix))
+
+	// each job syncs its own prefix with otherwise shared settings
+	jobCfg := *cfg
+	jobCfg.Sync.Prefix = jobConfig.Prefix
+
+	job.Run = func(ctx context.Context, run *daemon.Run) error {
+		// report transfers in progress and the outcome to the control api
+		var summary sync.Summary
+		opts := opts
+		opts.job = job.Name
+		opts.observer = &syncObserver{
+			onStart: func(action sync.SyncAction) {
+				run.Begin(daemon.Transfer{Operation: string(action.EffectiveOp()), Path: action.RelativePath, Size: action.File.Size})
+			},
+			onResult: func(result sync.ActionResult) {
+				run.End(result.RelativePath)
+				summary.AddResults([]sync.ActionResult{result})
+			},
+		}
+		defer func() { run.SetResult(summary) }()
+
+		switch direction {
+		case sync.DirectionPush:
+			return performPush(ctx, localPath, bucketName, &jobCfg, opts)
+		case sync.DirectionPull:
+			return performPull(ctx, bucketName, localPath, &jobCfg, opts)
+		default:
+			return performBidirectionalSync(ctx, localPath, bucketName, &jobCfg, opts)
+		}
+	}
+	return job, nil
+}
+
+// reportjoberror prints a failed job run without stopping the daemon
+func reportJobError(name string, err error) {
+	fmt.Fprintf(os.Stderr, "❌ job %s failed: %v\n", name, err)
+	if hint := aws.Hint(err); hint != "" {
+		fmt.Fprintf(os.Stderr, "hint: %s\n", hint)
+	}
+}
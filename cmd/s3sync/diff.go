@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/diff"
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/output"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [path] [bucket-name]",
+	Short: "show differences between local files and their s3 versions",
+	Long: `reads the remote version of a file, without writing it locally, and shows a unified diff
+against the local file (remote as the old side, local as the new side). binary files are
+compared by size, sha256 and modification time; objects too large to diff are not read and are
+compared by the size, etag and modification time of their listing. when path is a directory, a
+one-line summary is printed for every file that differs, and objects without a local file are
+not read either.
+the sync root is the nearest parent directory containing .s3sync; the bucket defaults to the
+one recorded in its manifest, then to the configured default bucket.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		target := "."
+		if len(args) >= 1 {
+			target = args[0]
+		}
+
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
+		}
+
+		absTarget, err := filepath.Abs(target)
+		if err != nil {
+			exitWithError("error resolving path", err)
+		}
+		root := findSyncRoot(absTarget)
+
+		bucketName, err := statusBucket(root, args, cfg)
+		if err != nil {
+			exitWithError("", err)
+		}
+
+		client, err := aws.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating aws client", err)
+		}
+		scanOptions, err := scanOptionsFromConfig(cfg)
+		if err != nil {
+			exitWithError("", err)
+		}
+
+		relPath, err := filepath.Rel(root, absTarget)
+		if err != nil {
+			exitWithError("error resolving path", err)
+		}
+		key := filepath.ToSlash(relPath)
+		contextLines, _ := cmd.Flags().GetInt("context")
+
+		d := &differ{
+			client:      client,
+			bucket:      bucketName,
+			prefix:      cfg.Sync.Prefix,
+			root:        root,
+			context:     contextLines,
+			scanOptions: scanOptions,
+		}
+
+		if stat, statErr := os.Stat(absTarget); key == "." || (statErr == nil && stat.IsDir()) {
+			if err := d.diffTree(cmd.Context(), key); err != nil {
+				exitWithError("error comparing directory", err)
+			}
+			return
+		}
+
+		if err := d.diffFile(cmd.Context(), key); err != nil {
+			exitWithError("error comparing file", err)
+		}
+	},
+}
+
+// sideinfo describes one side of a compared file
+type sideInfo struct {
+	Size     int64     `json:"size"`
+	Checksum string    `json:"sha256,omitempty"` // empty for objects described by their listing
+	ModTime  time.Time `json:"mod_time"`
+	ETag     string    `json:"etag,omitempty"`
+}
+
+// filediff is the comparison of a local file with its remote version
+type fileDiff struct {
+	RelativePath string    `json:"relative_path"`
+	Local        *sideInfo `json:"local,omitempty"`
+	Remote       *sideInfo `json:"remote,omitempty"`
+	Identical    bool      `json:"identical"`
+	Binary       bool      `json:"binary"`
+	MetadataOnly bool      `json:"metadata_only,omitempty"` // the object was not read, only its listing compared
+	Inserted     int       `json:"inserted"`
+	Deleted      int       `json:"deleted"`
+	Diff         string    `json:"diff,omitempty"`
+}
+
+// differ compares local files under a sync root with objects in a bucket
+type differ struct {
+	client  *aws.Client
+	bucket  string
+	prefix  string // key prefix of the synced objects
+	root    string
+	context int
+
+	// the local tree is scanned like push and pull scan it, so preserved links compare their
+	// targets and skipped links are missing
+	scanOptions fileutils.ScanOptions
+}
+
+// difffile compares a single file and prints its diff
+func (d *differ) diffFile(ctx context.Context, key string) error {
+	objectKey := sync.ObjectKey(d.prefix, key)
+	remoteFiles, err := d.client.ListObjects(ctx, d.bucket, objectKey)
+	if err != nil {
+		return fmt.Errorf("error listing remote objects: %w", err)
+	}
+
+	var remoteFile *fileutils.FileInfo
+	for i := range remoteFiles {
+		if remoteFiles[i].Path == objectKey {
+			remoteFile = &remoteFiles[i]
+			break
+		}
+	}
+
+	result, err := d.compare(ctx, key, remoteFile, true)
+	if err != nil {
+		return err
+	}
+
+	return renderer.Render(output.View{
+		Data: result,
+		Text: func(w io.Writer) {
+			printFileDiff(w, d.bucket, result)
+		},
+	})
+}
+
+// difftree compares every file below a directory and prints a summary line per differing file
+func (d *differ) diffTree(ctx context.Context, key string) error {
+	prefix := ""
+	if key != "." {
+		prefix = key + "/"
+	}
+
+	remoteFiles, err := d.client.ListObjects(ctx, d.bucket, listPrefix(d.prefix)+prefix)
+	if err != nil {
+		return fmt.Errorf("error listing remote objects: %w", err)
+	}
+	remoteByKey := make(map[string]fileutils.FileInfo, len(remoteFiles))
+	for _, file := range remoteFiles {
+		fileKey := strings.TrimPrefix(file.Path, listPrefix(d.prefix))
+		if sync.IsStateKey(fileKey) {
+			continue
+		}
+		remoteByKey[fileKey] = file
+	}
+
+	// the whole tree is scanned with the hash cache like push and pull; a subdirectory without it,
+	// since saving the cache after a partial scan would drop the entries of other files
+	manifestManager := sync.NewManifestManager(d.root)
+	localFiles := make(map[string]fileutils.FileInfo)
+	visit := func(file fileutils.FileInfo) {
+		localFiles[filepath.ToSlash(file.RelativePath)] = file
+	}
+	if key == "." {
+		if err := manifestManager.ScanLocal(ctx, d.root, d.scanOptions, visit); err != nil {
+			return fmt.Errorf("error scanning local directory: %w", err)
+		}
+	} else {
+		for file, err := range fileutils.ScanPathsSeq(ctx, d.root, []string{filepath.FromSlash(key)}, d.scanOptions) {
+			if err != nil {
+				return fmt.Errorf("error scanning local directory: %w", err)
+			}
+			visit(file)
+		}
+	}
+
+	lastManifest, err := manifestManager.LoadManifest()
+	if err != nil {
+		return fmt.Errorf("error loading manifest: %w", err)
+	}
+
+	keys := make([]string, 0, len(localFiles)+len(remoteByKey))
+	for fileKey := range localFiles {
+		keys = append(keys, fileKey)
+	}
+	for fileKey := range remoteByKey {
+		if _, ok := localFiles[fileKey]; !ok {
+			keys = append(keys, fileKey)
+		}
+	}
+	sort.Strings(keys)
+
+	results := make([]*fileDiff, 0)
+	for _, fileKey := range keys {
+		localFile, localExists := localFiles[fileKey]
+		remoteFile, remoteExists := remoteByKey[fileKey]
+
+		// files unchanged on both sides since the last sync need no download
+		if lastKnownFile, wasKnown := lastManifest.Files[filepath.FromSlash(fileKey)]; wasKnown && localExists && remoteExists &&
+			!sync.LocalChanged(localFile, lastKnownFile) && !sync.RemoteChanged(remoteFile, lastKnownFile) {
+			continue
+		}
+
+		var remote *fileutils.FileInfo
+		if remoteExists {
+			remote = &remoteFile
+		}
+		result, err := d.compare(ctx, fileKey, remote, false)
+		if err != nil {
+			return err
+		}
+		if !result.Identical {
+			results = append(results, result)
+		}
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, []string{diffState(result), result.RelativePath, diffSummary(result)})
+	}
+
+	return renderer.Render(output.View{
+		Data:    results,
+		Columns: []string{"state", "path", "changes"},
+		Rows:    rows,
+		Text: func(w io.Writer) {
+			if len(rows) == 0 {
+				fmt.Fprintln(w, "✅ no differences")
+				return
+			}
+			for _, row := range rows {
+				fmt.Fprintf(w, "%-12s %s  %s\n", row[0], row[1], row[2])
+			}
+			fmt.Fprintf(w, "%d files differ\n", len(rows))
+		},
+	})
+}
+
+// compare reads both sides of a file. the object is only read when its lines are needed: a
+// local file matching its etag is identical, and objects too large to diff, objects whose local
+// file is too large to diff and, unless withdiff is set, objects without a local file are
+// described by their listing.
+func (d *differ) compare(ctx context.Context, key string, remoteFile *fileutils.FileInfo, withDiff bool) (*fileDiff, error) {
+	result := &fileDiff{RelativePath: key}
+
+	localPath := filepath.Join(d.root, filepath.FromSlash(key))
+	localData, localInfo, err := d.readLocal(localPath)
+	if err != nil {
+		return nil, err
+	}
+	result.Local = localInfo
+
+	var remoteData []byte
+	if remoteFile != nil {
+		listed := &sideInfo{Size: remoteFile.Size, ModTime: remoteFile.ModTime, ETag: remoteFile.ETag}
+		switch {
+		case localInfo != nil && d.matchesETag(localPath, localInfo, localData, *remoteFile):
+			listed.Checksum = localInfo.Checksum
+			result.Remote = listed
+			result.Identical = true
+			return result, nil
+		case remoteFile.Size > diff.MaxTextSize || (localInfo != nil && localData == nil) || (localInfo == nil && !withDiff):
+			result.Remote = listed
+			result.MetadataOnly = true
+		default:
+			remoteData, result.Remote, err = d.readRemoteSide(ctx, *remoteFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if result.Local == nil && result.Remote == nil {
+		return nil, fmt.Errorf("%s: %w", key, aws.ErrNoSuchKey)
+	}
+	if result.MetadataOnly {
+		result.Binary = (localInfo != nil && localData == nil) || remoteFile.Size > diff.MaxTextSize
+		return result, nil
+	}
+	if result.Local != nil && result.Remote != nil && result.Local.Checksum == result.Remote.Checksum {
+		result.Identical = true
+		return result, nil
+	}
+
+	// compare line by line only when both present sides are small text files
+	tooLarge := (result.Local != nil && localData == nil) || (result.Remote != nil && remoteData == nil)
+	result.Binary = tooLarge || !diff.IsText(localData) || !diff.IsText(remoteData)
+	if result.Binary {
+		return result, nil
+	}
+
+	// texts too different to diff quickly are compared by metadata like large files
+	result.Inserted, result.Deleted, err = diff.Stats(remoteData, localData)
+	if errors.Is(err, diff.ErrTooDifferent) {
+		result.Binary = true
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if withDiff {
+		oldName, newName := "s3://"+d.bucket+"/"+key, path.Join("local", key)
+		if result.Remote == nil {
+			oldName = "/dev/null"
+		}
+		if result.Local == nil {
+			newName = "/dev/null"
+		}
+		if result.Diff, err = diff.Unified(oldName, newName, remoteData, localData, d.context); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// matchesetag reports whether a local file has the content of an object, going by its etag.
+// single-part etags of content already read are checked without reading the file again.
+func (d *differ) matchesETag(localPath string, local *sideInfo, localData []byte, remoteFile fileutils.FileInfo) bool {
+	if local.Size != remoteFile.Size || remoteFile.ETag == "" {
+		return false
+	}
+	if etag := strings.Trim(remoteFile.ETag, "\""); localData != nil && !strings.Contains(etag, "-") {
+		return fmt.Sprintf("%x", md5.Sum(localData)) == etag
+	}
+	var partSize int64
+	if d.client.Config != nil {
+		partSize = d.client.Config.Sync.ChunkSize
+	}
+	same, err := fileutils.MatchesETag(localPath, remoteFile.ETag, partSize)
+	return err == nil && same
+}
+
+// readremoteside reads an object into memory, hashing it and keeping its content if it is
+// small enough to diff. nothing is written locally. an object deleted since it was listed
+// returns nil info.
+func (d *differ) readRemoteSide(ctx context.Context, remoteFile fileutils.FileInfo) ([]byte, *sideInfo, error) {
+	content := newSideContent(remoteFile.Size)
+	transfer, err := d.client.ReadObject(ctx, d.bucket, remoteFile.Path, content)
+	if err != nil {
+		if errors.Is(err, aws.ErrNoSuchKey) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("error downloading %s: %w", remoteFile.Path, err)
+	}
+
+	info := &sideInfo{
+		Size:     transfer.Size,
+		Checksum: content.checksum(),
+		ModTime:  transfer.ModTime,
+		ETag:     remoteFile.ETag,
+	}
+	return content.data(transfer.Size), info, nil
+}
+
+// readlocal reads the local side of a file according to the symlink policy: a preserved link is
+// its target, as uploaded, and a skipped link is missing
+func (d *differ) readLocal(localPath string) ([]byte, *sideInfo, error) {
+	if policy := d.scanOptions.Symlinks; policy == fileutils.SymlinkPreserve || policy == fileutils.SymlinkSkip {
+		if info, err := os.Lstat(localPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if policy == fileutils.SymlinkSkip {
+				return nil, nil, nil
+			}
+			target, err := os.Readlink(localPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read symlink %s: %w", localPath, err)
+			}
+			return []byte(target), &sideInfo{
+				Size:     int64(len(target)),
+				Checksum: fileutils.LinkChecksum(target),
+				ModTime:  info.ModTime(),
+			}, nil
+		}
+	}
+	return readLocalSide(localPath)
+}
+
+// readlocalside hashes a file and returns its content if it is small enough to diff.
+// a missing file returns nil info.
+func readLocalSide(filePath string) ([]byte, *sideInfo, error) {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+
+	content := newSideContent(stat.Size())
+	read, err := io.Copy(content, file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	info := &sideInfo{
+		Size:     read,
+		Checksum: content.checksum(),
+		ModTime:  stat.ModTime(),
+	}
+	return content.data(read), info, nil
+}
+
+// sidecontent hashes the content of one side and keeps it while it fits the diff size limit
+type sideContent struct {
+	hash   hash.Hash
+	buffer bytes.Buffer
+	keep   bool
+}
+
+// newsidecontent prepares to read a side of the given expected size
+func newSideContent(size int64) *sideContent {
+	return &sideContent{hash: sha256.New(), keep: size <= diff.MaxTextSize}
+}
+
+func (c *sideContent) Write(p []byte) (int, error) {
+	c.hash.Write(p)
+	if c.keep {
+		if int64(c.buffer.Len()+len(p)) > diff.MaxTextSize {
+			c.keep = false
+			c.buffer = bytes.Buffer{}
+		} else {
+			c.buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// checksum returns the sha256 of the content written so far
+func (c *sideContent) checksum() string {
+	return fmt.Sprintf("%x", c.hash.Sum(nil))
+}
+
+// data returns the content if all size bytes were kept, nil if it is too large to diff
+func (c *sideContent) data(size int64) []byte {
+	if !c.keep || size > diff.MaxTextSize {
+		return nil
+	}
+	return c.buffer.Bytes()
+}
+
+// findsyncroot returns the nearest directory at or above path that contains a .s3sync directory.
+// without one, a directory path is its own root and a file's root is its parent.
+func findSyncRoot(path string) string {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if stat, err := os.Stat(filepath.Join(dir, ".s3sync")); err == nil && stat.IsDir() {
+			return dir
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		return path
+	}
+	return filepath.Dir(path)
+}
+
+// printfilediff prints the diff of a single file, or its metadata when it cannot be diffed
+func printFileDiff(w io.Writer, bucketName string, result *fileDiff) {
+	switch {
+	case result.Identical:
+		fmt.Fprintf(w, "✅ %s is identical to s3://%s/%s\n", result.RelativePath, bucketName, result.RelativePath)
+	case !result.Binary:
+		fmt.Fprint(w, result.Diff)
+	default:
+		kind := "files"
+		if result.Binary {
+			kind = "binary files"
+		}
+		fmt.Fprintf(w, "%s differ: %s\n", kind, result.RelativePath)
+		printSide(w, "local: ", result.Local)
+		printSide(w, "remote:", result.Remote)
+	}
+}
+
+// printside prints the metadata of one side of a binary diff
+func printSide(w io.Writer, label string, info *sideInfo) {
+	if info == nil {
+		fmt.Fprintf(w, "  %s (missing)\n", label)
+		return
+	}
+	digest := "sha256 " + info.Checksum
+	if info.Checksum == "" {
+		digest = "etag " + info.ETag
+	}
+	fmt.Fprintf(w, "  %s %d bytes, %s, modified %s\n",
+		label, info.Size, digest, info.ModTime.Format("2006-01-02 15:04:05"))
+}
+
+// diffstate names how a file differs between the two sides
+func diffState(result *fileDiff) string {
+	switch {
+	case result.Remote == nil:
+		return "local only"
+	case result.Local == nil:
+		return "remote only"
+	}
+	return "modified"
+}
+
+// diffsummary describes the size of a difference for tree output
+func diffSummary(result *fileDiff) string {
+	if result.Binary || result.MetadataOnly {
+		var sizes []string
+		for _, info := range []*sideInfo{result.Remote, result.Local} {
+			if info == nil {
+				sizes = append(sizes, "-")
+			} else {
+				sizes = append(sizes, fmt.Sprintf("%d", info.Size))
+			}
+		}
+		summary := strings.Join(sizes, " -> ") + " bytes"
+		if result.Binary {
+			summary = "binary, " + summary
+		}
+		return summary
+	}
+	return fmt.Sprintf("+%d -%d", result.Inserted, result.Deleted)
+}
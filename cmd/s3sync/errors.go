@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+// exit codes reported by s3sync
+//
+//	0   success, nothing to do (or changes applied without --detailed-exit-code)
+//	1   unclassified error
+//	2   success, changes applied (with --detailed-exit-code) or pending (status --exit-code)
+//	3   partial failure, some files could not be synced
+//	4   authentication or permission error
+//	5   bucket or object not found
+//	6   configuration error (invalid config, wrong region)
+//	64  invalid command line usage
+//	130 cancelled by signal
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitChanges   = 2
+	exitPartial   = 3
+	exitAuth      = 4
+	exitNotFound  = 5
+	exitConfig    = 6
+	exitUsage     = 64
+	exitCancelled = 130
+)
+
+// error output formats
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+var (
+	// errorformat selects how fatal errors are written to stderr
+	errorFormat = errorFormatText
+
+	// detailedexitcode makes successful runs that changed files exit with exitchanges
+	detailedExitCode bool
+
+	// changesapplied is set by commands that modified local or remote files
+	changesApplied bool
+)
+
+// errpartialfailure marks runs where some but not all files failed
+var errPartialFailure = errors.New("some files failed to sync")
+
+// filefailure records a single file that could not be synced
+type fileFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// partialfailureerror reports the files that failed during a sync run
+type partialFailureError struct {
+	Total    int
+	Failures []fileFailure
+}
+
+func (e *partialFailureError) Error() string {
+	return fmt.Sprintf("%d of %d files failed to sync", len(e.Failures), e.Total)
+}
+
+func (e *partialFailureError) Is(target error) bool { return target == errPartialFailure }
+
+// codederror attaches an explicit exit code to an error
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+
+func (e *codedError) Unwrap() error { return e.err }
+
+// configerror marks an error caused by missing or invalid configuration
+func configError(err error) error {
+	return &codedError{code: exitConfig, err: err}
+}
+
+// usageerror marks an error caused by invalid arguments or flags
+func usageError(format string, args ...interface{}) error {
+	return &codedError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+// exitcodefor maps an error to the exit code that best describes it
+func exitCodeFor(err error) int {
+	var coded *codedError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.Is(err, errPartialFailure):
+		return exitPartial
+	case errors.Is(err, aws.ErrAccessDenied), errors.Is(err, aws.ErrInvalidCredentials):
+		return exitAuth
+	case errors.Is(err, aws.ErrBucketNotFound), errors.Is(err, aws.ErrNoSuchKey):
+		return exitNotFound
+	case errors.Is(err, aws.ErrWrongRegion):
+		return exitConfig
+	}
+	return exitError
+}
+
+// errorkind returns a stable name for an exit code, used in json error reports
+func errorKind(code int) string {
+	switch code {
+	case exitPartial:
+		return "partial_failure"
+	case exitAuth:
+		return "auth"
+	case exitNotFound:
+		return "not_found"
+	case exitConfig:
+		return "config"
+	case exitUsage:
+		return "usage"
+	case exitCancelled:
+		return "cancelled"
+	}
+	return "error"
+}
+
+// errorreport is the json representation of a fatal error
+type errorReport struct {
+	Error    string        `json:"error"`
+	Kind     string        `json:"kind"`
+	ExitCode int           `json:"exit_code"`
+	Hint     string        `json:"hint,omitempty"`
+	Failures []fileFailure `json:"failures,omitempty"`
+}
+
+// exitcleanups stop what a command started in the background. os.exit skips deferred calls,
+// so exit runs them instead.
+var exitCleanups []func()
+
+// onexit registers a cleanup to run before the process exits
+func onExit(cleanup func()) {
+	exitCleanups = append(exitCleanups, cleanup)
+}
+
+// exit runs the registered cleanups, the last registered first, and ends the process
+func exit(code int) {
+	for i := len(exitCleanups) - 1; i >= 0; i-- {
+		exitCleanups[i]()
+	}
+	os.Exit(code)
+}
+
+// exitwitherror prints an error to stderr in the selected format and exits with its mapped code
+func exitWithError(prefix string, err error) {
+	code := exitCodeFor(err)
+	hint := hintFor(code, err)
+
+	message := err.Error()
+	if prefix != "" {
+		message = prefix + ": " + message
+	}
+
+	if errorFormat == errorFormatJSON {
+		report := errorReport{
+			Error:    message,
+			Kind:     errorKind(code),
+			ExitCode: code,
+			Hint:     hint,
+		}
+		var partial *partialFailureError
+		if errors.As(err, &partial) {
+			report.Failures = partial.Failures
+		}
+		data, _ := json.Marshal(report)
+		fmt.Fprintln(os.Stderr, string(data))
+		exit(code)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s\n", message)
+	var partial *partialFailureError
+	if errors.As(err, &partial) {
+		for _, failure := range partial.Failures {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", failure.Path, failure.Error)
+		}
+	}
+	if hint != "" {
+		fmt.Fprintf(os.Stderr, "hint: %s\n", hint)
+	}
+	exit(code)
+}
+
+// hintfor returns a short suggestion for resolving an error, or an empty string
+func hintFor(code int, err error) string {
+	if hint := aws.Hint(err); hint != "" {
+		return hint
+	}
+	if errors.Is(err, sync.ErrPlanStale) {
+		return "run 's3sync plan' again to review the current changes"
+	}
+	switch code {
+	case exitConfig:
+		return "run 's3sync setup' to configure credentials and defaults"
+	case exitUsage:
+		return "run 's3sync --help' for usage"
+	}
+	return ""
+}
+
+// validateerrorformat checks the --error-format flag value
+func validateErrorFormat(format string) error {
+	switch strings.ToLower(format) {
+	case errorFormatText, errorFormatJSON:
+		return nil
+	}
+	return usageError("invalid --error-format %q (expected text or json)", format)
+}
+
+// exitstatus returns the exit code for a successful run
+func exitStatus() int {
+	if detailedExitCode && changesApplied {
+		return exitChanges
+	}
+	return exitOK
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/hooks"
+	"github.com/jvkec/aws-s3sync/internal/metrics"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// hookscmd is a help topic documenting the hooks shared by push, pull, sync, apply and the daemon
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "commands and webhooks run around syncs",
+	Long: `push, pull, sync, apply and the daemon run the hooks configured under hooks. each hook runs a
+shell command with the json event on stdin, or posts it to a url:
+
+  hooks:
+    - name: purge-cdn
+      events: [post-sync]
+      url: https://example.com/purge
+      secret: shared-secret
+      retries: 2
+    - name: check-disk
+      events: [pre-sync]
+      command: ./check-disk.sh
+      timeout: 10s
+
+events are pre-sync, post-sync, on-error and per-file. a failing pre-sync hook aborts the run;
+other failures are only reported. with a secret, the event is signed with hmac-sha256 in the
+X-S3sync-Signature header or the S3SYNC_SIGNATURE variable. dry runs run no hooks.`,
+}
+
+// hookedsync runs a sync between the configured hooks and records it in the metrics. pre-sync
+// hooks run first and abort the run when one fails, per-file hooks follow every executed action
+// and post-sync or on-error hooks run last. failures of the other hooks are reported without
+// failing the run. dry runs run no hooks and are not recorded, nor are interrupted runs reported.
+func hookedSync(ctx context.Context, direction sync.Direction, localPath, bucketName string, cfg *config.Config, opts syncOptions, run func(opts syncOptions) error) error {
+	if opts.dryRun {
+		return run(opts)
+	}
+
+	h, err := hooks.New(cfg.Hooks)
+	if err != nil {
+		return configError(err)
+	}
+	base := hooks.Event{Job: opts.job, Direction: direction, Bucket: bucketName, Prefix: cfg.Sync.Prefix, LocalPath: localPath}
+
+	// the executed actions make up the summary handed to the last hooks
+	var executed []sync.SyncAction
+	var results []sync.ActionResult
+	inner := opts.observer
+	opts.observer = &syncObserver{
+		onStart: func(action sync.SyncAction) {
+			if inner != nil {
+				inner.onStart(action)
+			}
+			executed = append(executed, action)
+		},
+		onResult: func(result sync.ActionResult) {
+			if inner != nil {
+				inner.onResult(result)
+			}
+			results = append(results, result)
+			if h.Has(hooks.PerFile) {
+				event := base
+				event.Event = hooks.PerFile
+				event.Result = &result
+				reportHookError(h.Fire(ctx, event))
+			}
+		},
+	}
+
+	started := time.Now()
+	event := base
+	event.Event = hooks.PreSync
+	err = h.Fire(ctx, event)
+	if err != nil {
+		err = fmt.Errorf("%s aborted: %w", direction, err)
+	} else {
+		err = run(opts)
+	}
+	metrics.ObserveSync(metricsJob(opts.job, direction), time.Since(started), err)
+	if ctx.Err() != nil {
+		return err
+	}
+
+	summary := sync.Summarize(executed)
+	summary.AddResults(results)
+	event = base
+	event.Summary = &summary
+	event.Event = hooks.PostSync
+	if err != nil {
+		event.Event = hooks.OnError
+		event.Error = err.Error()
+	}
+	reportHookError(h.Fire(ctx, event))
+	return err
+}
+
+// reporthookerror prints failed hooks without stopping the sync
+func reportHookError(err error) {
+	if err != nil {
+		fmt.Fprintf(stderr, "⚠️  %v\n", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/hooks"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+func TestHookedSyncPreSync(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands run through sh")
+	}
+
+	tests := []struct {
+		name      string
+		preSync   string
+		wantRun   bool
+		wantEvent string // the last hook run after the sync
+	}{
+		{"passes", "true", true, hooks.PostSync},
+		{"aborts", "exit 3", false, hooks.OnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			last := filepath.Join(t.TempDir(), "last")
+			cfg := &config.Config{Hooks: []config.HookConfig{
+				{Events: []string{hooks.PreSync}, Command: test.preSync},
+				{Events: []string{hooks.PostSync, hooks.OnError}, Command: `printf %s "$S3SYNC_EVENT" > ` + last},
+			}}
+
+			ran := false
+			err := hookedSync(context.Background(), sync.DirectionPush, t.TempDir(), "bucket", cfg, syncOptions{}, func(syncOptions) error {
+				ran = true
+				return nil
+			})
+			if ran != test.wantRun {
+				t.Errorf("sync ran: %v, want %v", ran, test.wantRun)
+			}
+			if aborted := err != nil && strings.Contains(err.Error(), "push aborted"); aborted == test.wantRun {
+				t.Errorf("error %v", err)
+			}
+			if data, err := os.ReadFile(last); err != nil || string(data) != test.wantEvent {
+				t.Errorf("last hook ran for %q (%v), want %s", data, err, test.wantEvent)
+			}
+		})
+	}
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jvkec/aws-s3sync/internal/aws"
 	"github.com/jvkec/aws-s3sync/internal/config"
 	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/output"
 	"github.com/jvkec/aws-s3sync/internal/sync"
 	"github.com/spf13/cobra"
 )
@@ -32,8 +37,7 @@ var setupCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		configManager := config.NewConfigManager()
 		if err := configManager.SetupWizard(); err != nil {
-			fmt.Printf("error during setup: %v\n", err)
-			os.Exit(1)
+			exitWithError("error during setup", err)
 		}
 	},
 }
@@ -52,20 +56,46 @@ var configShowCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
-		}
-
-		fmt.Printf("configuration file: %s\n", configManager.GetConfigPath())
-		fmt.Printf("aws region: %s\n", cfg.AWS.Region)
-		if cfg.AWS.Profile != "" {
-			fmt.Printf("aws profile: %s\n", cfg.AWS.Profile)
-		} else {
-			fmt.Printf("aws access key: %s\n", maskCredential(cfg.AWS.AccessKeyID))
-		}
-		fmt.Printf("default bucket: %s\n", cfg.Sync.DefaultBucket)
-		fmt.Printf("max retries: %d\n", cfg.Sync.MaxRetries)
-		fmt.Printf("chunk size: %d mb\n", cfg.Sync.ChunkSize/(1024*1024))
+			exitWithError("error loading config", configError(err))
+		}
+
+		view := configView{
+			ConfigFile:    configManager.GetConfigPath(),
+			Region:        cfg.AWS.Region,
+			Profile:       cfg.AWS.Profile,
+			DefaultBucket: cfg.Sync.DefaultBucket,
+			MaxRetries:    cfg.Sync.MaxRetries,
+			ChunkSize:     cfg.Sync.ChunkSize,
+		}
+		if cfg.AWS.Profile == "" {
+			view.AccessKey = maskCredential(cfg.AWS.AccessKeyID)
+		}
+
+		render(output.View{
+			Data:    view,
+			Columns: []string{"setting", "value"},
+			Rows: [][]string{
+				{"config_file", view.ConfigFile},
+				{"region", view.Region},
+				{"profile", view.Profile},
+				{"access_key", view.AccessKey},
+				{"default_bucket", view.DefaultBucket},
+				{"max_retries", fmt.Sprint(view.MaxRetries)},
+				{"chunk_size", fmt.Sprint(view.ChunkSize)},
+			},
+			Text: func(w io.Writer) {
+				fmt.Fprintf(w, "configuration file: %s\n", view.ConfigFile)
+				fmt.Fprintf(w, "aws region: %s\n", view.Region)
+				if view.Profile != "" {
+					fmt.Fprintf(w, "aws profile: %s\n", view.Profile)
+				} else {
+					fmt.Fprintf(w, "aws access key: %s\n", view.AccessKey)
+				}
+				fmt.Fprintf(w, "default bucket: %s\n", view.DefaultBucket)
+				fmt.Fprintf(w, "max retries: %d\n", view.MaxRetries)
+				fmt.Fprintf(w, "chunk size: %d mb\n", view.ChunkSize/(1024*1024))
+			},
+		})
 	},
 }
 
@@ -77,29 +107,24 @@ var testConnectionCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
+			exitWithError("error loading config", configError(err))
 		}
 
 		if err := cfg.ValidateConfig(); err != nil {
-			fmt.Printf("invalid configuration: %v\n", err)
-			fmt.Println("run 's3sync setup' to configure credentials")
-			os.Exit(1)
+			exitWithError("invalid configuration", configError(err))
 		}
 
 		client, err := aws.NewClient(cfg)
 		if err != nil {
-			fmt.Printf("error creating aws client: %v\n", err)
-			os.Exit(1)
+			exitWithError("error creating aws client", err)
 		}
 
-		ctx := context.Background()
+		ctx := cmd.Context()
 		if err := client.TestConnection(ctx); err != nil {
-			fmt.Printf("connection failed: %v\n", err)
-			os.Exit(1)
+			exitWithError("connection failed", err)
 		}
 
-		fmt.Println("✅ aws connection successful!")
+		renderStatus(statusRecord{Status: "ok", Message: "aws connection successful"}, "✅ aws connection successful!")
 	},
 }
 
@@ -111,27 +136,38 @@ var listBucketsCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
+			exitWithError("error loading config", configError(err))
 		}
 
 		client, err := aws.NewClient(cfg)
 		if err != nil {
-			fmt.Printf("error creating aws client: %v\n", err)
-			os.Exit(1)
+			exitWithError("error creating aws client", err)
 		}
 
-		ctx := context.Background()
+		ctx := cmd.Context()
 		buckets, err := client.ListBuckets(ctx)
 		if err != nil {
-			fmt.Printf("error listing buckets: %v\n", err)
-			os.Exit(1)
+			exitWithError("error listing buckets", err)
 		}
 
-		fmt.Printf("accessible s3 buckets (%d):\n", len(buckets))
+		records := make([]bucketRecord, 0, len(buckets))
+		rows := make([][]string, 0, len(buckets))
 		for _, bucket := range buckets {
-			fmt.Printf("  - %s\n", bucket)
-		}
+			records = append(records, bucketRecord{Name: bucket})
+			rows = append(rows, []string{bucket})
+		}
+
+		render(output.View{
+			Data:    records,
+			Columns: []string{"name"},
+			Rows:    rows,
+			Text: func(w io.Writer) {
+				fmt.Fprintf(w, "accessible s3 buckets (%d):\n", len(buckets))
+				for _, bucket := range buckets {
+					fmt.Fprintf(w, "  - %s\n", bucket)
+				}
+			},
+		})
 	},
 }
 
@@ -146,24 +182,23 @@ var createBucketCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
+			exitWithError("error loading config", configError(err))
 		}
 
 		client, err := aws.NewClient(cfg)
 		if err != nil {
-			fmt.Printf("error creating aws client: %v\n", err)
-			os.Exit(1)
+			exitWithError("error creating aws client", err)
 		}
 
-		ctx := context.Background()
-		fmt.Printf("creating bucket: %s\n", bucketName)
+		ctx := cmd.Context()
+		renderer.Printf("creating bucket: %s\n", bucketName)
 		if err := client.CreateBucket(ctx, bucketName); err != nil {
-			fmt.Printf("error creating bucket: %v\n", err)
-			os.Exit(1)
+			exitWithError("error creating bucket", err)
 		}
 
-		fmt.Printf("✅ bucket created successfully: %s\n", bucketName)
+		changesApplied = true
+		renderStatus(statusRecord{Status: "ok", Message: "bucket created", Bucket: bucketName},
+			fmt.Sprintf("✅ bucket created successfully: %s", bucketName))
 	},
 }
 
@@ -186,24 +221,23 @@ var uploadCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
+			exitWithError("error loading config", configError(err))
 		}
 
 		client, err := aws.NewClient(cfg)
 		if err != nil {
-			fmt.Printf("error creating aws client: %v\n", err)
-			os.Exit(1)
+			exitWithError("error creating aws client", err)
 		}
 
-		ctx := context.Background()
-		fmt.Printf("uploading %s to s3://%s/%s\n", localFile, bucketName, s3Key)
-		if err := client.UploadFile(ctx, localFile, bucketName, s3Key); err != nil {
-			fmt.Printf("error uploading file: %v\n", err)
-			os.Exit(1)
+		ctx := cmd.Context()
+		renderer.Printf("uploading %s to s3://%s/%s\n", localFile, bucketName, s3Key)
+		if _, err := client.UploadFile(ctx, localFile, bucketName, s3Key); err != nil {
+			exitWithError("error uploading file", err)
 		}
 
-		fmt.Println("✅ file uploaded successfully!")
+		changesApplied = true
+		renderStatus(statusRecord{Status: "ok", Message: "file uploaded", Bucket: bucketName, Key: s3Key, LocalPath: localFile},
+			"✅ file uploaded successfully!")
 	},
 }
 
@@ -231,32 +265,37 @@ var downloadCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
+			exitWithError("error loading config", configError(err))
 		}
 
 		client, err := aws.NewClient(cfg)
 		if err != nil {
-			fmt.Printf("error creating aws client: %v\n", err)
-			os.Exit(1)
+			exitWithError("error creating aws client", err)
 		}
 
-		ctx := context.Background()
-		fmt.Printf("downloading s3://%s/%s to %s\n", bucketName, s3Key, localPath)
-		if err := client.DownloadFile(ctx, bucketName, s3Key, localPath); err != nil {
-			fmt.Printf("error downloading file: %v\n", err)
-			os.Exit(1)
+		ctx := cmd.Context()
+		renderer.Printf("downloading s3://%s/%s to %s\n", bucketName, s3Key, localPath)
+		if _, err := client.DownloadFile(ctx, bucketName, s3Key, localPath); err != nil {
+			exitWithError("error downloading file", err)
 		}
 
-		fmt.Println("✅ file downloaded successfully!")
+		changesApplied = true
+		renderStatus(statusRecord{Status: "ok", Message: "file downloaded", Bucket: bucketName, Key: s3Key, LocalPath: localPath},
+			"✅ file downloaded successfully!")
 	},
 }
 
 var pushCmd = &cobra.Command{
 	Use:   "push [local-path] [bucket-name]",
 	Short: "push local files to s3",
-	Long:  `pushes files from a local directory to an s3 bucket.`,
-	Args:  cobra.RangeArgs(1, 2),
+	Long: `pushes files from a local directory to an s3 bucket.
+
+with --watch, push keeps running after the first push and pushes files as they change. only the
+changed paths are rescanned, and deleting a synced file deletes its object unless the object was
+changed remotely.
+
+runs the hooks configured under hooks; see 's3sync help hooks'.`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		localPath := args[0]
 		bucketName := ""
@@ -264,8 +303,10 @@ var pushCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
 		}
 
 		if len(args) == 2 {
@@ -273,17 +314,30 @@ var pushCmd = &cobra.Command{
 		} else if cfg.Sync.DefaultBucket != "" {
 			bucketName = cfg.Sync.DefaultBucket
 		} else {
-			fmt.Println("error: bucket name required (no default bucket configured)")
-			fmt.Println("usage: s3sync push [local-path] [bucket-name]")
-			fmt.Println("or run 's3sync setup' to configure a default bucket")
-			os.Exit(1)
+			exitWithError("", usageError("bucket name required (no default bucket configured)\n"+
+				"usage: s3sync push [local-path] [bucket-name]\n"+
+				"or run 's3sync setup' to configure a default bucket"))
+		}
+
+		opts, err := syncOptionsFromFlags(cmd)
+		if err != nil {
+			exitWithError("", err)
 		}
+		defer startMetricsFromFlags(cmd)()
 
-		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if watch, _ := cmd.Flags().GetBool("watch"); watch {
+			if opts.dryRun || opts.interactive {
+				exitWithError("", usageError("--watch cannot be combined with --dry-run or --interactive"))
+			}
+			debounce, _ := cmd.Flags().GetDuration("debounce")
+			if err := performWatch(cmd.Context(), localPath, bucketName, cfg, opts, debounce); err != nil {
+				exitWithError("error during push", err)
+			}
+			return
+		}
 
-		if err := performPush(localPath, bucketName, cfg, dryRun); err != nil {
-			fmt.Printf("error during push: %v\n", err)
-			os.Exit(1)
+		if err := performPush(cmd.Context(), localPath, bucketName, cfg, opts); err != nil {
+			exitWithError("error during push", err)
 		}
 	},
 }
@@ -291,8 +345,9 @@ var pushCmd = &cobra.Command{
 var pullCmd = &cobra.Command{
 	Use:   "pull [bucket-name] [local-path]",
 	Short: "pull remote files from s3",
-	Long:  `pulls files from an s3 bucket to a local directory.`,
-	Args:  cobra.RangeArgs(1, 2),
+	Long: `pulls files from an s3 bucket to a local directory.
+runs the hooks configured under hooks; see 's3sync help hooks'.`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		bucketName := args[0]
 		localPath := "./"
@@ -304,15 +359,62 @@ var pullCmd = &cobra.Command{
 		configManager := config.NewConfigManager()
 		cfg, err := configManager.LoadConfig()
 		if err != nil {
-			fmt.Printf("error loading config: %v\n", err)
-			os.Exit(1)
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
 		}
 
-		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		opts, err := syncOptionsFromFlags(cmd)
+		if err != nil {
+			exitWithError("", err)
+		}
+		defer startMetricsFromFlags(cmd)()
 
-		if err := performPull(bucketName, localPath, cfg, dryRun); err != nil {
-			fmt.Printf("error during pull: %v\n", err)
-			os.Exit(1)
+		if err := performPull(cmd.Context(), bucketName, localPath, cfg, opts); err != nil {
+			exitWithError("error during pull", err)
+		}
+	},
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync [local-path] [bucket-name]",
+	Short: "sync local files and s3 in both directions",
+	Long: `uploads local changes and downloads remote changes in one run, resolving files changed on
+both sides according to --conflict.
+runs the hooks configured under hooks; see 's3sync help hooks'.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		localPath := args[0]
+		bucketName := ""
+
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
+		}
+
+		if len(args) == 2 {
+			bucketName = args[1]
+		} else if cfg.Sync.DefaultBucket != "" {
+			bucketName = cfg.Sync.DefaultBucket
+		} else {
+			exitWithError("", usageError("bucket name required (no default bucket configured)\n"+
+				"usage: s3sync sync [local-path] [bucket-name]\n"+
+				"or run 's3sync setup' to configure a default bucket"))
+		}
+
+		opts, err := syncOptionsFromFlags(cmd)
+		if err != nil {
+			exitWithError("", err)
+		}
+		defer startMetricsFromFlags(cmd)()
+
+		if err := performBidirectionalSync(cmd.Context(), localPath, bucketName, cfg, opts); err != nil {
+			exitWithError("error during sync", err)
 		}
 	},
 }
@@ -323,20 +425,47 @@ var scanCmd = &cobra.Command{
 	Long:  `scans a local directory and displays a list of files that would be synchronized.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
-			fmt.Println("error: missing local_path argument")
-			os.Exit(1)
+			exitWithError("", usageError("missing local_path argument"))
 		}
 		localPath := args[0]
-		files, err := fileutils.ScanDirectoryWithInfo(localPath)
+
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
+		}
+		scanOptions, err := scanOptionsFromConfig(cfg)
 		if err != nil {
-			fmt.Printf("error scanning directory: %v\n", err)
-			os.Exit(1)
+			exitWithError("", err)
 		}
 
-		fmt.Printf("files in %s (%d files):\n", localPath, len(files))
-		for _, file := range files {
-			fmt.Printf("  %s (%d bytes, %s)\n", file.RelativePath, file.Size, file.ModTime.Format("2006-01-02 15:04:05"))
+		files := make([]fileutils.FileInfo, 0)
+		err = sync.NewManifestManager(localPath).ScanLocal(cmd.Context(), localPath, scanOptions, func(file fileutils.FileInfo) {
+			files = append(files, file)
+		})
+		if err != nil {
+			exitWithError("error scanning directory", err)
 		}
+
+		rows := make([][]string, 0, len(files))
+		for _, file := range files {
+			rows = append(rows, []string{file.RelativePath, fmt.Sprint(file.Size), file.ModTime.Format("2006-01-02 15:04:05"), file.Checksum})
+		}
+
+		render(output.View{
+			Data:    files,
+			Columns: []string{"path", "size", "modified", "checksum"},
+			Rows:    rows,
+			Text: func(w io.Writer) {
+				fmt.Fprintf(w, "files in %s (%d files):\n", localPath, len(files))
+				for _, file := range files {
+					fmt.Fprintf(w, "  %s (%d bytes, %s)\n", file.RelativePath, file.Size, file.ModTime.Format("2006-01-02 15:04:05"))
+				}
+			},
+		})
 	},
 }
 
@@ -352,215 +481,100 @@ func maskCredential(credential string) string {
 	return credential[:4] + strings.Repeat("*", len(credential)-4)
 }
 
-func performPush(localPath, bucketName string, cfg *config.Config, dryRun bool) error {
-	// create aws client
-	client, err := aws.NewClient(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to create aws client: %w", err)
-	}
-
-	ctx := context.Background()
-
-	// check if bucket exists
-	exists, err := client.BucketExists(ctx, bucketName)
-	if err != nil {
-		return fmt.Errorf("error checking bucket: %w", err)
-	}
-	if !exists {
-		return fmt.Errorf("bucket %s does not exist or is not accessible", bucketName)
-	}
-
-	// create manifest manager
-	manifestManager := sync.NewManifestManager(localPath)
-
-	// load last known manifest
-	lastManifest, err := manifestManager.LoadManifest()
-	if err != nil {
-		return fmt.Errorf("error loading manifest: %w", err)
-	}
-
-	// build current local manifest
-	localManifest, err := manifestManager.BuildLocalManifest(localPath)
-	if err != nil {
-		return fmt.Errorf("error scanning local directory: %w", err)
-	}
-	localManifest.Bucket = bucketName
-
-	// get remote manifest by listing s3 objects
-	remoteFiles, err := client.ListObjects(ctx, bucketName, "")
-	if err != nil {
-		return fmt.Errorf("error listing remote objects: %w", err)
-	}
-
-	remoteManifest := &sync.Manifest{
-		Files:  make(map[string]fileutils.FileInfo),
-		Bucket: bucketName,
-	}
-	for _, file := range remoteFiles {
-		remoteManifest.Files[file.RelativePath] = file
-	}
-
-	// compute sync actions
-	actions := sync.ComputeSyncActions(localManifest, remoteManifest, lastManifest)
-
-	// display actions
-	uploadCount := 0
-	skipCount := 0
-	for _, action := range actions {
-		switch action.Operation {
-		case sync.SyncOpUpload:
-			uploadCount++
-			if dryRun {
-				fmt.Printf("[dry-run] would upload: %s (%s)\n", action.RelativePath, action.Reason)
-			}
-		case sync.SyncOpSkip:
-			skipCount++
+func init() {
+	// global error reporting flags
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", errorFormatText, "format for error output on stderr (text, json)")
+	rootCmd.PersistentFlags().BoolVar(&detailedExitCode, "detailed-exit-code", false, "exit with code 2 when files were changed")
+	rootCmd.PersistentFlags().String("output", string(output.FormatPlain), "output format (plain, table, json, ndjson)")
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if err := validateErrorFormat(errorFormat); err != nil {
+			errorFormat = errorFormatText
+			exitWithError("", err)
+		}
+		errorFormat = strings.ToLower(errorFormat)
+
+		outputName, _ := cmd.Flags().GetString("output")
+		format, err := output.ParseFormat(outputName)
+		if err != nil {
+			exitWithError("", usageError("%v", err))
 		}
-	}
+		renderer = output.NewRenderer(stdout, format)
 
-	fmt.Printf("📦 push summary: %d files to upload, %d files to skip\n", uploadCount, skipCount)
-
-	if dryRun {
-		fmt.Println("dry-run mode: no files were actually uploaded")
-		return nil
-	}
-
-	if uploadCount == 0 {
-		fmt.Println("✅ everything up to date!")
-		return nil
-	}
-
-	// perform uploads
-	for _, action := range actions {
-		if action.Operation == sync.SyncOpUpload {
-			fmt.Printf("⬆️  uploading %s...\n", action.RelativePath)
-			localFilePath := filepath.Join(localPath, action.RelativePath)
-			if err := client.UploadFile(ctx, localFilePath, bucketName, action.RelativePath); err != nil {
-				return fmt.Errorf("error uploading %s: %w", action.RelativePath, err)
-			}
+		// structured output implies structured errors unless asked otherwise
+		if renderer.Structured() && !cmd.Flags().Changed("error-format") {
+			errorFormat = errorFormatJSON
 		}
 	}
+	rootCmd.SilenceErrors = true
 
-	// save updated manifest
-	if err := manifestManager.SaveManifest(localManifest); err != nil {
-		return fmt.Errorf("error saving manifest: %w", err)
+	// dry-run and interactive review flags for push, pull and sync
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd, syncCmd} {
+		cmd.Flags().Bool("dry-run", false, "show what would be done without actually doing it")
+		cmd.Flags().BoolP("interactive", "i", false, "review each action before it runs: accept, skip, show a diff or pick a conflict side")
 	}
 
-	fmt.Printf("✅ synced %d files to s3 bucket %s\n", uploadCount, bucketName)
-	return nil
-}
-
-func performPull(bucketName, localPath string, cfg *config.Config, dryRun bool) error {
-	// create aws client
-	client, err := aws.NewClient(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to create aws client: %w", err)
+	// conflict policy for files changed on both sides
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd, syncCmd, planCmd} {
+		cmd.Flags().String("conflict", string(sync.ConflictNewer), "resolve files changed on both sides: newer, local, remote, keep-both, skip or prompt (push and pull skip conflicts resolved for the other side; keep-both needs sync)")
 	}
 
-	ctx := context.Background()
-
-	// check if bucket exists
-	exists, err := client.BucketExists(ctx, bucketName)
-	if err != nil {
-		return fmt.Errorf("error checking bucket: %w", err)
-	}
-	if !exists {
-		return fmt.Errorf("bucket %s does not exist or is not accessible", bucketName)
+	// permission, ownership, symlink and checksum handling for commands that scan the local tree
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd, syncCmd, planCmd, applyCmd, statusCmd, diffCmd, scanCmd} {
+		cmd.Flags().Bool("preserve", false, "store and restore permissions, ownership and symlink targets in object metadata")
+		cmd.Flags().String("symlinks", "", "symlink handling: follow, preserve or skip (default from config, else follow)")
+		cmd.Flags().Bool("checksum", false, "hash every file instead of reusing checksums of files with unchanged inode, size and mtime")
 	}
 
-	// ensure local directory exists
-	if err := fileutils.CreateDirIfNotExists(localPath); err != nil {
-		return fmt.Errorf("error creating local directory: %w", err)
+	// shared state object for clients syncing the same bucket
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd, syncCmd, planCmd, applyCmd, statusCmd} {
+		cmd.Flags().Bool("remote-state", false, "track file versions and deletions in a shared .s3sync/state object in the bucket (the bucket is only listed when the state changed since the last run, so every client writing the prefix should use it)")
 	}
 
-	// create manifest manager
-	manifestManager := sync.NewManifestManager(localPath)
-
-	// load last known manifest
-	lastManifest, err := manifestManager.LoadManifest()
-	if err != nil {
-		return fmt.Errorf("error loading manifest: %w", err)
+	// key prefix of the synced objects; saved plans carry their own
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd, syncCmd, planCmd, statusCmd} {
+		cmd.Flags().String("prefix", "", "sync with the objects below this key prefix instead of the whole bucket")
 	}
 
-	// build current local manifest
-	localManifest, err := manifestManager.BuildLocalManifest(localPath)
-	if err != nil {
-		return fmt.Errorf("error scanning local directory: %w", err)
+	// delta uploads for commands that upload
+	for _, cmd := range []*cobra.Command{pushCmd, syncCmd, applyCmd} {
+		cmd.Flags().Bool("delta", false, "upload large files as multipart deltas, copying parts unchanged since the last sync")
 	}
 
-	// get remote manifest by listing s3 objects
-	remoteFiles, err := client.ListObjects(ctx, bucketName, "")
-	if err != nil {
-		return fmt.Errorf("error listing remote objects: %w", err)
-	}
+	// continuous push
+	pushCmd.Flags().Bool("watch", false, "keep running and push changed files as they change")
+	pushCmd.Flags().Duration("debounce", 500*time.Millisecond, "with --watch, wait this long after the last change before pushing")
 
-	remoteManifest := &sync.Manifest{
-		Files:  make(map[string]fileutils.FileInfo),
-		Bucket: bucketName,
-	}
-	for _, file := range remoteFiles {
-		remoteManifest.Files[file.RelativePath] = file
-	}
+	// daemon flags
+	daemonCmd.Flags().Bool("once", false, "run every job once and exit instead of following the schedules")
 
-	// compute sync actions
-	actions := sync.ComputeSyncActions(localManifest, remoteManifest, lastManifest)
-
-	// display actions
-	downloadCount := 0
-	skipCount := 0
-	for _, action := range actions {
-		switch action.Operation {
-		case sync.SyncOpDownload:
-			downloadCount++
-			if dryRun {
-				fmt.Printf("[dry-run] would download: %s (%s)\n", action.RelativePath, action.Reason)
-			}
-		case sync.SyncOpSkip:
-			skipCount++
-		}
+	// prometheus metrics for long-running commands
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd, syncCmd, daemonCmd} {
+		cmd.Flags().String("metrics-addr", "", "serve prometheus metrics on http://<addr>/metrics while running, e.g. localhost:9464")
 	}
 
-	fmt.Printf("📦 pull summary: %d files to download, %d files to skip\n", downloadCount, skipCount)
+	// control api address for ctl
+	ctlCmd.PersistentFlags().String("control", "", "control api address of the daemon (default from config)")
 
-	if dryRun {
-		fmt.Println("dry-run mode: no files were actually downloaded")
-		return nil
-	}
+	// plan output and direction flags
+	planCmd.Flags().StringP("out", "o", "", "save the plan to this file for 's3sync apply'")
+	planCmd.Flags().String("direction", string(sync.DirectionPush), "sync direction (push, pull, sync)")
 
-	if downloadCount == 0 {
-		fmt.Println("✅ everything up to date!")
-		return nil
-	}
+	// status output flags
+	statusCmd.Flags().BoolP("short", "s", false, "show porcelain output: two status letters (local, remote) and the path")
+	statusCmd.Flags().Bool("exit-code", false, "exit with code 2 when there are pending changes")
 
-	// perform downloads
-	for _, action := range actions {
-		if action.Operation == sync.SyncOpDownload {
-			fmt.Printf("⬇️  downloading %s...\n", action.RelativePath)
-			localFilePath := filepath.Join(localPath, action.RelativePath)
-			if err := client.DownloadFile(ctx, bucketName, action.RelativePath, localFilePath); err != nil {
-				return fmt.Errorf("error downloading %s: %w", action.RelativePath, err)
-			}
-		}
-	}
-
-	// save updated manifest
-	remoteManifest.Bucket = bucketName
-	if err := manifestManager.SaveManifest(remoteManifest); err != nil {
-		return fmt.Errorf("error saving manifest: %w", err)
-	}
-
-	fmt.Printf("✅ synced %d files from s3 bucket %s\n", downloadCount, bucketName)
-	return nil
-}
-
-func init() {
-	// add dry-run flag to push and pull commands
-	pushCmd.Flags().Bool("dry-run", false, "show what would be done without actually doing it")
-	pullCmd.Flags().Bool("dry-run", false, "show what would be done without actually doing it")
+	// diff flags
+	diffCmd.Flags().IntP("context", "U", 3, "number of context lines in unified diffs")
 
 	// add subcommands to config
 	configCmd.AddCommand(configShowCmd)
 
+	// add subcommands to ctl
+	ctlCmd.AddCommand(ctlStatusCmd, ctlTriggerCmd, ctlPauseCmd, ctlResumeCmd, ctlReloadCmd)
+
+	// add subcommands to manifest
+	manifestCmd.AddCommand(manifestShowCmd, manifestUpgradeCmd, manifestVerifyCmd, manifestRebuildCmd)
+
 	// add all commands to root
 	rootCmd.AddCommand(
 		setupCmd,
@@ -572,13 +586,29 @@ func init() {
 		downloadCmd,
 		pushCmd,
 		pullCmd,
+		syncCmd,
 		scanCmd,
+		planCmd,
+		applyCmd,
+		statusCmd,
+		diffCmd,
+		manifestCmd,
+		daemonCmd,
+		ctlCmd,
+		hooksCmd,
 	)
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// cancel the running command on interrupt so it can stop cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		stop()
+		// commands report their own errors, so anything left is a usage error
+		exitWithError("", &codedError{code: exitUsage, err: err})
 	}
+
+	stop()
+	exit(exitStatus())
 }
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/output"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "inspect and repair the sync manifest",
+	Long:  `inspect, upgrade, verify and rebuild the .s3sync manifest of a synced directory.`,
+}
+
+var manifestShowCmd = &cobra.Command{
+	Use:   "show [local-path]",
+	Short: "show the sync manifest",
+	Long:  `prints the schema version, bucket, last sync time and recorded files of a manifest.`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		localPath := manifestPath(args)
+
+		manifest, err := sync.NewManifestManager(localPath).LoadManifest()
+		if err != nil {
+			exitWithError("error loading manifest", err)
+		}
+
+		view := manifestView{
+			Version:  manifest.Version,
+			Bucket:   manifest.Bucket,
+			Prefix:   manifest.Prefix,
+			LastSync: manifest.LastSync,
+			Files:    manifest.SortedFiles(),
+		}
+		rows := make([][]string, 0, len(view.Files))
+		for _, file := range view.Files {
+			rows = append(rows, []string{file.RelativePath, fmt.Sprint(file.Size), file.Checksum, file.ETag})
+		}
+
+		render(output.View{
+			Data:    view,
+			Records: filesAsRecords(view.Files),
+			Columns: []string{"path", "size", "checksum", "etag"},
+			Rows:    rows,
+			Text: func(w io.Writer) {
+				fmt.Fprintf(w, "manifest version: %d\n", view.Version)
+				fmt.Fprintf(w, "bucket: %s\n", view.Bucket)
+				if view.LastSync.IsZero() {
+					fmt.Fprintln(w, "last sync: never")
+				} else {
+					fmt.Fprintf(w, "last sync: %s\n", view.LastSync.Format("2006-01-02 15:04:05"))
+				}
+				fmt.Fprintf(w, "files (%d):\n", len(view.Files))
+				for _, file := range view.Files {
+					fmt.Fprintf(w, "  %s (%d bytes, sha256 %s, etag %s)\n", file.RelativePath, file.Size, shortChecksum(file.Checksum), file.ETag)
+				}
+			},
+		})
+	},
+}
+
+var manifestUpgradeCmd = &cobra.Command{
+	Use:   "upgrade [local-path]",
+	Short: "migrate the sync manifest to the current schema version",
+	Long: `moves a manifest.json written by older versions into the current store and applies the
+schema migrations needed to reach the current version. other commands migrate in memory; this
+writes the result back immediately.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		localPath := manifestPath(args)
+
+		from, applied, err := sync.NewManifestManager(localPath).UpgradeManifest()
+		if err != nil {
+			exitWithError("error upgrading manifest", err)
+		}
+
+		if len(applied) == 0 {
+			renderStatus(statusRecord{Status: "ok", Message: fmt.Sprintf("manifest already at version %d", from), LocalPath: localPath},
+				fmt.Sprintf("✅ manifest already at version %d", from))
+			return
+		}
+
+		changesApplied = true
+		message := fmt.Sprintf("manifest upgraded from version %d to %d", from, sync.ManifestVersion)
+		renderStatus(statusRecord{Status: "ok", Message: message, LocalPath: localPath},
+			"✅ "+message+"\n  "+strings.Join(applied, "\n  "))
+	},
+}
+
+var manifestVerifyCmd = &cobra.Command{
+	Use:   "verify [local-path]",
+	Short: "check the sync manifest for inconsistent entries",
+	Long: `loads the manifest and reports entries that cannot be right: paths outside the sync root,
+keys that do not match their entry, invalid checksums and entries without any checksum. exits with an error
+when problems are found; 's3sync manifest rebuild' reconstructs a damaged manifest.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		localPath := manifestPath(args)
+
+		manifest, err := sync.NewManifestManager(localPath).LoadManifest()
+		if err != nil {
+			exitWithError("error loading manifest", err)
+		}
+
+		report := manifestVerifyReport{
+			Version:  manifest.Version,
+			Files:    len(manifest.Files),
+			Problems: sync.VerifyManifest(manifest),
+		}
+
+		rows := make([][]string, 0, len(report.Problems))
+		for _, problem := range report.Problems {
+			rows = append(rows, []string{problem.RelativePath, problem.Problem})
+		}
+
+		render(output.View{
+			Data:    report,
+			Columns: []string{"path", "problem"},
+			Rows:    rows,
+			Text: func(w io.Writer) {
+				if len(report.Problems) == 0 {
+					fmt.Fprintf(w, "✅ manifest ok (version %d, %d files)\n", report.Version, report.Files)
+					return
+				}
+				for _, problem := range report.Problems {
+					if problem.RelativePath == "" {
+						fmt.Fprintf(w, "❌ %s\n", problem.Problem)
+					} else {
+						fmt.Fprintf(w, "❌ %s: %s\n", problem.RelativePath, problem.Problem)
+					}
+				}
+			},
+		})
+
+		if len(report.Problems) > 0 {
+			exitWithError("", fmt.Errorf("manifest verification found %d problem(s)", len(report.Problems)))
+		}
+	},
+}
+
+var manifestRebuildCmd = &cobra.Command{
+	Use:   "rebuild [local-path] [bucket-name]",
+	Short: "reconstruct the sync manifest from a local scan and a remote listing",
+	Long: `scans the local directory, lists the bucket and records every file whose local content
+matches its object's etag as synced. files that differ are left out and are resolved by the
+next sync like files seen for the first time. the previous manifest is kept as a .bak file.
+the bucket defaults to the one in the existing manifest if it can still be read, then to the
+configured default bucket.`,
+	Args: cobra.RangeArgs(0, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		localPath := manifestPath(args)
+
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+
+		manifestManager := sync.NewManifestManager(localPath)
+		bucketName := ""
+		if len(args) == 2 {
+			bucketName = args[1]
+		} else if lastManifest, err := manifestManager.LoadManifest(); err == nil && lastManifest.Bucket != "" {
+			bucketName = lastManifest.Bucket
+		} else if cfg.Sync.DefaultBucket != "" {
+			bucketName = cfg.Sync.DefaultBucket
+		} else {
+			exitWithError("", usageError("bucket name required (manifest unreadable and no default bucket configured)"))
+		}
+
+		ctx := cmd.Context()
+		client, err := aws.NewClient(cfg)
+		if err != nil {
+			exitWithError("error creating aws client", err)
+		}
+		exists, err := client.BucketExists(ctx, bucketName)
+		if err != nil {
+			exitWithError("error checking bucket", err)
+		}
+		if !exists {
+			exitWithError("", fmt.Errorf("bucket %s: %w", bucketName, aws.ErrBucketNotFound))
+		}
+
+		scanOptions, err := scanOptionsFromConfig(cfg)
+		if err != nil {
+			exitWithError("", err)
+		}
+		localManifest, err := manifestManager.BuildLocalManifest(ctx, localPath, scanOptions)
+		if err != nil {
+			exitWithError("error scanning local directory", err)
+		}
+		remoteManifest, err := listRemoteManifest(ctx, client, bucketName, cfg.Sync.Prefix)
+		if err != nil {
+			exitWithError("", err)
+		}
+
+		manifest, err := sync.RebuildManifest(localManifest, remoteManifest, contentMatcher(localPath, cfg))
+		if err != nil {
+			exitWithError("error rebuilding manifest", err)
+		}
+
+		if err := manifestManager.ResetManifest(); err != nil {
+			exitWithError("error rebuilding manifest", err)
+		}
+		if err := manifestManager.SaveManifest(manifest); err != nil {
+			exitWithError("error saving manifest", err)
+		}
+		changesApplied = true
+
+		message := fmt.Sprintf("manifest rebuilt: %d of %d local files match s3 bucket %s", len(manifest.Files), len(localManifest.Files), bucketName)
+		renderStatus(statusRecord{Status: "ok", Message: message, Bucket: bucketName, LocalPath: localPath}, "✅ "+message)
+	},
+}
+
+// manifestview is the structured form of a manifest
+type manifestView struct {
+	Version  int                  `json:"version"`
+	Bucket   string               `json:"bucket"`
+	Prefix   string               `json:"prefix,omitempty"`
+	LastSync time.Time            `json:"last_sync"`
+	Files    []fileutils.FileInfo `json:"files"`
+}
+
+// manifestverifyreport is the result of manifest verify
+type manifestVerifyReport struct {
+	Version  int                    `json:"version"`
+	Files    int                    `json:"files"`
+	Problems []sync.ManifestProblem `json:"problems"`
+}
+
+// manifestpath returns the local path argument of a manifest command
+func manifestPath(args []string) string {
+	if len(args) >= 1 {
+		return args[0]
+	}
+	return "."
+}
+
+// filesasrecords returns files as ndjson records
+func filesAsRecords(files []fileutils.FileInfo) []interface{} {
+	records := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		records = append(records, file)
+	}
+	return records
+}
+
+// shortchecksum abbreviates a checksum for text output
+func shortChecksum(checksum string) string {
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	if checksum == "" {
+		return "-"
+	}
+	return checksum
+}
+
+// contentmatcher compares a file below localpath with an object by size and etag, assuming
+// multipart objects were uploaded with the configured chunk size. a preserved symlink is stored
+// as its target. results are remembered, so that a run planning and then saving the manifest
+// reads each file once.
+func contentMatcher(localPath string, cfg *config.Config) sync.ETagMatcher {
+	matched := make(map[[3]string]bool)
+	return func(local, remote fileutils.FileInfo) (bool, error) {
+		if local.Size != remote.Size {
+			return false, nil
+		}
+		key := [3]string{local.RelativePath, local.Checksum, remote.ETag}
+		if same, ok := matched[key]; ok {
+			return same, nil
+		}
+
+		var same bool
+		if local.LinkTarget != "" {
+			same = fmt.Sprintf("%x", md5.Sum([]byte(local.LinkTarget))) == strings.Trim(remote.ETag, "\"")
+		} else {
+			var err error
+			if same, err = fileutils.MatchesETag(filepath.Join(localPath, local.RelativePath), remote.ETag, cfg.Sync.ChunkSize); err != nil {
+				return false, err
+			}
+		}
+		matched[key] = same
+		return same, nil
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/metrics"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// metricsshutdowntimeout bounds how long stopping the metrics server waits for scrapes in flight
+const metricsShutdownTimeout = 5 * time.Second
+
+// startmetrics serves /metrics on addr in the background; the returned function stops it. it
+// is also stopped when the command exits with an error.
+func startMetrics(addr string) (func(), error) {
+	if addr == "" {
+		return func() {}, nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serve metrics on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	renderer.Printf("📈 serving metrics on http://%s/metrics\n", listener.Addr())
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+	onExit(stop)
+	return stop, nil
+}
+
+// startmetricsfromflags serves /metrics on the --metrics-addr of a command, if set
+func startMetricsFromFlags(cmd *cobra.Command) func() {
+	addr, _ := cmd.Flags().GetString("metrics-addr")
+	stop, err := startMetrics(addr)
+	if err != nil {
+		exitWithError("", err)
+	}
+	return stop
+}
+
+// metricsjob returns the job label of a run: the daemon job, or the direction outside the daemon
+func metricsJob(job string, direction sync.Direction) string {
+	if job != "" {
+		return job
+	}
+	return string(direction)
+}
+
+// pendingcounts counts the pending actions of a plan by the operation they carry out
+func pendingCounts(actions []sync.SyncAction, ops []sync.SyncOp) map[string]int {
+	counts := make(map[string]int)
+	for _, action := range actions {
+		if !sync.ContainsOp(ops, action.Operation) || action.EffectiveOp() == sync.SyncOpSkip {
+			continue
+		}
+		counts[string(action.EffectiveOp())]++
+	}
+	return counts
+}
+
+// failedcounts counts the failed actions of a run, which stay pending for the next run
+func failedCounts(results []sync.ActionResult) map[string]int {
+	counts := make(map[string]int)
+	for _, result := range results {
+		if result.Status == sync.StatusFailed {
+			counts[string(result.EffectiveOp())]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jvkec/aws-s3sync/internal/output"
+)
+
+// renderer writes command results in the format selected with --output
+var renderer = output.NewRenderer(stdout, output.FormatPlain)
+
+// bucketrecord is the structured form of a bucket listing entry
+type bucketRecord struct {
+	Name string `json:"name"`
+}
+
+// configview is the structured form of the current configuration
+type configView struct {
+	ConfigFile    string `json:"config_file"`
+	Region        string `json:"region"`
+	Profile       string `json:"profile,omitempty"`
+	AccessKey     string `json:"access_key,omitempty"`
+	DefaultBucket string `json:"default_bucket"`
+	MaxRetries    int    `json:"max_retries"`
+	ChunkSize     int64  `json:"chunk_size"`
+}
+
+// statusrecord is the structured result of commands that only report success
+type statusRecord struct {
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+	Bucket    string `json:"bucket,omitempty"`
+	Key       string `json:"key,omitempty"`
+	LocalPath string `json:"local_path,omitempty"`
+}
+
+// render writes a command result and exits if it cannot be encoded
+func render(view output.View) {
+	if err := renderer.Render(view); err != nil {
+		exitWithError("error writing output", err)
+	}
+}
+
+// renderstatus writes a status record, or the given message for text formats
+func renderStatus(record statusRecord, message string) {
+	render(output.View{
+		Data:    record,
+		Columns: []string{"status", "message"},
+		Rows:    [][]string{{record.Status, record.Message}},
+		Text: func(w io.Writer) {
+			fmt.Fprintln(w, message)
+		},
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/output"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan [local-path] [bucket-name]",
+	Short: "compute sync actions and save them for review",
+	Long: `computes the actions needed to sync a local directory with an s3 bucket and prints them.
+with --out the plan is saved together with fingerprints of the local files, remote objects
+and sync manifest, so 's3sync apply' can later execute exactly the reviewed actions.
+save plan files outside the synced directory, otherwise writing the plan changes local state.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		localPath := args[0]
+
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
+		}
+
+		bucketName := cfg.Sync.DefaultBucket
+		if len(args) == 2 {
+			bucketName = args[1]
+		}
+		if bucketName == "" {
+			exitWithError("", usageError("bucket name required (no default bucket configured)"))
+		}
+
+		directionName, _ := cmd.Flags().GetString("direction")
+		direction := sync.Direction(directionName)
+		ops, err := sync.DirectionOps(direction)
+		if err != nil {
+			exitWithError("", usageError("%v", err))
+		}
+
+		conflictName, _ := cmd.Flags().GetString("conflict")
+		conflict, err := sync.ParseConflictPolicy(conflictName)
+		if err != nil {
+			exitWithError("", usageError("%v", err))
+		}
+
+		absPath, err := filepath.Abs(localPath)
+		if err != nil {
+			exitWithError("error resolving local path", err)
+		}
+
+		prepared, err := prepareSync(cmd.Context(), absPath, bucketName, cfg)
+		if err != nil {
+			exitWithError("error computing plan", err)
+		}
+
+		prepared.actions = sync.DropLocallyMovedSources(prepared.actions, ops)
+		if err := sync.ResolveConflicts(prepared.actions, direction, conflict, promptConflict); err != nil {
+			exitWithError("error resolving conflicts", err)
+		}
+
+		plan := sync.NewPlan(direction, bucketName, absPath, planActions(direction, prepared.actions),
+			prepared.localManifest, prepared.remoteManifest, prepared.lastManifest)
+		plan.Prefix = cfg.Sync.Prefix
+
+		planFile, _ := cmd.Flags().GetString("out")
+		if planFile != "" {
+			if err := sync.SavePlan(planFile, plan); err != nil {
+				exitWithError("error saving plan", err)
+			}
+		}
+
+		render(output.View{
+			Data:    plan,
+			Columns: []string{"operation", "path", "size", "reason"},
+			Rows:    planRows(plan.Actions),
+			Text: func(w io.Writer) {
+				for _, action := range plan.Actions {
+					if action.Operation == sync.SyncOpConflict {
+						fmt.Fprintf(w, "  %s %s (%s, keeping %s)\n", action.Operation, action.RelativePath, action.Reason, action.Resolution)
+					} else {
+						fmt.Fprintf(w, "  %s %s (%s)\n", action.Operation, action.RelativePath, action.Reason)
+					}
+				}
+				fmt.Fprintf(w, "📋 %s plan: %d files to upload, %d files to download%s, %d conflicts\n",
+					plan.Direction, plan.Summary.Uploads, plan.Summary.Downloads, moveSummary(plan.Summary), plan.Summary.Conflicts)
+				if planFile != "" {
+					fmt.Fprintf(w, "plan saved to %s, run 's3sync apply %s' to execute it\n", planFile, planFile)
+				}
+			},
+		})
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply [plan-file]",
+	Short: "execute a saved plan",
+	Long: `executes exactly the actions stored in a plan file created by 's3sync plan --out'.
+the plan is refused if local files, remote objects or the sync manifest changed since it was created.
+runs the hooks configured under hooks; see 's3sync help hooks'.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		plan, err := sync.LoadPlan(args[0])
+		if err != nil {
+			exitWithError("error loading plan", err)
+		}
+
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
+		}
+
+		// saved plans carry their own prefix
+		cfg.Sync.Prefix = plan.Prefix
+		err = hookedSync(cmd.Context(), plan.Direction, plan.LocalPath, plan.Bucket, cfg, syncOptions{}, func(opts syncOptions) error {
+			return performApply(cmd, plan, cfg, opts)
+		})
+		if err != nil {
+			exitWithError("error applying plan", err)
+		}
+	},
+}
+
+// performapply verifies that a plan is still current and executes its actions
+func performApply(cmd *cobra.Command, plan *sync.Plan, cfg *config.Config, opts syncOptions) error {
+	ctx := cmd.Context()
+
+	current, err := prepareSync(ctx, plan.LocalPath, plan.Bucket, cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := plan.CheckDrift(current.localManifest, current.remoteManifest, current.lastManifest); err != nil {
+		return err
+	}
+	current.observer = opts.observer
+
+	report := newSyncReport(plan.Direction, plan.Bucket, plan.LocalPath, plan.Actions, false)
+	printSyncSummary(plan.Direction, report.Summary)
+
+	ops, err := sync.DirectionOps(plan.Direction)
+	if err != nil {
+		return err
+	}
+	if sync.PendingActions(plan.Actions, ops) == 0 {
+		renderer.Println("✅ nothing to apply")
+		return renderSyncReport(report)
+	}
+
+	return executeSync(ctx, current, plan.Direction, plan.Actions, report)
+}
+
+// planactions keeps the actions a direction executes, dropping skips and opposite transfers
+func planActions(direction sync.Direction, actions []sync.SyncAction) []sync.SyncAction {
+	ops, _ := sync.DirectionOps(direction)
+
+	planned := make([]sync.SyncAction, 0, len(actions))
+	for _, action := range actions {
+		if sync.ContainsOp(ops, action.Operation) {
+			planned = append(planned, action)
+		}
+	}
+
+	return planned
+}
+
+// planrows formats actions for table output
+func planRows(actions []sync.SyncAction) [][]string {
+	rows := make([][]string, 0, len(actions))
+	for _, action := range actions {
+		rows = append(rows, []string{string(action.Operation), action.RelativePath, fmt.Sprint(action.File.Size), action.Reason})
+	}
+	return rows
+}
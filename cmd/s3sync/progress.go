@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/output"
+	"github.com/jvkec/aws-s3sync/internal/progress"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+// progress reporting intervals
+const (
+	progressBarInterval   = 200 * time.Millisecond
+	progressEventInterval = time.Second
+	progressLogInterval   = 10 * time.Second
+)
+
+// progressbarwidth is the number of cells of a progress bar
+const progressBarWidth = 24
+
+// screen keeps the progress bars below the lines printed while they are shown
+var screen = &progressScreen{out: os.Stderr}
+
+// stdout and stderr are the standard streams as written while syncing, kept clear of the progress bars
+var (
+	stdout = screen.writer(os.Stdout)
+	stderr = screen.writer(os.Stderr)
+)
+
+// progressscreen draws progress bars at the bottom of the terminal. output written through its
+// writers clears the bars first and draws them again after it, so printed lines scroll above them.
+type progressScreen struct {
+	mu    stdsync.Mutex
+	out   io.Writer
+	frame []string // lines of the current bars
+	drawn int      // lines of bars on the terminal
+}
+
+// writer wraps w so that its output does not mix with the bars
+func (s *progressScreen) writer(w io.Writer) io.Writer {
+	return &screenWriter{screen: s, out: w}
+}
+
+// show replaces the bars with frame; an empty frame removes them
+func (s *progressScreen) show(frame []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clear()
+	s.frame = frame
+	s.draw()
+}
+
+// clear erases the drawn bars, leaving the cursor where they started
+func (s *progressScreen) clear() {
+	if s.drawn == 0 {
+		return
+	}
+	fmt.Fprint(s.out, "\r\033[K"+strings.Repeat("\033[1A\033[K", s.drawn-1))
+	s.drawn = 0
+}
+
+// draw writes the bars without a trailing newline so the next clear can take them back
+func (s *progressScreen) draw() {
+	if len(s.frame) == 0 {
+		return
+	}
+	fmt.Fprint(s.out, strings.Join(s.frame, "\n"))
+	s.drawn = len(s.frame)
+}
+
+// screenwriter writes output above the progress bars
+type screenWriter struct {
+	screen *progressScreen
+	out    io.Writer
+}
+
+func (w *screenWriter) Write(p []byte) (int, error) {
+	w.screen.mu.Lock()
+	defer w.screen.mu.Unlock()
+	w.screen.clear()
+	n, err := w.out.Write(p)
+	// bars are drawn again once the line is complete
+	if len(p) > 0 && p[len(p)-1] == '\n' {
+		w.screen.draw()
+	}
+	return n, err
+}
+
+// startprogress reports the progress of a run until the returned function is called: as
+// progress events with structured output, as bars when stderr is a terminal and as periodic log
+// lines otherwise
+func startProgress(tracker *progress.Tracker) func() {
+	interval, report := progressLogInterval, logProgress
+	switch {
+	case renderer.Structured():
+		interval, report = progressEventInterval, emitProgress
+	case terminalWidth(os.Stderr) > 0 && os.Getenv("TERM") != "dumb":
+		interval, report = progressBarInterval, func(snapshot progress.Snapshot) {
+			screen.show(progressBars(snapshot, terminalWidth(os.Stderr)))
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report(tracker.Snapshot())
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+		screen.show(nil)
+	}
+}
+
+// emitprogress writes a progress event. ndjson output carries it inline; json output keeps
+// stdout a single document and writes events to stderr instead.
+func emitProgress(snapshot progress.Snapshot) {
+	if renderer.Format() == output.FormatNDJSON {
+		renderer.Event("progress", snapshot)
+		return
+	}
+	if data, err := json.Marshal(output.Event{Type: "progress", Data: snapshot}); err == nil {
+		fmt.Fprintln(os.Stderr, string(data))
+	}
+}
+
+// logprogress prints a progress line for logs and other non-terminal output
+func logProgress(snapshot progress.Snapshot) {
+	line := fmt.Sprintf("📊 %s of %s (%d%%), %d of %d files, %s, eta %s",
+		formatBytes(snapshot.BytesDone), formatBytes(snapshot.Bytes), percent(snapshot.BytesDone, snapshot.Bytes),
+		snapshot.FilesDone, snapshot.Files, formatRate(snapshot.BytesPerSecond), formatETA(snapshot.ETA))
+	for _, transfer := range snapshot.Transfers {
+		if transfer.Size > 0 {
+			line += fmt.Sprintf("; %s %s %d%%", transfer.Operation, transfer.Path, percent(transfer.BytesDone, transfer.Size))
+		}
+	}
+	renderer.Println(line)
+}
+
+// progressbars renders a bar for each file in transfer and one for the whole run, each line
+// fitted to the terminal width so that it never wraps
+func progressBars(snapshot progress.Snapshot, width int) []string {
+	var lines []string
+	for _, transfer := range snapshot.Transfers {
+		if transfer.Size == 0 {
+			continue
+		}
+		lines = append(lines, progressLine(transfer.Path, transfer.BytesDone, transfer.Size, transfer.BytesPerSecond, transfer.ETA, width))
+	}
+	label := fmt.Sprintf("total %d/%d files", snapshot.FilesDone, snapshot.Files)
+	return append(lines, progressLine(label, snapshot.BytesDone, snapshot.Bytes, snapshot.BytesPerSecond, snapshot.ETA, width))
+}
+
+// progressline draws a label, a bar and the figures of done out of total. the label gives way
+// first when the terminal is narrow, then the bar.
+func progressLine(label string, done, total int64, bytesPerSecond, eta float64, width int) string {
+	figures := fmt.Sprintf(" %3d%% %s/%s %s eta %s", percent(done, total), formatBytes(done), formatBytes(total), formatRate(bytesPerSecond), formatETA(eta))
+
+	// one column stays free so that the cursor never wraps to the next line
+	room := width - 1 - len([]rune(figures))
+	barWidth := min(progressBarWidth, max(room/2, 0))
+	labelWidth := room - barWidth - 3
+	if labelWidth < 4 {
+		return truncate(figures[1:], width-1)
+	}
+
+	filled := barWidth * percent(done, total) / 100
+	bar := " [" + strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled) + "]"
+	return fmt.Sprintf("%-*s%s%s", labelWidth, shortenPath(label, labelWidth), bar, figures)
+}
+
+// truncate cuts s to at most width runes
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= max(width, 0) {
+		return s
+	}
+	return string(runes[:max(width, 0)])
+}
+
+// percent returns done out of total as a whole percentage between 0 and 100
+func percent(done, total int64) int {
+	if total <= 0 {
+		return 100
+	}
+	return int(min(max(done*100/total, 0), 100))
+}
+
+// shortenpath keeps the end of a path that is longer than width
+func shortenPath(path string, width int) string {
+	runes := []rune(path)
+	if len(runes) <= width {
+		return path
+	}
+	return "…" + string(runes[len(runes)-width+1:])
+}
+
+// formatbytes formats a byte count with a binary unit, e.g. 1.5 GiB
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, unit := float64(n), 0
+	for value >= 1024 && unit < 6 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[unit-1])
+}
+
+// formatrate formats a throughput, or a dash before any data moved
+func formatRate(bytesPerSecond float64) string {
+	if bytesPerSecond <= 0 {
+		return "- /s"
+	}
+	return formatBytes(int64(bytesPerSecond)) + "/s"
+}
+
+// formateta formats the seconds left, or a dash while unknown
+func formatETA(seconds float64) string {
+	if seconds <= 0 {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).Round(time.Second).String()
+}
+
+// transferbytes sums the file data the pending actions of a run send or receive
+func transferBytes(actions []sync.SyncAction, ops []sync.SyncOp) int64 {
+	var total int64
+	for _, action := range actions {
+		if sync.ContainsOp(ops, action.Operation) {
+			total += sync.TransferSize(action)
+		}
+	}
+	return total
+}
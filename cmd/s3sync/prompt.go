@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+// stdinreader is shared by all prompts so buffered input is not lost between questions
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// promptline prints a question to stderr and reads a trimmed, lower-case answer from stdin
+func promptLine(question string) (string, error) {
+	fmt.Fprint(os.Stderr, question)
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.ToLower(strings.TrimSpace(answer)), nil
+}
+
+// promptconflict asks on the terminal how to resolve a file changed on both sides
+func promptConflict(action sync.SyncAction) (sync.ConflictPolicy, error) {
+	fmt.Fprintf(os.Stderr, "\n⚠️  conflict: %s (%s)\n", action.RelativePath, action.Reason)
+	printConflictSides(action)
+
+	for {
+		answer, err := promptLine("keep [l]ocal, [r]emote, [b]oth, [n]ewer or [s]kip? ")
+		if err != nil {
+			return "", err
+		}
+		if resolution, ok := parseResolution(answer); ok {
+			return resolution, nil
+		}
+		fmt.Fprintln(os.Stderr, "please answer l, r, b, n or s")
+	}
+}
+
+// printconflictsides prints the size and modification time of both sides of a conflict
+func printConflictSides(action sync.SyncAction) {
+	fmt.Fprintf(os.Stderr, "  local:  %d bytes, modified %s\n", action.File.Size, action.File.ModTime.Format("2006-01-02 15:04:05"))
+	if action.RemoteFile != nil {
+		fmt.Fprintf(os.Stderr, "  remote: %d bytes, modified %s\n", action.RemoteFile.Size, action.RemoteFile.ModTime.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// parseresolution maps a short or long prompt answer to a conflict resolution
+func parseResolution(answer string) (sync.ConflictPolicy, bool) {
+	switch answer {
+	case "l", "local":
+		return sync.ConflictLocal, true
+	case "r", "remote":
+		return sync.ConflictRemote, true
+	case "b", "both", "keep-both":
+		return sync.ConflictKeepBoth, true
+	case "n", "newer":
+		return sync.ConflictNewer, true
+	case "s", "skip":
+		return sync.ConflictSkip, true
+	}
+	return "", false
+}
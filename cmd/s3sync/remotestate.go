@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+// remotestateattempts is how often the shared state write is retried after losing a race
+const remoteStateAttempts = 5
+
+// remotestatesession is the shared state object of a bucket as read at the start of a run
+type remoteStateSession struct {
+	client          *aws.Client
+	manifestManager *sync.ManifestManager
+	bucket          string
+	key             string
+	writer          sync.WriterIdentity
+	state           *sync.RemoteState
+
+	// the state records every object of the prefix: it is unchanged since this directory last
+	// saw it complete, or the run listed the bucket and its update records what it saw
+	complete bool
+}
+
+// loadremotestate reads the shared state object of a prefix, starting a new one if none exists
+func loadRemoteState(ctx context.Context, client *aws.Client, bucketName, prefix string, manifestManager *sync.ManifestManager) (*remoteStateSession, error) {
+	writer, err := manifestManager.WriterIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &remoteStateSession{
+		client:          client,
+		manifestManager: manifestManager,
+		bucket:          bucketName,
+		key:             sync.RemoteStateObjectKey(strings.Trim(prefix, "/")),
+		writer:          writer,
+	}
+	if err := session.reload(ctx); err != nil {
+		return nil, err
+	}
+	etag := session.state.ETag()
+	session.complete = etag != "" && manifestManager.CompleteRemoteState(bucketName, session.key) == etag
+	return session, nil
+}
+
+// reload reads the current shared state object
+func (s *remoteStateSession) reload(ctx context.Context) error {
+	data, etag, err := s.client.GetObjectData(ctx, s.bucket, s.key)
+	if errors.Is(err, aws.ErrNoSuchKey) {
+		s.state = sync.NewRemoteState()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error loading remote state: %w", err)
+	}
+
+	state, err := sync.ParseRemoteState(data, etag)
+	if err != nil {
+		return fmt.Errorf("error loading remote state: %w", err)
+	}
+	s.state = state
+	return nil
+}
+
+// save writes the changes of a run to the shared state object. the write is conditional on
+// the etag the state was read with; when another client wrote in between, the state is read
+// again and the changes are applied on top of it. a complete state is remembered with its new
+// etag, so that the next run can skip listing the bucket while no other client writes.
+func (s *remoteStateSession) save(ctx context.Context, update *sync.RemoteStateUpdate) error {
+	if update.Empty() {
+		return s.markComplete()
+	}
+
+	var err error
+	for attempt := 0; attempt < remoteStateAttempts; attempt++ {
+		if attempt > 0 {
+			if err := s.reload(ctx); err != nil {
+				return err
+			}
+		}
+
+		update.ApplyTo(s.state, s.writer, time.Now())
+		data, marshalErr := s.state.Marshal()
+		if marshalErr != nil {
+			return fmt.Errorf("error saving remote state: %w", marshalErr)
+		}
+
+		var etag string
+		etag, err = s.client.PutObjectData(ctx, s.bucket, s.key, data, s.state.ETag())
+		if err == nil {
+			if s.state, err = sync.ParseRemoteState(data, etag); err != nil {
+				return err
+			}
+			return s.markComplete()
+		}
+		if !errors.Is(err, aws.ErrPreconditionFailed) {
+			break
+		}
+	}
+
+	return fmt.Errorf("error saving remote state: %w", err)
+}
+
+// markcomplete remembers the etag of a complete state
+func (s *remoteStateSession) markComplete() error {
+	if !s.complete || s.state.ETag() == "" {
+		return nil
+	}
+	return s.manifestManager.SaveCompleteRemoteState(s.bucket, s.key, s.state.ETag())
+}
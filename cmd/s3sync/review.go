@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+// reviewchoice is a decision taken for one action during interactive review
+type reviewChoice struct {
+	accept     bool
+	resolution sync.ConflictPolicy // chosen side of a conflict
+}
+
+// reviewer walks the pending actions of a plan and asks for a decision on each one
+type reviewer struct {
+	plan      *syncPlan
+	direction sync.Direction
+	differ    *differ
+	remaining map[sync.SyncOp]reviewChoice // choices applied to all remaining actions of a kind
+	quit      bool
+}
+
+// reviewactions asks for every pending action with an operation in ops whether to run it.
+// skipped transfers become skip actions, conflicts get the chosen resolution if the direction
+// runs it; the manifest keeps the last known state of skipped files so they stay pending for
+// the next run.
+func reviewActions(ctx context.Context, plan *syncPlan, direction sync.Direction, bucketName, localPath string, ops []sync.SyncOp) error {
+	r := &reviewer{
+		plan:      plan,
+		direction: direction,
+		differ: &differ{
+			client:      plan.client,
+			bucket:      bucketName,
+			prefix:      plan.prefix,
+			root:        localPath,
+			context:     3,
+			scanOptions: plan.scanOptions,
+		},
+		remaining: make(map[sync.SyncOp]reviewChoice),
+	}
+
+	// conflicts resolved to skip by the policy are still offered so a side can be chosen
+	var reviewed []*sync.SyncAction
+	for i := range plan.actions {
+		if sync.ContainsOp(ops, plan.actions[i].Operation) && plan.actions[i].Operation != sync.SyncOpSkip {
+			reviewed = append(reviewed, &plan.actions[i])
+		}
+	}
+
+	for index, action := range reviewed {
+		choice, err := r.choose(ctx, *action, index+1, len(reviewed))
+		if err != nil {
+			return err
+		}
+		applyReviewChoice(action, choice, r.direction)
+	}
+
+	return nil
+}
+
+// choose returns the decision for an action, prompting unless an earlier answer covers it
+func (r *reviewer) choose(ctx context.Context, action sync.SyncAction, index, total int) (reviewChoice, error) {
+	if r.quit {
+		return reviewChoice{}, nil
+	}
+	if choice, ok := r.remaining[action.Operation]; ok {
+		return choice, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "\n[%d/%d] %s %s (%s)\n", index, total, action.Operation, action.RelativePath, action.Reason)
+	question := "[y]es, [n]o, [d]iff, [q]uit; add * to apply to all remaining " + string(action.Operation) + "s: "
+	if action.Operation == sync.SyncOpConflict {
+		printConflictSides(action)
+		fmt.Fprintf(os.Stderr, "  policy would keep: %s\n", action.Resolution)
+		question = "keep [l]ocal, [r]emote, [b]oth, [s]kip, [y] policy choice, [d]iff, [q]uit; add * to apply to all remaining conflicts: "
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return reviewChoice{}, err
+		}
+
+		answer, err := promptLine(question)
+		if err != nil {
+			return reviewChoice{}, err
+		}
+		all := strings.HasSuffix(answer, "*")
+		answer = strings.TrimSuffix(answer, "*")
+
+		switch answer {
+		case "d", "diff":
+			r.showDiff(ctx, action)
+			continue
+		case "q", "quit":
+			r.quit = true
+			return reviewChoice{}, nil
+		}
+
+		choice, ok := parseReviewAnswer(action, answer)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "please answer with one of the listed letters")
+			continue
+		}
+		if all {
+			r.remaining[action.Operation] = choice
+		}
+		return choice, nil
+	}
+}
+
+// parsereviewanswer maps a prompt answer to a decision for the kind of action
+func parseReviewAnswer(action sync.SyncAction, answer string) (reviewChoice, bool) {
+	switch answer {
+	case "y", "yes":
+		return reviewChoice{accept: true, resolution: action.Resolution}, true
+	case "n", "no":
+		return reviewChoice{}, true
+	}
+
+	if action.Operation != sync.SyncOpConflict {
+		return reviewChoice{}, false
+	}
+	resolution, ok := parseResolution(answer)
+	if !ok || resolution == sync.ConflictNewer {
+		return reviewChoice{}, false
+	}
+	return reviewChoice{accept: resolution != sync.ConflictSkip, resolution: resolution}, true
+}
+
+// applyreviewchoice updates an action with the decision taken for it in a direction
+func applyReviewChoice(action *sync.SyncAction, choice reviewChoice, direction sync.Direction) {
+	if action.Operation == sync.SyncOpConflict {
+		action.Resolution = sync.ConflictSkip
+		if choice.accept {
+			action.Resolution = sync.DirectionResolution(direction, choice.resolution)
+		}
+		return
+	}
+
+	if !choice.accept {
+		action.Operation = sync.SyncOpSkip
+		action.Reason = "skipped during review"
+	}
+}
+
+// showdiff prints the diff of an action's file against its remote version to stderr
+func (r *reviewer) showDiff(ctx context.Context, action sync.SyncAction) {
+	var remoteFile *fileutils.FileInfo
+	if file, ok := r.plan.remoteManifest.Files[action.RelativePath]; ok {
+		remoteFile = &file
+	}
+
+	result, err := r.differ.compare(ctx, filepath.ToSlash(action.RelativePath), remoteFile, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ failed to diff %s: %v\n", action.RelativePath, err)
+		return
+	}
+	printFileDiff(os.Stderr, r.differ.bucket, result)
+}
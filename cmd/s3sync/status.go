@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/output"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status [local-path] [bucket-name]",
+	Short: "show pending local and remote changes",
+	Long: `compares a fresh local scan and the remote object listing with the last sync manifest and
+shows files that are new, modified or deleted on each side, plus conflicts changed on both sides.
+the bucket defaults to the one recorded in the manifest, then to the configured default bucket.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		localPath := "."
+		if len(args) >= 1 {
+			localPath = args[0]
+		}
+
+		configManager := config.NewConfigManager()
+		cfg, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError("error loading config", configError(err))
+		}
+		if err := applySyncFlags(cmd, cfg); err != nil {
+			exitWithError("", err)
+		}
+
+		bucketName, err := statusBucket(localPath, args, cfg)
+		if err != nil {
+			exitWithError("", err)
+		}
+
+		plan, err := prepareSync(cmd.Context(), localPath, bucketName, cfg)
+		if err != nil {
+			exitWithError("error computing status", err)
+		}
+
+		statuses, err := sync.ComputeStatus(plan.localManifest, plan.remoteManifest, plan.lastManifest, plan.matches)
+		if err != nil {
+			exitWithError("error computing status", err)
+		}
+		short, _ := cmd.Flags().GetBool("short")
+
+		rows := make([][]string, 0, len(statuses))
+		for _, status := range statuses {
+			rows = append(rows, []string{status.Local.Code() + status.Remote.Code(), status.RelativePath})
+		}
+
+		render(output.View{
+			Data:    statuses,
+			Columns: []string{"status", "path"},
+			Rows:    rows,
+			Text: func(w io.Writer) {
+				if short {
+					for _, row := range rows {
+						fmt.Fprintf(w, "%s %s\n", row[0], row[1])
+					}
+					return
+				}
+				printLongStatus(w, bucketName, plan.lastManifest, statuses)
+			},
+		})
+
+		exitCode, _ := cmd.Flags().GetBool("exit-code")
+		if exitCode && len(statuses) > 0 {
+			exit(exitChanges)
+		}
+	},
+}
+
+// statusbucket picks the bucket from the arguments, the last manifest or the configured default
+func statusBucket(localPath string, args []string, cfg *config.Config) (string, error) {
+	if len(args) == 2 {
+		return args[1], nil
+	}
+
+	lastManifest, err := sync.NewManifestManager(localPath).LoadManifest()
+	if err != nil {
+		return "", fmt.Errorf("error loading manifest: %w", err)
+	}
+	if lastManifest.Bucket != "" {
+		return lastManifest.Bucket, nil
+	}
+	if cfg.Sync.DefaultBucket != "" {
+		return cfg.Sync.DefaultBucket, nil
+	}
+
+	return "", usageError("bucket name required (directory has no sync manifest and no default bucket is configured)")
+}
+
+// printlongstatus prints a git-style grouped view of pending changes
+func printLongStatus(w io.Writer, bucketName string, lastManifest *sync.Manifest, statuses []sync.FileStatus) {
+	if lastManifest.LastSync.IsZero() {
+		fmt.Fprintf(w, "bucket: %s (never synced)\n", bucketName)
+	} else {
+		fmt.Fprintf(w, "bucket: %s (last sync %s)\n", bucketName, lastManifest.LastSync.Format("2006-01-02 15:04:05"))
+	}
+
+	if len(statuses) == 0 {
+		fmt.Fprintln(w, "✅ nothing to sync, directory clean")
+		return
+	}
+
+	var local, remote, conflicts []sync.FileStatus
+	for _, status := range statuses {
+		switch {
+		case status.Conflict:
+			conflicts = append(conflicts, status)
+		case status.Local != sync.ChangeNone:
+			local = append(local, status)
+		default:
+			remote = append(remote, status)
+		}
+	}
+
+	if len(local) > 0 {
+		fmt.Fprintln(w, "\nlocal changes (use 's3sync push' to upload):")
+		for _, status := range local {
+			fmt.Fprintf(w, "  %-10s %s\n", status.Local+":", status.RelativePath)
+		}
+	}
+	if len(remote) > 0 {
+		fmt.Fprintln(w, "\nremote changes (use 's3sync pull' to download):")
+		for _, status := range remote {
+			fmt.Fprintf(w, "  %-10s %s\n", status.Remote+":", status.RelativePath)
+		}
+	}
+	if len(conflicts) > 0 {
+		fmt.Fprintln(w, "\nconflicts (changed on both sides):")
+		for _, status := range conflicts {
+			fmt.Fprintf(w, "  %-20s %s\n", fmt.Sprintf("%s/%s:", status.Local, status.Remote), status.RelativePath)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/metrics"
+	"github.com/jvkec/aws-s3sync/internal/output"
+	"github.com/jvkec/aws-s3sync/internal/progress"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// syncplan holds the manifests and actions computed for a push or pull
+type syncPlan struct {
+	client          *aws.Client
+	manifestManager *sync.ManifestManager
+	lastManifest    *sync.Manifest
+	localManifest   *sync.Manifest
+	remoteManifest  *sync.Manifest
+	remoteState     *remoteStateSession // shared state object, nil unless enabled
+	prefix          string              // key prefix of the synced objects
+	matches         sync.ETagMatcher    // compares files never synced with their objects
+	observer        *syncObserver       // follows executed actions, nil unless set in the options
+	job             string              // labels the metrics of the run, the direction if empty
+	actions         []sync.SyncAction
+
+	// how the local tree was scanned, so that review diffs read files the same way
+	scanOptions fileutils.ScanOptions
+
+	// a partial plan covers only the files at scope; its manifests hold just those entries
+	// and the synced entries are merged into baseManifest when saving
+	scope        []string
+	baseManifest *sync.Manifest
+	remoteScope  []string // files whose objects were looked up, the only ones the shared state update may observe
+}
+
+// preparesync checks the bucket, loads the manifests and computes sync actions
+func prepareSync(ctx context.Context, localPath, bucketName string, cfg *config.Config) (*syncPlan, error) {
+	// create aws client
+	client, err := aws.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aws client: %w", err)
+	}
+
+	// check if bucket exists
+	exists, err := client.BucketExists(ctx, bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("error checking bucket: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s: %w", bucketName, aws.ErrBucketNotFound)
+	}
+
+	// create manifest manager
+	manifestManager := sync.NewManifestManager(localPath)
+
+	// load last known manifest
+	lastManifest, err := manifestManager.LoadManifest()
+	if err != nil {
+		return nil, fmt.Errorf("error loading manifest: %w", err)
+	}
+
+	// build current local manifest
+	scanOptions, err := scanOptionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	localManifest, err := manifestManager.BuildLocalManifest(ctx, localPath, scanOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning local directory: %w", err)
+	}
+
+	// files another client deleted are not uploaded again
+	var session *remoteStateSession
+	var state *sync.RemoteState
+	if cfg.Sync.RemoteState {
+		session, err = loadRemoteState(ctx, client, bucketName, cfg.Sync.Prefix, manifestManager)
+		if err != nil {
+			return nil, err
+		}
+		state = session.state
+	}
+
+	// get remote manifest from a shared state no client changed since it was complete, or by
+	// listing s3 objects
+	var remoteManifest *sync.Manifest
+	if session != nil && session.complete {
+		remoteManifest = state.Manifest(bucketName, cfg.Sync.Prefix)
+	} else {
+		remoteManifest, err = listRemoteManifest(ctx, client, bucketName, cfg.Sync.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			// the update saved after the run records what the listing shows
+			session.complete = true
+		}
+	}
+	if err := refineRemoteModTimes(ctx, client, bucketName, localManifest, remoteManifest, lastManifest); err != nil {
+		return nil, err
+	}
+
+	plan := &syncPlan{
+		client:          client,
+		manifestManager: manifestManager,
+		lastManifest:    lastManifest,
+		localManifest:   localManifest,
+		remoteManifest:  remoteManifest,
+		remoteState:     session,
+		prefix:          cfg.Sync.Prefix,
+		matches:         contentMatcher(localPath, cfg),
+		scanOptions:     scanOptions,
+	}
+	plan.actions, err = sync.ComputeSharedSyncActions(localManifest, remoteManifest, lastManifest, state, plan.matches)
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// savesyncedmanifest saves the manifest of a run, merging the entries of a partial plan into
+// the full manifest
+func saveSyncedManifest(plan *syncPlan, manifest *sync.Manifest) error {
+	if plan.scope != nil {
+		plan.baseManifest.ReplaceSubset(plan.scope, manifest)
+		manifest = plan.baseManifest
+	}
+	if err := plan.manifestManager.SaveManifest(manifest); err != nil {
+		return fmt.Errorf("error saving manifest: %w", err)
+	}
+	return nil
+}
+
+// recordmatchedfiles saves the manifest of a run without transfers if it found files never
+// synced to be identical on both sides, so that later runs need not compare them again
+func recordMatchedFiles(plan *syncPlan) error {
+	manifest, err := sync.SyncedManifest(plan.localManifest, plan.remoteManifest, plan.lastManifest, nil, plan.matches)
+	if err != nil {
+		return fmt.Errorf("error saving manifest: %w", err)
+	}
+	for relativePath := range manifest.Files {
+		if _, known := plan.lastManifest.Files[relativePath]; !known {
+			return saveSyncedManifest(plan, manifest)
+		}
+	}
+	return nil
+}
+
+// saveremotestate records the results of a run and the objects it saw in the shared state
+func saveRemoteState(ctx context.Context, plan *syncPlan, results []sync.ActionResult) error {
+	if plan.remoteState == nil {
+		return nil
+	}
+	update := sync.NewRemoteStateUpdate(plan.localManifest, plan.remoteManifest, plan.remoteState.state, results, plan.remoteState.writer, time.Now())
+	if plan.scope != nil {
+		update.LimitTo(plan.remoteScope)
+	}
+	return plan.remoteState.save(ctx, update)
+}
+
+// listremotemanifest lists the objects below a prefix of a bucket as a manifest
+func listRemoteManifest(ctx context.Context, client *aws.Client, bucketName, prefix string) (*sync.Manifest, error) {
+	remoteFiles, err := client.ListObjects(ctx, bucketName, listPrefix(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("error listing remote objects: %w", err)
+	}
+
+	remoteManifest := &sync.Manifest{
+		Files:  make(map[string]fileutils.FileInfo),
+		Bucket: bucketName,
+		Prefix: prefix,
+	}
+	for _, file := range remoteFiles {
+		if sync.IsStateKey(file.RelativePath) {
+			continue
+		}
+		remoteManifest.Files[file.RelativePath] = file
+	}
+
+	return remoteManifest, nil
+}
+
+// listprefix returns the listing prefix of a key prefix, ending in a slash so that sibling
+// prefixes sharing its name are not listed
+func listPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// refineremotemodtimes replaces the s3 upload time of remote files with the original
+// modification time from their metadata where times decide the outcome: files present on both
+// sides that are unknown to the manifest or changed remotely since the last sync
+func refineRemoteModTimes(ctx context.Context, client *aws.Client, bucketName string, localManifest, remoteManifest, lastManifest *sync.Manifest) error {
+	for relativePath, remoteFile := range remoteManifest.Files {
+		if _, localExists := localManifest.Files[relativePath]; !localExists {
+			continue
+		}
+		if lastKnownFile, wasKnown := lastManifest.Files[relativePath]; wasKnown && !sync.RemoteChanged(remoteFile, lastKnownFile) {
+			continue
+		}
+
+		stat, err := client.StatObject(ctx, bucketName, remoteFile.Path)
+		if errors.Is(err, aws.ErrNoSuchKey) {
+			// deleted since the listing; the transfer will report it
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading remote metadata: %w", err)
+		}
+		remoteFile.ModTime = stat.ModTime
+		remoteManifest.Files[relativePath] = remoteFile
+	}
+
+	return nil
+}
+
+// syncreport is the structured result of a push or pull
+type syncReport struct {
+	Direction sync.Direction      `json:"direction"`
+	Bucket    string              `json:"bucket"`
+	LocalPath string              `json:"local_path"`
+	Actions   []sync.SyncAction   `json:"actions"`
+	Results   []sync.ActionResult `json:"results"`
+	Summary   sync.Summary        `json:"summary"`
+}
+
+// syncoptions are the command line options shared by push, pull and sync
+type syncOptions struct {
+	dryRun      bool
+	interactive bool
+	conflict    sync.ConflictPolicy
+	observer    *syncObserver
+	job         string // daemon job running the sync, for the metrics
+}
+
+// syncobserver follows the actions of a run next to the printed output, as the daemon does
+// to report transfers in progress
+type syncObserver struct {
+	onStart  func(action sync.SyncAction)
+	onResult func(result sync.ActionResult)
+}
+
+// attach makes an executor report to the observer as well, if there is one
+func (o *syncObserver) attach(executor *sync.Executor) {
+	if o == nil {
+		return
+	}
+	onStart, onResult := executor.OnStart, executor.OnResult
+	executor.OnStart = func(action sync.SyncAction) {
+		onStart(action)
+		o.onStart(action)
+	}
+	executor.OnResult = func(result sync.ActionResult) {
+		onResult(result)
+		o.onResult(result)
+	}
+}
+
+// syncoptionsfromflags reads the sync options of a command
+func syncOptionsFromFlags(cmd *cobra.Command) (syncOptions, error) {
+	var opts syncOptions
+	opts.dryRun, _ = cmd.Flags().GetBool("dry-run")
+	opts.interactive, _ = cmd.Flags().GetBool("interactive")
+
+	conflictName, _ := cmd.Flags().GetString("conflict")
+	conflict, err := sync.ParseConflictPolicy(conflictName)
+	if err != nil {
+		return opts, usageError("%v", err)
+	}
+	opts.conflict = conflict
+
+	return opts, nil
+}
+
+// applysyncflags overrides the configured scan, remote state, delta and prefix settings with command line flags
+func applySyncFlags(cmd *cobra.Command, cfg *config.Config) error {
+	if cmd.Flags().Changed("checksum") {
+		cfg.Sync.Checksum, _ = cmd.Flags().GetBool("checksum")
+	}
+	if cmd.Flags().Changed("preserve") {
+		cfg.Sync.Preserve, _ = cmd.Flags().GetBool("preserve")
+	}
+	if cmd.Flags().Changed("symlinks") {
+		cfg.Sync.Symlinks, _ = cmd.Flags().GetString("symlinks")
+	}
+	if cmd.Flags().Changed("remote-state") {
+		cfg.Sync.RemoteState, _ = cmd.Flags().GetBool("remote-state")
+	}
+	if cmd.Flags().Changed("delta") {
+		cfg.Sync.Delta, _ = cmd.Flags().GetBool("delta")
+	}
+	if cmd.Flags().Changed("prefix") {
+		cfg.Sync.Prefix, _ = cmd.Flags().GetString("prefix")
+	}
+
+	if _, err := fileutils.ParseSymlinkPolicy(cfg.Sync.Symlinks); err != nil {
+		return usageError("%v", err)
+	}
+	return nil
+}
+
+// scanoptionsfromconfig returns the local scan options for the configured sync settings
+func scanOptionsFromConfig(cfg *config.Config) (fileutils.ScanOptions, error) {
+	symlinks, err := fileutils.ParseSymlinkPolicy(cfg.Sync.Symlinks)
+	if err != nil {
+		return fileutils.ScanOptions{}, configError(err)
+	}
+	return fileutils.ScanOptions{Symlinks: symlinks, Preserve: cfg.Sync.Preserve, Checksum: cfg.Sync.Checksum}, nil
+}
+
+func performPush(ctx context.Context, localPath, bucketName string, cfg *config.Config, opts syncOptions) error {
+	return performSync(ctx, sync.DirectionPush, localPath, bucketName, cfg, opts)
+}
+
+func performPull(ctx context.Context, bucketName, localPath string, cfg *config.Config, opts syncOptions) error {
+	// ensure local directory exists
+	if err := fileutils.CreateDirIfNotExists(localPath); err != nil {
+		return fmt.Errorf("error creating local directory: %w", err)
+	}
+
+	return performSync(ctx, sync.DirectionPull, localPath, bucketName, cfg, opts)
+}
+
+func performBidirectionalSync(ctx context.Context, localPath, bucketName string, cfg *config.Config, opts syncOptions) error {
+	return performSync(ctx, sync.DirectionSync, localPath, bucketName, cfg, opts)
+}
+
+// performsync computes and executes the actions for a direction between the configured hooks
+func performSync(ctx context.Context, direction sync.Direction, localPath, bucketName string, cfg *config.Config, opts syncOptions) error {
+	return hookedSync(ctx, direction, localPath, bucketName, cfg, opts, func(opts syncOptions) error {
+		return runSync(ctx, direction, localPath, bucketName, cfg, opts)
+	})
+}
+
+// runsync computes and executes the actions for a direction
+func runSync(ctx context.Context, direction sync.Direction, localPath, bucketName string, cfg *config.Config, opts syncOptions) error {
+	plan, err := prepareSync(ctx, localPath, bucketName, cfg)
+	if err != nil {
+		return err
+	}
+	plan.observer = opts.observer
+	plan.job = opts.job
+
+	ops, err := sync.DirectionOps(direction)
+	if err != nil {
+		return err
+	}
+	plan.actions = sync.DropLocallyMovedSources(plan.actions, ops)
+	if err := sync.ResolveConflicts(plan.actions, direction, opts.conflict, promptConflict); err != nil {
+		return err
+	}
+	if opts.interactive {
+		if err := reviewActions(ctx, plan, direction, bucketName, localPath, ops); err != nil {
+			return fmt.Errorf("interactive review: %w", err)
+		}
+	}
+	report := newSyncReport(direction, bucketName, localPath, plan.actions, opts.dryRun)
+
+	// display actions
+	if opts.dryRun {
+		for _, action := range plan.actions {
+			if !sync.ContainsOp(ops, action.Operation) {
+				continue
+			}
+			if action.Operation == sync.SyncOpConflict {
+				renderer.Printf("[dry-run] conflict: %s (%s, keeping %s)\n", action.RelativePath, action.Reason, action.Resolution)
+			} else {
+				renderer.Printf("[dry-run] would %s: %s (%s)\n", action.Operation, action.RelativePath, action.Reason)
+			}
+		}
+	}
+
+	printSyncSummary(direction, report.Summary)
+
+	if opts.dryRun {
+		renderer.Println("dry-run mode: no files were actually transferred")
+		return renderSyncReport(report)
+	}
+
+	if sync.PendingActions(plan.actions, ops) == 0 {
+		metrics.SetPending(metricsJob(plan.job, direction), nil)
+		if err := recordMatchedFiles(plan); err != nil {
+			return err
+		}
+		if err := saveRemoteState(ctx, plan, nil); err != nil {
+			return err
+		}
+		renderer.Println("✅ everything up to date!")
+		return renderSyncReport(report)
+	}
+
+	return executeSync(ctx, plan, direction, plan.actions, report)
+}
+
+// executesync runs the transfers of a plan, saves the manifest and reports the outcome
+func executeSync(ctx context.Context, plan *syncPlan, direction sync.Direction, actions []sync.SyncAction, report *syncReport) error {
+	ops, err := sync.DirectionOps(direction)
+	if err != nil {
+		return err
+	}
+	pending := sync.PendingActions(actions, ops)
+	metrics.SetPending(metricsJob(plan.job, direction), pendingCounts(actions, ops))
+
+	// perform transfers, continuing past individual failures
+	executor := newSyncExecutor(plan.client, report.Bucket, plan.prefix, report.LocalPath)
+	onResult := executor.OnResult
+	executor.OnResult = func(result sync.ActionResult) {
+		onResult(result)
+		// the manifest saved below records the result again if this fails
+		if err := plan.manifestManager.RecordResult(result, plan.localManifest, plan.remoteManifest); err != nil {
+			fmt.Fprintf(stderr, "⚠️  failed to record %s in the manifest: %v\n", result.RelativePath, err)
+		}
+	}
+	plan.observer.attach(executor)
+	executor.Progress = progress.NewTracker(pending, transferBytes(actions, ops))
+	stopProgress := startProgress(executor.Progress)
+	results, err := executor.Execute(ctx, actions, ops...)
+	stopProgress()
+	report.Results = results
+	report.Summary.AddResults(results)
+	if err != nil {
+		return fmt.Errorf("%s cancelled after %d transfers: %w", direction, report.Summary.Succeeded, err)
+	}
+
+	failures, firstErr := collectFailures(results)
+	metrics.SetPending(metricsJob(plan.job, direction), failedCounts(results))
+	if report.Summary.Succeeded == 0 && firstErr != nil {
+		return fmt.Errorf("error syncing %s: %w", failures[0].Path, firstErr)
+	}
+	changesApplied = report.Summary.Succeeded > 0
+
+	// save updated manifest; failed transfers keep their last known state and are retried next run
+	manifest, err := sync.SyncedManifest(plan.localManifest, plan.remoteManifest, plan.lastManifest, results, plan.matches)
+	if err != nil {
+		return fmt.Errorf("error saving manifest: %w", err)
+	}
+	if err := saveSyncedManifest(plan, manifest); err != nil {
+		return err
+	}
+	if err := saveRemoteState(ctx, plan, results); err != nil {
+		return err
+	}
+
+	if report.Summary.BytesSaved > 0 {
+		renderer.Printf("♻️  %d bytes not transferred thanks to moves, copies and reused parts\n", report.Summary.BytesSaved)
+	}
+
+	if len(failures) > 0 {
+		if err := renderSyncReport(report); err != nil {
+			return err
+		}
+		return &partialFailureError{Total: pending, Failures: failures}
+	}
+
+	switch direction {
+	case sync.DirectionPush:
+		renderer.Printf("✅ synced %d files to s3 bucket %s\n", pending, report.Bucket)
+	case sync.DirectionPull:
+		renderer.Printf("✅ synced %d files from s3 bucket %s\n", pending, report.Bucket)
+	default:
+		renderer.Printf("✅ synced %d files with s3 bucket %s\n", pending, report.Bucket)
+	}
+	return renderSyncReport(report)
+}
+
+// printsyncsummary prints the planned transfer counts for a direction
+func printSyncSummary(direction sync.Direction, summary sync.Summary) {
+	conflicts := ""
+	if summary.Conflicts > 0 {
+		conflicts = fmt.Sprintf(", %d conflicts", summary.Conflicts)
+	}
+	conflicts = moveSummary(summary) + conflicts
+
+	switch direction {
+	case sync.DirectionPush:
+		renderer.Printf("📦 push summary: %d files to upload, %d files to skip%s\n", summary.Uploads, summary.Skipped, conflicts)
+	case sync.DirectionPull:
+		renderer.Printf("📦 pull summary: %d files to download, %d files to skip%s\n", summary.Downloads, summary.Skipped, conflicts)
+	default:
+		renderer.Printf("📦 %s summary: %d files to upload, %d files to download, %d files to skip%s\n",
+			direction, summary.Uploads, summary.Downloads, summary.Skipped, conflicts)
+	}
+}
+
+// movesummary describes the planned moves and copies, if any
+func moveSummary(summary sync.Summary) string {
+	copies := ""
+	if summary.Moves > 0 {
+		copies += fmt.Sprintf(", %d files to move", summary.Moves)
+	}
+	if summary.Copies > 0 {
+		copies += fmt.Sprintf(", %d files to copy", summary.Copies)
+	}
+	return copies
+}
+
+// newsyncreport creates a report for a computed plan and emits its actions in ndjson mode
+func newSyncReport(direction sync.Direction, bucketName, localPath string, actions []sync.SyncAction, dryRun bool) *syncReport {
+	report := &syncReport{
+		Direction: direction,
+		Bucket:    bucketName,
+		LocalPath: localPath,
+		Actions:   actions,
+		Results:   make([]sync.ActionResult, 0),
+		Summary:   sync.Summarize(actions),
+	}
+	report.Summary.DryRun = dryRun
+
+	for _, action := range actions {
+		renderer.Event("action", action)
+	}
+
+	return report
+}
+
+// newsyncexecutor creates an executor that reports progress through the renderer
+func newSyncExecutor(client *aws.Client, bucketName, prefix, localPath string) *sync.Executor {
+	return &sync.Executor{
+		Client:    client,
+		Bucket:    bucketName,
+		Prefix:    prefix,
+		LocalPath: localPath,
+		OnStart: func(action sync.SyncAction) {
+			if action.Operation == sync.SyncOpConflict {
+				renderer.Printf("⚠️  conflict on %s, keeping %s\n", action.RelativePath, action.Resolution)
+			}
+			switch action.EffectiveOp() {
+			case sync.SyncOpUpload:
+				renderer.Printf("⬆️  uploading %s...\n", action.RelativePath)
+			case sync.SyncOpDownload:
+				renderer.Printf("⬇️  downloading %s...\n", action.RelativePath)
+			case sync.SyncOpMove:
+				renderer.Printf("🔀 moving %s to %s on s3...\n", action.Source, action.RelativePath)
+			case sync.SyncOpCopy:
+				renderer.Printf("📑 copying %s to %s on s3...\n", action.Source, action.RelativePath)
+			case sync.SyncOpLocalMove:
+				renderer.Printf("🔀 moving %s to %s locally...\n", action.Source, action.RelativePath)
+			case sync.SyncOpLocalCopy:
+				renderer.Printf("📑 copying %s to %s locally...\n", action.Source, action.RelativePath)
+			}
+		},
+		OnResult: func(result sync.ActionResult) {
+			if result.Status == sync.StatusFailed && !renderer.Structured() {
+				fmt.Fprintf(stderr, "❌ failed to %s %s: %s\n", result.Operation, result.RelativePath, result.Error)
+			}
+			renderer.Event("result", result)
+		},
+	}
+}
+
+// rendersyncreport writes the final push or pull report; text output is printed while syncing
+func renderSyncReport(report *syncReport) error {
+	return renderer.Render(output.View{
+		Data:    report,
+		Records: []interface{}{output.Event{Type: "summary", Data: report.Summary}},
+		Text:    func(w io.Writer) {},
+	})
+}
+
+// collectfailures returns the failed transfers of a run with the first error
+func collectFailures(results []sync.ActionResult) ([]fileFailure, error) {
+	var failures []fileFailure
+	var firstErr error
+
+	for _, result := range results {
+		if result.Status != sync.StatusFailed {
+			continue
+		}
+		failures = append(failures, fileFailure{Path: result.RelativePath, Error: result.Error})
+		if firstErr == nil {
+			firstErr = result.Err()
+		}
+	}
+
+	return failures, firstErr
+}
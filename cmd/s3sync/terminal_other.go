@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// terminalwidth returns the columns of the terminal at f, or 0 if f is not a terminal.
+// without a way to ask, character devices are assumed to be 80 columns wide.
+func terminalWidth(f *os.File) int {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return 0
+	}
+	return 80
+}
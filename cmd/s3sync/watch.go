@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+// performwatch pushes the tree once and then keeps pushing the paths that change until ctx is
+// done. failed pushes are reported and retried with the next change; lost file events trigger
+// a full push.
+func performWatch(ctx context.Context, localPath, bucketName string, cfg *config.Config, opts syncOptions, debounce time.Duration) error {
+	// watch before the first push so that changes made during it are not missed
+	watcher, err := fileutils.NewWatcher(localPath, debounce)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := performPush(ctx, localPath, bucketName, cfg, opts); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		reportWatchError(err)
+	}
+	renderer.Printf("👀 watching %s for changes (ctrl-c to stop)\n", localPath)
+
+	err = watcher.Run(ctx, func(batch fileutils.WatchBatch) error {
+		var err error
+		if batch.Rescan {
+			renderer.Println("⚠️  file events were lost, rescanning the whole tree")
+			err = performPush(ctx, localPath, bucketName, cfg, opts)
+		} else {
+			err = hookedSync(ctx, sync.DirectionPush, localPath, bucketName, cfg, opts, func(opts syncOptions) error {
+				return pushChanges(ctx, localPath, bucketName, cfg, opts, batch.Paths)
+			})
+		}
+		if err != nil && ctx.Err() == nil {
+			reportWatchError(err)
+		}
+		return nil
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// reportwatcherror prints a failed push without stopping the watch
+func reportWatchError(err error) {
+	fmt.Fprintf(os.Stderr, "❌ push failed: %v\n", err)
+	if hint := aws.Hint(err); hint != "" {
+		fmt.Fprintf(os.Stderr, "hint: %s\n", hint)
+	}
+}
+
+// pushchanges pushes the files at the changed paths. only those paths are scanned and only
+// their objects are looked up, so the cost follows the size of the change, not of the tree.
+// deleting a synced file deletes its object unless the object changed remotely. with a shared
+// state object, the changes of those paths are recorded in it like after a full push.
+func pushChanges(ctx context.Context, localPath, bucketName string, cfg *config.Config, opts syncOptions, paths []string) error {
+	client, err := aws.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create aws client: %w", err)
+	}
+
+	manifestManager := sync.NewManifestManager(localPath)
+	lastManifest, err := manifestManager.LoadManifest()
+	if err != nil {
+		return fmt.Errorf("error loading manifest: %w", err)
+	}
+	lastKnown := lastManifest.Subset(paths)
+
+	// the hash cache is left out: saving it after a partial scan would drop unseen entries
+	scanOptions, err := scanOptionsFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	localManifest := &sync.Manifest{Files: make(map[string]fileutils.FileInfo)}
+	for file, err := range fileutils.ScanPathsSeq(ctx, localPath, paths, scanOptions) {
+		if err != nil {
+			return fmt.Errorf("error scanning local directory: %w", err)
+		}
+		localManifest.Files[file.RelativePath] = file
+	}
+
+	remoteManifest := &sync.Manifest{Files: make(map[string]fileutils.FileInfo), Bucket: bucketName, Prefix: cfg.Sync.Prefix}
+	var lookedUp []string
+	seen := make(map[string]bool)
+	for _, manifest := range []*sync.Manifest{localManifest, lastKnown} {
+		for relativePath := range manifest.Files {
+			if seen[relativePath] {
+				continue
+			}
+			seen[relativePath] = true
+			lookedUp = append(lookedUp, relativePath)
+			stat, err := client.StatObject(ctx, bucketName, sync.ObjectKey(cfg.Sync.Prefix, relativePath))
+			if errors.Is(err, aws.ErrNoSuchKey) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error reading remote metadata: %w", err)
+			}
+			stat.RelativePath = relativePath
+			remoteManifest.Files[relativePath] = *stat
+		}
+	}
+
+	plan := &syncPlan{
+		client:          client,
+		manifestManager: manifestManager,
+		lastManifest:    lastKnown,
+		localManifest:   localManifest,
+		remoteManifest:  remoteManifest,
+		prefix:          cfg.Sync.Prefix,
+		matches:         contentMatcher(localPath, cfg),
+		observer:        opts.observer,
+		job:             opts.job,
+		scope:           paths,
+		baseManifest:    lastManifest,
+		remoteScope:     lookedUp,
+	}
+
+	// files another client deleted are not uploaded again, and deletions are recorded as tombstones
+	var state *sync.RemoteState
+	if cfg.Sync.RemoteState {
+		plan.remoteState, err = loadRemoteState(ctx, client, bucketName, cfg.Sync.Prefix, manifestManager)
+		if err != nil {
+			return err
+		}
+		state = plan.remoteState.state
+	}
+	plan.actions, err = sync.ComputeSharedSyncActions(localManifest, remoteManifest, lastKnown, state, plan.matches)
+	if err != nil {
+		return err
+	}
+	sync.PropagateLocalDeletions(plan.actions, localManifest, remoteManifest, lastKnown)
+	if err := sync.ResolveConflicts(plan.actions, sync.DirectionPush, opts.conflict, promptConflict); err != nil {
+		return err
+	}
+
+	ops, err := sync.DirectionOps(sync.DirectionPush)
+	if err != nil {
+		return err
+	}
+	if sync.PendingActions(plan.actions, ops) == 0 {
+		if err := recordMatchedFiles(plan); err != nil {
+			return err
+		}
+		return saveRemoteState(ctx, plan, nil)
+	}
+
+	report := newSyncReport(sync.DirectionPush, bucketName, localPath, plan.actions, false)
+	return executeSync(ctx, plan, sync.DirectionPush, plan.actions, report)
+}
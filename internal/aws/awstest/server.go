@@ -0,0 +1,328 @@
+package awstest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	sdkaws "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/config"
+)
+
+// server is an in-memory s3 endpoint for tests. it keeps objects of any bucket, supports the
+// single and multipart uploads, copies, reads and deletes the client sends, and counts requests
+// by operation name.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]*Object
+	uploads  map[string]map[int][]byte
+	requests map[string]int
+	nextID   int
+}
+
+// object is a stored object
+type Object struct {
+	Data     []byte
+	ETag     string
+	Metadata map[string]string
+}
+
+// newserver starts a server that is closed when the test ends
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		objects:  make(map[string]*Object),
+		uploads:  make(map[string]map[int][]byte),
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// client returns an aws client with the given configuration that talks to the server
+func (s *Server) Client(cfg *config.Config) *aws.Client {
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	return &aws.Client{
+		S3: s3.New(s3.Options{
+			Region:                     "us-east-1",
+			BaseEndpoint:               sdkaws.String(s.URL),
+			UsePathStyle:               true,
+			Credentials:                credentials.NewStaticCredentialsProvider("test", "test", ""),
+			RequestChecksumCalculation: sdkaws.RequestChecksumCalculationWhenRequired,
+			ResponseChecksumValidation: sdkaws.ResponseChecksumValidationWhenRequired,
+		}),
+		Config: cfg,
+		Region: "us-east-1",
+	}
+}
+
+// put stores an object directly
+func (s *Server) Put(bucketName, key string, data []byte) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object := &Object{Data: data, ETag: md5Hex(data), Metadata: map[string]string{}}
+	s.objects[bucketName+"/"+key] = object
+	return object
+}
+
+// get returns a stored object, or nil
+func (s *Server) Get(bucketName, key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[bucketName+"/"+key]
+}
+
+// requests returns how many requests of an operation were served, such as putobject or
+// uploadpartcopy
+func (s *Server) Requests(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[operation]
+}
+
+// handle serves one path-style request
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(name, "/") {
+		// bucket requests: every bucket exists
+		s.requests["HeadBucket"]++
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodHead:
+		s.requests["HeadObject"]++
+		object := s.objects[name]
+		if object == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeObjectHeaders(w, object)
+	case r.Method == http.MethodGet:
+		s.requests["GetObject"]++
+		object := s.objects[name]
+		if object == nil {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		writeObjectHeaders(w, object)
+		w.Write(object.Data)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.requests["CreateMultipartUpload"]++
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = make(map[int][]byte)
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.requests["CompleteMultipartUpload"]++
+		s.complete(w, r, name, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.requests["AbortMultipartUpload"]++
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		s.requests["DeleteObject"]++
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		s.putPart(w, r, query)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.requests["CopyObject"]++
+		source, status, code := s.copySource(r)
+		if source == nil {
+			writeError(w, status, code)
+			return
+		}
+		object := &Object{Data: source.Data, ETag: source.ETag, Metadata: source.Metadata}
+		if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+			object.Metadata = requestMetadata(r)
+		}
+		s.objects[name] = object
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string   `xml:"ETag"`
+		}{ETag: quote(object.ETag)})
+	case r.Method == http.MethodPut:
+		s.requests["PutObject"]++
+		if status, code := s.checkConditions(r, s.objects[name]); status != 0 {
+			writeError(w, status, code)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		object := &Object{Data: data, ETag: md5Hex(data), Metadata: requestMetadata(r)}
+		s.objects[name] = object
+		w.Header().Set("ETag", quote(object.ETag))
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// putpart stores a part of a multipart upload, uploaded or copied from a range of an object
+func (s *Server) putPart(w http.ResponseWriter, r *http.Request, query url.Values) {
+	parts := s.uploads[query.Get("uploadId")]
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if parts == nil || err != nil {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	if r.Header.Get("X-Amz-Copy-Source") == "" {
+		s.requests["UploadPart"]++
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		parts[number] = data
+		w.Header().Set("ETag", quote(md5Hex(data)))
+		return
+	}
+
+	s.requests["UploadPartCopy"]++
+	source, status, code := s.copySource(r)
+	if source == nil {
+		writeError(w, status, code)
+		return
+	}
+	var first, last int
+	if _, err := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &first, &last); err != nil || last >= len(source.Data) {
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+		return
+	}
+	parts[number] = source.Data[first : last+1]
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyPartResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: quote(md5Hex(parts[number]))})
+}
+
+// complete assembles a multipart upload from its parts in the requested order
+func (s *Server) complete(w http.ResponseWriter, r *http.Request, name, id string) {
+	parts := s.uploads[id]
+	var request struct {
+		Parts []struct {
+			PartNumber int `xml:"PartNumber"`
+		} `xml:"Part"`
+	}
+	if parts == nil || xml.NewDecoder(r.Body).Decode(&request) != nil {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var data, sums []byte
+	for _, part := range request.Parts {
+		sum := md5.Sum(parts[part.PartNumber])
+		data = append(data, parts[part.PartNumber]...)
+		sums = append(sums, sum[:]...)
+	}
+	delete(s.uploads, id)
+
+	object := &Object{Data: data, ETag: fmt.Sprintf("%s-%d", md5Hex(sums), len(request.Parts)), Metadata: map[string]string{}}
+	s.objects[name] = object
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: quote(object.ETag)})
+}
+
+// copysource returns the source object of a copy, or the status and code of the error
+func (s *Server) copySource(r *http.Request) (*Object, int, string) {
+	name, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		return nil, http.StatusBadRequest, "InvalidArgument"
+	}
+	source := s.objects[name]
+	if source == nil {
+		return nil, http.StatusNotFound, "NoSuchKey"
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-Match"); match != "" && match != quote(source.ETag) {
+		return nil, http.StatusPreconditionFailed, "PreconditionFailed"
+	}
+	return source, 0, ""
+}
+
+// checkconditions applies the if-match and if-none-match headers of a write
+func (s *Server) checkConditions(r *http.Request, existing *Object) (int, string) {
+	if match := r.Header.Get("If-Match"); match != "" && (existing == nil || match != quote(existing.ETag)) {
+		return http.StatusPreconditionFailed, "PreconditionFailed"
+	}
+	if r.Header.Get("If-None-Match") == "*" && existing != nil {
+		return http.StatusPreconditionFailed, "PreconditionFailed"
+	}
+	return 0, ""
+}
+
+// requestmetadata returns the user metadata sent with a request
+func requestMetadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for name, values := range r.Header {
+		if key, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			metadata[key] = values[0]
+		}
+	}
+	return metadata
+}
+
+// writeobjectheaders sends the headers describing an object
+func writeObjectHeaders(w http.ResponseWriter, object *Object) {
+	w.Header().Set("ETag", quote(object.ETag))
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.Data)))
+	w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+	for key, value := range object.Metadata {
+		w.Header().Set("X-Amz-Meta-"+key, value)
+	}
+}
+
+// writexml sends an xml response body
+func writeXML(w http.ResponseWriter, body any) {
+	data, _ := xml.Marshal(body)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
+
+// writeerror sends an s3 error response
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// md5hex returns the etag s3 gives data uploaded in one request
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// quote puts an etag in the quotes s3 sends it in
+func quote(etag string) string {
+	return "\"" + etag + "\""
+}
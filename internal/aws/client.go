@@ -56,8 +56,10 @@ func NewClient(appConfig *appConfig.Config) (*Client, error) {
 		return nil, fmt.Errorf("failed to load aws config: %w", err)
 	}
 
-	// create s3 client
-	s3Client := s3.NewFromConfig(cfg)
+	// create s3 client, recording every operation for the metrics endpoint
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, instrumentRequests)
+	})
 
 	return &Client{
 		S3:     s3Client,
@@ -71,7 +73,7 @@ func (c *Client) TestConnection(ctx context.Context) error {
 	// test connection by listing buckets
 	_, err := c.S3.ListBuckets(ctx, &s3.ListBucketsInput{})
 	if err != nil {
-		return fmt.Errorf("failed to connect to aws s3: %w", err)
+		return fmt.Errorf("failed to connect to aws s3: %w", classifyError(err, "", nil))
 	}
 
 	return nil
@@ -81,7 +83,7 @@ func (c *Client) TestConnection(ctx context.Context) error {
 func (c *Client) ListBuckets(ctx context.Context) ([]string, error) {
 	result, err := c.S3.ListBuckets(ctx, &s3.ListBucketsInput{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list buckets: %w", err)
+		return nil, fmt.Errorf("failed to list buckets: %w", classifyError(err, "", nil))
 	}
 
 	buckets := make([]string, 0, len(result.Buckets))
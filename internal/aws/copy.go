@@ -0,0 +1,156 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+const (
+	// maxcopyobjectsize is the largest object a single copyobject request can copy
+	maxCopyObjectSize = 5 << 30
+
+	// copypartsize is the part size of multipart copies; raised for objects that would
+	// need more than maxcopyparts parts
+	copyPartSize = 512 << 20
+	maxCopyParts = 10000
+)
+
+// copyfile copies an existing object to a new key on the server side, for a local file whose
+// content is known to match the source object. the metadata of the copy is taken from the
+// local file, so the result is the same as uploading it.
+func (c *Client) CopyFile(ctx context.Context, localPath, bucketName, sourceKey, s3Key string) (*TransferResult, error) {
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	var metadata map[string]string
+	if info.Mode()&os.ModeSymlink != 0 && c.symlinkPolicy() == fileutils.SymlinkPreserve {
+		target, err := os.Readlink(localPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read symlink %s: %w", localPath, err)
+		}
+		metadata = c.fileMetadata(info)
+		metadata[MetadataSymlink] = target
+	} else {
+		if info, err = os.Stat(localPath); err != nil {
+			return nil, fmt.Errorf("failed to get file info: %w", err)
+		}
+		metadata = c.fileMetadata(info)
+	}
+
+	source, err := c.StatObject(ctx, bucketName, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TransferResult{ModTime: info.ModTime(), Size: source.Size}
+	if source.Size > maxCopyObjectSize {
+		err = c.copyMultipart(ctx, bucketName, sourceKey, s3Key, source.Size, metadata, result)
+	} else {
+		err = c.copyObject(ctx, bucketName, sourceKey, s3Key, metadata, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// copyobject copies an object with a single request, replacing its metadata
+func (c *Client) copyObject(ctx context.Context, bucketName, sourceKey, s3Key string, metadata map[string]string, result *TransferResult) error {
+	output, err := c.S3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(bucketName),
+		Key:               aws.String(s3Key),
+		CopySource:        aws.String(copySource(bucketName, sourceKey)),
+		Metadata:          metadata,
+		MetadataDirective: types.MetadataDirectiveReplace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", sourceKey, s3Key, classifyError(err, bucketName, ErrNoSuchKey))
+	}
+
+	if output.CopyObjectResult != nil {
+		result.ETag = trimETag(output.CopyObjectResult.ETag)
+	}
+	result.VersionID = aws.ToString(output.VersionId)
+	return nil
+}
+
+// copymultipart copies an object too large for copyobject in ranges with uploadpartcopy
+func (c *Client) copyMultipart(ctx context.Context, bucketName, sourceKey, s3Key string, size int64, metadata map[string]string, result *TransferResult) error {
+	partSize := int64(copyPartSize)
+	if minimum := (size + maxCopyParts - 1) / maxCopyParts; minimum > partSize {
+		partSize = minimum
+	}
+
+	upload, err := c.S3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(s3Key),
+		Metadata: metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start copy of %s to %s: %w", sourceKey, s3Key, classifyError(err, bucketName, ErrBucketNotFound))
+	}
+
+	parts := make([]types.CompletedPart, 0, (size+partSize-1)/partSize)
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+partSize, number+1 {
+		end := min(offset+partSize, size) - 1
+		part, err := c.S3.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(bucketName),
+			Key:             aws.String(s3Key),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int32(number),
+			CopySource:      aws.String(copySource(bucketName, sourceKey)),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			c.abortMultipart(bucketName, s3Key, upload.UploadId)
+			return fmt.Errorf("failed to copy part %d of %s: %w", number, sourceKey, classifyError(err, bucketName, ErrNoSuchKey))
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int32(number)})
+	}
+
+	output, err := c.S3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(s3Key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		c.abortMultipart(bucketName, s3Key, upload.UploadId)
+		return fmt.Errorf("failed to complete copy of %s to %s: %w", sourceKey, s3Key, classifyError(err, bucketName, ErrBucketNotFound))
+	}
+
+	result.ETag = trimETag(output.ETag)
+	result.VersionID = aws.ToString(output.VersionId)
+	return nil
+}
+
+// abortmultipart discards the parts of a failed multipart upload. it runs without the
+// request context so a cancelled run still cleans up.
+func (c *Client) abortMultipart(bucketName, s3Key string, uploadID *string) {
+	c.S3.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(s3Key),
+		UploadId: uploadID,
+	})
+}
+
+// copysource returns the url-encoded copy source header value of an object
+func copySource(bucketName, s3Key string) string {
+	segments := strings.Split(s3Key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucketName + "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,165 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/progress"
+)
+
+// minpartsize is the smallest part s3 accepts in a multipart upload, except for the last part
+const minPartSize = 5 << 20
+
+// delta reports whether large files are uploaded as deltas against their previous version
+func (c *Client) delta() bool {
+	return c.Config != nil && c.Config.Sync.Delta
+}
+
+// deltapartsize returns the part size of a delta upload: the configured chunk size, raised to
+// the s3 minimum and to what keeps the file within the part limit
+func (c *Client) deltaPartSize(size int64) int64 {
+	partSize := int64(minPartSize)
+	if c.Config != nil && c.Config.Sync.ChunkSize > partSize {
+		partSize = c.Config.Sync.ChunkSize
+	}
+	if minimum := (size + maxCopyParts - 1) / maxCopyParts; minimum > partSize {
+		partSize = minimum
+	}
+	return partSize
+}
+
+// uploadfiledelta uploads a file, reusing the unchanged parts of base when delta uploads are
+// enabled. files larger than one part are sent as multipart uploads whose part hashes are
+// returned for the manifest; parts whose hash matches the part hashes of base are copied from
+// the current object with uploadpartcopy, conditional on it still having base's etag. a zero
+// base, or one recorded with another part size, uploads every part.
+func (c *Client) UploadFileDelta(ctx context.Context, localPath, bucketName, s3Key string, base fileutils.FileInfo) (*TransferResult, error) {
+	if !c.delta() {
+		return c.UploadFile(ctx, localPath, bucketName, s3Key)
+	}
+	if c.symlinkPolicy() == fileutils.SymlinkPreserve {
+		if linkInfo, err := os.Lstat(localPath); err == nil && linkInfo.Mode()&os.ModeSymlink != 0 {
+			return c.uploadSymlink(ctx, localPath, linkInfo, bucketName, s3Key)
+		}
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	partSize := c.deltaPartSize(fileInfo.Size())
+	if fileInfo.Size() <= partSize {
+		return c.UploadFile(ctx, localPath, bucketName, s3Key)
+	}
+	if base.PartSize != partSize || base.ETag == "" {
+		base = fileutils.FileInfo{}
+	}
+
+	result, err := c.uploadParts(ctx, file, fileInfo, bucketName, s3Key, partSize, base)
+	if errors.Is(err, ErrPreconditionFailed) && len(base.PartHashes) > 0 {
+		// the object changed since its part hashes were recorded; send every part
+		result, err = c.uploadParts(ctx, file, fileInfo, bucketName, s3Key, partSize, fileutils.FileInfo{})
+	}
+	return result, err
+}
+
+// uploadparts sends a file as a multipart upload, copying the parts that base already holds
+func (c *Client) uploadParts(ctx context.Context, file *os.File, fileInfo os.FileInfo, bucketName, s3Key string, partSize int64, base fileutils.FileInfo) (*TransferResult, error) {
+	upload, err := c.S3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(s3Key),
+		Metadata: c.fileMetadata(fileInfo),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start upload of %s: %w", s3Key, classifyError(err, bucketName, ErrBucketNotFound))
+	}
+
+	size := fileInfo.Size()
+	buffer := make([]byte, partSize)
+	hashes := make([]string, 0, (size+partSize-1)/partSize)
+	parts := make([]types.CompletedPart, 0, cap(hashes))
+	var reused int64
+
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+partSize, number+1 {
+		data := buffer[:min(partSize, size-offset)]
+		if _, err := io.ReadFull(io.NewSectionReader(file, offset, int64(len(data))), data); err != nil {
+			c.abortMultipart(bucketName, s3Key, upload.UploadId)
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name(), err)
+		}
+		hash := fmt.Sprintf("%x", sha256.Sum256(data))
+		hashes = append(hashes, hash)
+
+		var etag *string
+		if index := int(number - 1); index < len(base.PartHashes) && base.PartHashes[index] == hash {
+			part, err := c.S3.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+				Bucket:            aws.String(bucketName),
+				Key:               aws.String(s3Key),
+				UploadId:          upload.UploadId,
+				PartNumber:        aws.Int32(number),
+				CopySource:        aws.String(copySource(bucketName, s3Key)),
+				CopySourceIfMatch: aws.String("\"" + base.ETag + "\""),
+				CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(data))-1)),
+			})
+			if err != nil {
+				c.abortMultipart(bucketName, s3Key, upload.UploadId)
+				return nil, fmt.Errorf("failed to reuse part %d of %s: %w", number, s3Key, classifyError(err, bucketName, ErrNoSuchKey))
+			}
+			etag = part.CopyPartResult.ETag
+			reused += int64(len(data))
+			progress.Skip(ctx, int64(len(data)))
+		} else {
+			part, err := c.S3.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:        aws.String(bucketName),
+				Key:           aws.String(s3Key),
+				UploadId:      upload.UploadId,
+				PartNumber:    aws.Int32(number),
+				Body:          progress.NewReader(ctx, bytes.NewReader(data)),
+				ContentLength: aws.Int64(int64(len(data))),
+			})
+			if err != nil {
+				c.abortMultipart(bucketName, s3Key, upload.UploadId)
+				return nil, fmt.Errorf("failed to upload part %d of %s: %w", number, s3Key, classifyError(err, bucketName, ErrBucketNotFound))
+			}
+			etag = part.ETag
+		}
+		parts = append(parts, types.CompletedPart{ETag: etag, PartNumber: aws.Int32(number)})
+	}
+
+	output, err := c.S3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(s3Key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		c.abortMultipart(bucketName, s3Key, upload.UploadId)
+		return nil, fmt.Errorf("failed to complete upload of %s: %w", s3Key, classifyError(err, bucketName, ErrBucketNotFound))
+	}
+
+	return &TransferResult{
+		ETag:       trimETag(output.ETag),
+		ModTime:    fileInfo.ModTime(),
+		VersionID:  aws.ToString(output.VersionId),
+		Size:       size,
+		Reused:     reused,
+		PartSize:   partSize,
+		PartHashes: hashes,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package aws_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/aws/awstest"
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// partsize is the part size of delta uploads with the default chunk size
+const partSize = 5 << 20
+
+func TestUploadFileDeltaReusesParts(t *testing.T) {
+	tests := []struct {
+		name string
+		// edit changes the file and the stored object after the first upload and returns the
+		// base the second upload is given
+		edit       func(t *testing.T, server *awstest.Server, data []byte, base fileutils.FileInfo) fileutils.FileInfo
+		wantCopies int
+		wantParts  int
+		wantReused int64
+	}{
+		{
+			name: "middle part changed",
+			edit: func(t *testing.T, server *awstest.Server, data []byte, base fileutils.FileInfo) fileutils.FileInfo {
+				data[partSize+1] ^= 0xff
+				return base
+			},
+			wantCopies: 2,
+			wantParts:  1,
+			wantReused: partSize + 2<<20,
+		},
+		{
+			name: "no base",
+			edit: func(t *testing.T, server *awstest.Server, data []byte, base fileutils.FileInfo) fileutils.FileInfo {
+				return fileutils.FileInfo{}
+			},
+			wantParts: 3,
+		},
+		{
+			name: "base with another part size",
+			edit: func(t *testing.T, server *awstest.Server, data []byte, base fileutils.FileInfo) fileutils.FileInfo {
+				base.PartSize *= 2
+				return base
+			},
+			wantParts: 3,
+		},
+		{
+			name: "object replaced since the base",
+			edit: func(t *testing.T, server *awstest.Server, data []byte, base fileutils.FileInfo) fileutils.FileInfo {
+				server.Put("bucket", "big.bin", []byte("replaced by another writer"))
+				return base
+			},
+			// the first part copy is refused and every part is sent again
+			wantCopies: 1,
+			wantParts:  3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := awstest.NewServer(t)
+			client := server.Client(&config.Config{Sync: config.SyncConfig{Delta: true}})
+			localPath := filepath.Join(t.TempDir(), "big.bin")
+
+			data := make([]byte, 2*partSize+2<<20)
+			for i := range data {
+				data[i] = byte(i % 251)
+			}
+			if err := os.WriteFile(localPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			first, err := client.UploadFileDelta(context.Background(), localPath, "bucket", "big.bin", fileutils.FileInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(first.PartHashes) != 3 || first.PartSize != partSize || first.Reused != 0 {
+				t.Fatalf("first upload recorded %d parts of %d bytes, reusing %d", len(first.PartHashes), first.PartSize, first.Reused)
+			}
+
+			base := test.edit(t, server, data, fileutils.FileInfo{ETag: first.ETag, PartSize: first.PartSize, PartHashes: first.PartHashes})
+			if err := os.WriteFile(localPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			copies, parts := server.Requests("UploadPartCopy"), server.Requests("UploadPart")
+			second, err := client.UploadFileDelta(context.Background(), localPath, "bucket", "big.bin", base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := server.Requests("UploadPartCopy") - copies; got != test.wantCopies {
+				t.Errorf("%d parts copied, want %d", got, test.wantCopies)
+			}
+			if got := server.Requests("UploadPart") - parts; got != test.wantParts {
+				t.Errorf("%d parts uploaded, want %d", got, test.wantParts)
+			}
+			if second.Reused != test.wantReused {
+				t.Errorf("reused %d bytes, want %d", second.Reused, test.wantReused)
+			}
+			if object := server.Get("bucket", "big.bin"); object == nil || !bytes.Equal(object.Data, data) || object.ETag != second.ETag {
+				t.Error("stored object does not match the uploaded file")
+			}
+		})
+	}
+}
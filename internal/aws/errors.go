@@ -0,0 +1,115 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// typed errors returned (wrapped) by client operations
+var (
+	ErrBucketNotFound     = errors.New("bucket does not exist")
+	ErrNoSuchKey          = errors.New("object does not exist")
+	ErrAccessDenied       = errors.New("access denied")
+	ErrInvalidCredentials = errors.New("invalid or expired aws credentials")
+	ErrWrongRegion        = errors.New("bucket is in a different region")
+	ErrPreconditionFailed = errors.New("object was changed by another writer")
+)
+
+// regionerror reports a bucket that lives in a region other than the configured one
+type RegionError struct {
+	Bucket string
+	Region string // actual bucket region, empty if s3 did not report it
+	Err    error
+}
+
+func (e *RegionError) Error() string {
+	if e.Region != "" {
+		return fmt.Sprintf("bucket %s is in region %s: %v", e.Bucket, e.Region, e.Err)
+	}
+	return fmt.Sprintf("bucket %s is in a different region: %v", e.Bucket, e.Err)
+}
+
+func (e *RegionError) Is(target error) bool { return target == ErrWrongRegion }
+
+func (e *RegionError) Unwrap() error { return e.Err }
+
+// classifyerror maps an s3 error to one of the typed errors above.
+// notfound is returned for a bare 404, since head requests carry no error code
+// and the meaning of 404 depends on whether a bucket or a key was addressed.
+func classifyError(err error, bucketName string, notFound error) error {
+	if err == nil {
+		return nil
+	}
+
+	code := ""
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	}
+
+	status := 0
+	region := ""
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		status = respErr.HTTPStatusCode()
+		if respErr.Response != nil {
+			region = respErr.Response.Header.Get("X-Amz-Bucket-Region")
+		}
+	}
+
+	switch code {
+	case "NoSuchBucket":
+		return fmt.Errorf("%w: %w", ErrBucketNotFound, err)
+	case "NoSuchKey":
+		return fmt.Errorf("%w: %w", ErrNoSuchKey, err)
+	case "AccessDenied", "AllAccessDisabled", "Forbidden":
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	case "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken", "TokenRefreshRequired":
+		return fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	case "PermanentRedirect", "AuthorizationHeaderMalformed", "IllegalLocationConstraintException":
+		return &RegionError{Bucket: bucketName, Region: region, Err: err}
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	}
+
+	switch status {
+	case http.StatusMovedPermanently, http.StatusTemporaryRedirect:
+		return &RegionError{Bucket: bucketName, Region: region, Err: err}
+	case http.StatusNotFound:
+		if notFound != nil {
+			return fmt.Errorf("%w: %w", notFound, err)
+		}
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	}
+
+	return err
+}
+
+// hint returns a short suggestion for resolving a typed error, or an empty string
+func Hint(err error) string {
+	var regionErr *RegionError
+	switch {
+	case errors.As(err, &regionErr) && regionErr.Region != "":
+		return fmt.Sprintf("set the aws region to %s (run 's3sync setup')", regionErr.Region)
+	case errors.Is(err, ErrWrongRegion):
+		return "check the configured aws region (run 's3sync setup')"
+	case errors.Is(err, ErrInvalidCredentials):
+		return "check your aws credentials (run 's3sync setup' or 's3sync test-connection')"
+	case errors.Is(err, ErrAccessDenied):
+		return "your credentials do not have permission for this bucket or object"
+	case errors.Is(err, ErrBucketNotFound):
+		return "check the bucket name or create it with 's3sync create-bucket'"
+	case errors.Is(err, ErrNoSuchKey):
+		return "check the object key (use 's3sync pull --dry-run' to list remote files)"
+	case errors.Is(err, ErrPreconditionFailed):
+		return "another client synced at the same time; run the command again"
+	}
+	return ""
+}
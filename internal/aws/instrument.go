@@ -0,0 +1,39 @@
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/jvkec/aws-s3sync/internal/metrics"
+)
+
+// throttleerrors recognizes the errors the sdk treats as throttling
+var throttleErrors = retry.IsErrorThrottles(retry.DefaultThrottles)
+
+// instrumentrequests adds a middleware recording the count, latency, retries and throttled
+// attempts of every s3 operation. it runs after the operation name is known and around the
+// retry loop, so one operation is observed once however many attempts it took.
+func instrumentRequests(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3syncMetrics",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, metadata, err := next.HandleInitialize(ctx, in)
+
+			retried, throttled := 0, 0
+			if attempts, ok := retry.GetAttemptResults(metadata); ok {
+				retried = max(len(attempts.Results)-1, 0)
+				for _, attempt := range attempts.Results {
+					if attempt.Err != nil && throttleErrors.IsErrorThrottle(attempt.Err) == aws.TrueTernary {
+						throttled++
+					}
+				}
+			}
+			metrics.ObserveRequest(middleware.GetOperationName(ctx), time.Since(start), err, retried, throttled)
+
+			return out, metadata, err
+		}), middleware.After)
+}
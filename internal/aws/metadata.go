@@ -0,0 +1,135 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// user metadata keys; s3 stores them as x-amz-meta-<key>
+const (
+	MetadataMTime   = "mtime"   // original modification time of an uploaded file
+	MetadataATime   = "atime"   // original access time, stored when sync.preserve_atime is set
+	MetadataMode    = "mode"    // octal permission bits, stored with --preserve
+	MetadataUID     = "uid"     // owning user id, stored with --preserve
+	MetadataGID     = "gid"     // owning group id, stored with --preserve
+	MetadataSymlink = "symlink" // target of a preserved symlink
+)
+
+// formatmetadatatime encodes a time as unix seconds with a nanosecond fraction
+func formatMetadataTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// parsemetadatatime decodes a time written by formatmetadatatime. plain unix seconds and
+// rfc 3339 values written by other tools are accepted as well.
+func parseMetadataTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+
+	secPart, fracPart, _ := strings.Cut(value, ".")
+	sec, err := strconv.ParseInt(secPart, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if fracPart != "" {
+		if len(fracPart) > 9 {
+			fracPart = fracPart[:9]
+		}
+		fracPart += strings.Repeat("0", 9-len(fracPart))
+		if nsec, err = strconv.ParseInt(fracPart, 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+
+	return time.Unix(sec, nsec), true
+}
+
+// objectmodtime returns the modification time recorded in object metadata, or fallback
+// (the s3 lastmodified time) when the object carries none
+func objectModTime(metadata map[string]string, fallback time.Time) time.Time {
+	if t, ok := parseMetadataTime(metadata[MetadataMTime]); ok {
+		return t
+	}
+	return fallback
+}
+
+// preserve reports whether permissions and ownership are stored and restored
+func (c *Client) preserve() bool {
+	return c.Config != nil && c.Config.Sync.Preserve
+}
+
+// symlinkpolicy returns the configured symlink handling
+func (c *Client) symlinkPolicy() fileutils.SymlinkPolicy {
+	if c.Config == nil {
+		return fileutils.SymlinkFollow
+	}
+	policy, err := fileutils.ParseSymlinkPolicy(c.Config.Sync.Symlinks)
+	if err != nil {
+		return fileutils.SymlinkFollow
+	}
+	return policy
+}
+
+// filemetadata returns the object metadata recorded for an uploaded file
+func (c *Client) fileMetadata(info os.FileInfo) map[string]string {
+	metadata := map[string]string{
+		MetadataMTime: formatMetadataTime(info.ModTime()),
+	}
+	if c.Config != nil && c.Config.Sync.PreserveATime {
+		if atime := fileutils.AccessTime(info); !atime.IsZero() {
+			metadata[MetadataATime] = formatMetadataTime(atime)
+		}
+	}
+
+	if c.preserve() {
+		if info.Mode()&os.ModeSymlink == 0 {
+			metadata[MetadataMode] = fmt.Sprintf("%04o", uint32(info.Mode().Perm()))
+		}
+		if uid, gid, ok := fileutils.FileOwner(info); ok {
+			metadata[MetadataUID] = strconv.Itoa(uid)
+			metadata[MetadataGID] = strconv.Itoa(gid)
+		}
+	}
+
+	return metadata
+}
+
+// restorepermissions applies the mode and ownership recorded in object metadata to a file
+func restorePermissions(localPath string, metadata map[string]string) error {
+	if value, ok := metadata[MetadataMode]; ok {
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err == nil {
+			if err := os.Chmod(localPath, os.FileMode(mode).Perm()); err != nil {
+				return fmt.Errorf("failed to set permissions of %s: %w", localPath, err)
+			}
+		}
+	}
+	return restoreOwnership(localPath, metadata)
+}
+
+// restoreownership applies the owner recorded in object metadata to a file or link. only
+// privileged users may give files away, so permission and unsupported errors are ignored.
+func restoreOwnership(localPath string, metadata map[string]string) error {
+	uid, uidErr := strconv.Atoi(metadata[MetadataUID])
+	gid, gidErr := strconv.Atoi(metadata[MetadataGID])
+	if uidErr != nil || gidErr != nil {
+		return nil
+	}
+
+	if err := os.Lchown(localPath, uid, gid); err != nil && !errors.Is(err, os.ErrPermission) && !errors.Is(err, errors.ErrUnsupported) {
+		return fmt.Errorf("failed to set owner of %s: %w", localPath, err)
+	}
+	return nil
+}
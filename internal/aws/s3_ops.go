@@ -1,19 +1,23 @@
 package aws
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/progress"
 )
 
 // bucketexists checks if a bucket exists and is accessible
@@ -23,12 +27,12 @@ func (c *Client) BucketExists(ctx context.Context, bucketName string) (bool, err
 	})
 
 	if err != nil {
-		// check if error is "not found" vs other errors
-		var noSuchBucket *types.NoSuchBucket
-		if errors.As(err, &noSuchBucket) {
+		// head requests carry no error body, so classify by status code
+		err = classifyError(err, bucketName, ErrBucketNotFound)
+		if errors.Is(err, ErrBucketNotFound) {
 			return false, nil
 		}
-		return false, fmt.Errorf("error checking bucket: %w", err)
+		return false, fmt.Errorf("error checking bucket %s: %w", bucketName, err)
 	}
 
 	return true, nil
@@ -60,7 +64,7 @@ func (c *Client) CreateBucket(ctx context.Context, bucketName string) error {
 	// create the bucket
 	_, err = c.S3.CreateBucket(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+		return fmt.Errorf("failed to create bucket %s: %w", bucketName, classifyError(err, bucketName, nil))
 	}
 
 	// enable versioning
@@ -71,7 +75,7 @@ func (c *Client) CreateBucket(ctx context.Context, bucketName string) error {
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to enable versioning on bucket %s: %w", bucketName, err)
+		return fmt.Errorf("failed to enable versioning on bucket %s: %w", bucketName, classifyError(err, bucketName, ErrBucketNotFound))
 	}
 
 	// enable server-side encryption
@@ -88,48 +92,105 @@ func (c *Client) CreateBucket(ctx context.Context, bucketName string) error {
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to enable encryption on bucket %s: %w", bucketName, err)
+		return fmt.Errorf("failed to enable encryption on bucket %s: %w", bucketName, classifyError(err, bucketName, ErrBucketNotFound))
 	}
 
 	return nil
 }
 
+// transferresult describes the object written or read by a transfer
+type TransferResult struct {
+	ETag      string    // etag of the s3 object
+	Checksum  string    // sha256 of the transferred data, set for downloads
+	ModTime   time.Time // original modification time recorded in the object metadata
+	VersionID string    // object version, set when the bucket is versioned
+	Size      int64
+	Reused    int64 // bytes copied from the previous version of the object instead of sent
+
+	// part layout of delta uploads, recorded in the manifest for the next delta
+	PartSize   int64
+	PartHashes []string
+}
+
 // uploadfile uploads a single file to s3
-func (c *Client) UploadFile(ctx context.Context, localPath, bucketName, s3Key string) error {
+func (c *Client) UploadFile(ctx context.Context, localPath, bucketName, s3Key string) (*TransferResult, error) {
+	// preserved symlinks are uploaded as their target path
+	if c.symlinkPolicy() == fileutils.SymlinkPreserve {
+		if linkInfo, err := os.Lstat(localPath); err == nil && linkInfo.Mode()&os.ModeSymlink != 0 {
+			return c.uploadSymlink(ctx, localPath, linkInfo, bucketName, s3Key)
+		}
+	}
+
 	// open local file
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", localPath, err)
+		return nil, fmt.Errorf("failed to open file %s: %w", localPath, err)
 	}
 	defer file.Close()
 
 	// get file info
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	// upload file
-	_, err = c.S3.PutObject(ctx, &s3.PutObjectInput{
+	// upload file, counting the bytes sent for progress reporting
+	output, err := c.S3.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:        aws.String(bucketName),
 		Key:           aws.String(s3Key),
-		Body:          file,
+		Body:          progress.NewReader(ctx, file),
 		ContentLength: aws.Int64(fileInfo.Size()),
+		Metadata:      c.fileMetadata(fileInfo),
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to upload file to s3: %w", err)
+		return nil, fmt.Errorf("failed to upload file to s3: %w", classifyError(err, bucketName, ErrBucketNotFound))
 	}
 
-	return nil
+	return &TransferResult{
+		ETag:      trimETag(output.ETag),
+		ModTime:   fileInfo.ModTime(),
+		VersionID: aws.ToString(output.VersionId),
+		Size:      fileInfo.Size(),
+	}, nil
+}
+
+// uploadsymlink stores a symlink as an object holding its target, recorded in the metadata as well
+func (c *Client) uploadSymlink(ctx context.Context, localPath string, linkInfo os.FileInfo, bucketName, s3Key string) (*TransferResult, error) {
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symlink %s: %w", localPath, err)
+	}
+
+	metadata := c.fileMetadata(linkInfo)
+	metadata[MetadataSymlink] = target
+
+	output, err := c.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(s3Key),
+		Body:          strings.NewReader(target),
+		ContentLength: aws.Int64(int64(len(target))),
+		Metadata:      metadata,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload symlink to s3: %w", classifyError(err, bucketName, ErrBucketNotFound))
+	}
+
+	return &TransferResult{
+		ETag:      trimETag(output.ETag),
+		Checksum:  fileutils.LinkChecksum(target),
+		ModTime:   linkInfo.ModTime(),
+		VersionID: aws.ToString(output.VersionId),
+		Size:      int64(len(target)),
+	}, nil
 }
 
 // downloadfile downloads a single file from s3
-func (c *Client) DownloadFile(ctx context.Context, bucketName, s3Key, localPath string) error {
+func (c *Client) DownloadFile(ctx context.Context, bucketName, s3Key, localPath string) (*TransferResult, error) {
 	// ensure local directory exists
 	localDir := filepath.Dir(localPath)
 	if err := fileutils.CreateDirIfNotExists(localDir); err != nil {
-		return fmt.Errorf("failed to create local directory: %w", err)
+		return nil, fmt.Errorf("failed to create local directory: %w", err)
 	}
 
 	// get object from s3
@@ -138,23 +199,85 @@ func (c *Client) DownloadFile(ctx context.Context, bucketName, s3Key, localPath
 		Key:    aws.String(s3Key),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to download file from s3: %w", err)
+		return nil, fmt.Errorf("failed to download file from s3: %w", classifyError(err, bucketName, ErrNoSuchKey))
 	}
 	defer result.Body.Close()
 
+	modTime := objectModTime(result.Metadata, aws.ToTime(result.LastModified))
+	if target, ok := result.Metadata[MetadataSymlink]; ok && c.symlinkPolicy() == fileutils.SymlinkPreserve {
+		if err := c.restoreSymlink(localPath, target, result.Metadata); err != nil {
+			return nil, err
+		}
+		return &TransferResult{
+			ETag:      trimETag(result.ETag),
+			Checksum:  fileutils.LinkChecksum(target),
+			ModTime:   modTime,
+			VersionID: aws.ToString(result.VersionId),
+			Size:      int64(len(target)),
+		}, nil
+	}
+
+	// never write through a symlink left at the destination
+	if info, err := os.Lstat(localPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(localPath); err != nil {
+			return nil, fmt.Errorf("failed to replace symlink %s: %w", localPath, err)
+		}
+	}
+
 	// create local file
 	file, err := os.Create(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to create local file %s: %w", localPath, err)
+		return nil, fmt.Errorf("failed to create local file %s: %w", localPath, err)
 	}
 	defer file.Close()
 
-	// copy data
-	_, err = io.Copy(file, result.Body)
+	// copy data, hashing it on the way so the manifest records the local checksum
+	hash := sha256.New()
+	written, err := io.Copy(progress.NewWriter(ctx, io.MultiWriter(file, hash)), result.Body)
 	if err != nil {
-		return fmt.Errorf("failed to write file data: %w", err)
+		return nil, fmt.Errorf("failed to write file data: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write file data: %w", err)
 	}
 
+	// restore the original timestamps; objects without metadata get their s3 upload time
+	accessTime, ok := parseMetadataTime(result.Metadata[MetadataATime])
+	if !ok {
+		accessTime = modTime
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(localPath, accessTime, modTime); err != nil {
+			return nil, fmt.Errorf("failed to set modification time of %s: %w", localPath, err)
+		}
+	}
+	if c.preserve() {
+		if err := restorePermissions(localPath, result.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	return &TransferResult{
+		ETag:      trimETag(result.ETag),
+		Checksum:  fmt.Sprintf("%x", hash.Sum(nil)),
+		ModTime:   modTime,
+		VersionID: aws.ToString(result.VersionId),
+		Size:      written,
+	}, nil
+}
+
+// restoresymlink replaces whatever is at localPath with a symlink to target
+func (c *Client) restoreSymlink(localPath, target string, metadata map[string]string) error {
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s with a symlink: %w", localPath, err)
+	}
+	if err := os.Symlink(target, localPath); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", localPath, err)
+	}
+	if c.preserve() {
+		// links have no permissions of their own; only ownership is restored
+		return restoreOwnership(localPath, metadata)
+	}
 	return nil
 }
 
@@ -178,7 +301,7 @@ func (c *Client) ListObjects(ctx context.Context, bucketName, prefix string) ([]
 
 		result, err := c.S3.ListObjectsV2(ctx, input)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+			return nil, fmt.Errorf("failed to list objects: %w", classifyError(err, bucketName, ErrBucketNotFound))
 		}
 
 		// process objects
@@ -199,17 +322,16 @@ func (c *Client) ListObjects(ctx context.Context, bucketName, prefix string) ([]
 				relativePath = strings.TrimPrefix(relativePath, "/")
 			}
 
-			// get object metadata for checksum
-			etag := ""
-			if obj.ETag != nil {
-				etag = strings.Trim(*obj.ETag, "\"")
-			}
+			// get object metadata for checksum; listings carry no user metadata, so the
+			// original mtime is only available through statobject
+			etag := trimETag(obj.ETag)
 
 			fileInfo := fileutils.FileInfo{
 				Path:         *obj.Key,
 				Size:         aws.ToInt64(obj.Size),
 				ModTime:      aws.ToTime(obj.LastModified),
 				Checksum:     etag, // use etag as checksum for s3 objects
+				ETag:         etag,
 				RelativePath: relativePath,
 			}
 
@@ -227,6 +349,99 @@ func (c *Client) ListObjects(ctx context.Context, bucketName, prefix string) ([]
 	return files, nil
 }
 
+// statobject returns the metadata of a single object. the modification time is taken from the
+// mtime metadata written on upload, falling back to the s3 lastmodified time.
+func (c *Client) StatObject(ctx context.Context, bucketName, s3Key string) (*fileutils.FileInfo, error) {
+	result, err := c.S3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		// head requests carry no error body, so classify by status code
+		return nil, fmt.Errorf("error reading object %s: %w", s3Key, classifyError(err, bucketName, ErrNoSuchKey))
+	}
+
+	etag := trimETag(result.ETag)
+	return &fileutils.FileInfo{
+		Path:         s3Key,
+		Size:         aws.ToInt64(result.ContentLength),
+		ModTime:      objectModTime(result.Metadata, aws.ToTime(result.LastModified)),
+		Checksum:     etag,
+		ETag:         etag,
+		RelativePath: s3Key,
+	}, nil
+}
+
+// getobjectdata reads a small object into memory and returns it with its etag
+func (c *Client) GetObjectData(ctx context.Context, bucketName, s3Key string) ([]byte, string, error) {
+	result, err := c.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read object %s: %w", s3Key, classifyError(err, bucketName, ErrNoSuchKey))
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read object %s: %w", s3Key, err)
+	}
+
+	return data, trimETag(result.ETag), nil
+}
+
+// readobject streams the content of an object to w without writing anything locally. unlike
+// downloadfile it applies no symlink, permission or timestamp handling; the result carries the
+// etag, the original modification time and the number of bytes read.
+func (c *Client) ReadObject(ctx context.Context, bucketName, s3Key string, w io.Writer) (*TransferResult, error) {
+	result, err := c.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(s3Key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", s3Key, classifyError(err, bucketName, ErrNoSuchKey))
+	}
+	defer result.Body.Close()
+
+	read, err := io.Copy(w, result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %w", s3Key, err)
+	}
+
+	return &TransferResult{
+		ETag:      trimETag(result.ETag),
+		ModTime:   objectModTime(result.Metadata, aws.ToTime(result.LastModified)),
+		VersionID: aws.ToString(result.VersionId),
+		Size:      read,
+	}, nil
+}
+
+// putobjectdata writes a small object conditionally and returns its new etag. with ifMatch set
+// the write only succeeds while the object still has that etag; with an empty ifMatch it only
+// succeeds if the object does not exist yet. a lost race returns errpreconditionfailed.
+func (c *Client) PutObjectData(ctx context.Context, bucketName, s3Key string, data []byte, ifMatch string) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucketName),
+		Key:           aws.String(s3Key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String("application/json"),
+	}
+	if ifMatch != "" {
+		input.IfMatch = aws.String("\"" + ifMatch + "\"")
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	output, err := c.S3.PutObject(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to write object %s: %w", s3Key, classifyError(err, bucketName, ErrBucketNotFound))
+	}
+
+	return trimETag(output.ETag), nil
+}
+
 // deleteobject deletes an object from s3
 func (c *Client) DeleteObject(ctx context.Context, bucketName, s3Key string) error {
 	_, err := c.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -235,7 +450,7 @@ func (c *Client) DeleteObject(ctx context.Context, bucketName, s3Key string) err
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to delete object %s: %w", s3Key, err)
+		return fmt.Errorf("failed to delete object %s: %w", s3Key, classifyError(err, bucketName, ErrBucketNotFound))
 	}
 
 	return nil
@@ -249,13 +464,18 @@ func (c *Client) ObjectExists(ctx context.Context, bucketName, s3Key string) (bo
 	})
 
 	if err != nil {
-		// check if error is "not found" vs other errors
-		var noSuchKey *types.NoSuchKey
-		if errors.As(err, &noSuchKey) {
+		// head requests carry no error body, so classify by status code
+		err = classifyError(err, bucketName, ErrNoSuchKey)
+		if errors.Is(err, ErrNoSuchKey) {
 			return false, nil
 		}
-		return false, fmt.Errorf("error checking object: %w", err)
+		return false, fmt.Errorf("error checking object %s: %w", s3Key, err)
 	}
 
 	return true, nil
 }
+
+// trimetag strips the quotes s3 puts around etags
+func trimETag(etag *string) string {
+	return strings.Trim(aws.ToString(etag), "\"")
+}
@@ -6,15 +6,18 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // config represents the application configuration
 type Config struct {
-	AWS     AWSConfig  `yaml:"aws"`
-	Sync    SyncConfig `yaml:"sync"`
-	Profile string     `yaml:"profile"`
+	AWS     AWSConfig    `yaml:"aws"`
+	Sync    SyncConfig   `yaml:"sync"`
+	Daemon  DaemonConfig `yaml:"daemon,omitempty"`
+	Hooks   []HookConfig `yaml:"hooks,omitempty"`
+	Profile string       `yaml:"profile"`
 }
 
 // awsconfig contains aws-specific configuration
@@ -32,7 +35,47 @@ type SyncConfig struct {
 	ExcludeFiles  []string `yaml:"exclude_files"`
 	IncludeFiles  []string `yaml:"include_files"`
 	MaxRetries    int      `yaml:"max_retries"`
-	ChunkSize     int64    `yaml:"chunk_size"` // in bytes
+	ChunkSize     int64    `yaml:"chunk_size"`     // in bytes
+	PreserveATime bool     `yaml:"preserve_atime"` // also store access times in object metadata
+	Preserve      bool     `yaml:"preserve"`       // store and restore permissions, ownership and symlinks
+	Symlinks      string   `yaml:"symlinks"`       // follow, preserve or skip
+	Checksum      bool     `yaml:"checksum"`       // hash every file instead of trusting the hash cache
+	RemoteState   bool     `yaml:"remote_state"`   // keep a shared state object in the bucket, listed only when another client changed it
+	Delta         bool     `yaml:"delta"`          // upload only the changed parts of large files
+	Prefix        string   `yaml:"prefix"`         // key prefix of the synced objects in the bucket
+}
+
+// daemonconfig contains the scheduled jobs of 's3sync daemon'
+type DaemonConfig struct {
+	Jobs        []JobConfig   `yaml:"jobs"`
+	Jitter      time.Duration `yaml:"jitter"`       // random delay of up to this much added to each scheduled run
+	MinBackoff  time.Duration `yaml:"min_backoff"`  // delay before retrying a failed job, doubled per failure
+	MaxBackoff  time.Duration `yaml:"max_backoff"`  // longest delay between retries
+	Control     string        `yaml:"control"`      // control api address: a socket path or a loopback host:port
+	MetricsAddr string        `yaml:"metrics_addr"` // host:port serving /metrics besides the control api
+}
+
+// jobconfig describes one scheduled sync between a local directory and a bucket prefix
+type JobConfig struct {
+	Name      string        `yaml:"name"`
+	LocalPath string        `yaml:"local_path"`
+	Bucket    string        `yaml:"bucket,omitempty"` // default bucket if empty
+	Prefix    string        `yaml:"prefix,omitempty"`
+	Direction string        `yaml:"direction"`          // push, pull or sync
+	Interval  time.Duration `yaml:"interval,omitempty"` // run every interval, or
+	Cron      string        `yaml:"cron,omitempty"`     // run at the times of a cron expression
+	Conflict  string        `yaml:"conflict,omitempty"` // conflict policy, newer if empty
+}
+
+// hookconfig describes a command or url notified of sync events
+type HookConfig struct {
+	Name    string        `yaml:"name,omitempty"`
+	Events  []string      `yaml:"events"`            // pre-sync, post-sync, on-error and per-file
+	Command string        `yaml:"command,omitempty"` // shell command reading the json event on stdin, or
+	URL     string        `yaml:"url,omitempty"`     // url the json event is posted to
+	Timeout time.Duration `yaml:"timeout,omitempty"` // limit of each attempt, 30s if zero
+	Retries int           `yaml:"retries,omitempty"` // attempts after the first one fails
+	Secret  string        `yaml:"secret,omitempty"`  // signs the event with an hmac-sha256 if set
 }
 
 // configmanager handles configuration operations
@@ -67,6 +110,12 @@ func (cm *ConfigManager) LoadConfig() (*Config, error) {
 			ExcludeFiles: []string{".DS_Store", "Thumbs.db", ".git/*"},
 			MaxRetries:   3,
 			ChunkSize:    8 * 1024 * 1024, // 8mb chunks
+			Symlinks:     "follow",
+		},
+		Daemon: DaemonConfig{
+			Jitter:     10 * time.Second,
+			MinBackoff: 30 * time.Second,
+			MaxBackoff: 30 * time.Minute,
 		},
 	}
 
@@ -0,0 +1,254 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// control api routes
+const (
+	controlStatus  = "/v1/status"
+	controlTrigger = "/v1/trigger"
+	controlPause   = "/v1/pause"
+	controlResume  = "/v1/resume"
+	controlReload  = "/v1/reload"
+)
+
+// controlresponse is the body of every control api response
+type ControlResponse struct {
+	Jobs  []JobStatus `json:"jobs,omitempty"`  // status of every job, for status
+	Done  []string    `json:"done,omitempty"`  // jobs that were triggered, paused or resumed
+	Error string      `json:"error,omitempty"` // set on failure
+}
+
+// listencontrol opens the control api address: a unix socket path, or host:port on a
+// loopback address since the api has no authentication
+func ListenControl(address string) (net.Listener, error) {
+	network, addr, err := controlNetwork(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		// the daemon lock guarantees a left-over socket belongs to a dead daemon
+		os.Remove(addr)
+		listener, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		if err := os.Chmod(addr, 0600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to restrict %s: %w", addr, err)
+		}
+		return listener, nil
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return listener, nil
+}
+
+// controlnetwork splits a control address into a network and an address to dial
+func controlNetwork(address string) (string, string, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", path, nil
+	}
+	if strings.ContainsRune(address, os.PathSeparator) || strings.HasSuffix(address, ".sock") {
+		return "unix", address, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid control address %q: expected a socket path or host:port", address)
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return "", "", fmt.Errorf("invalid control address %q: only loopback addresses are allowed", address)
+		}
+	}
+	return "tcp", address, nil
+}
+
+// newcontrolhandler serves the control api of a scheduler. reload reads the configuration
+// again and applies it to the scheduler.
+func NewControlHandler(s *Scheduler, reload func() error) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET "+controlStatus, func(w http.ResponseWriter, r *http.Request) {
+		writeControl(w, http.StatusOK, ControlResponse{Jobs: s.Status()})
+	})
+	mux.HandleFunc("POST "+controlTrigger, controlJobs(s, s.Trigger))
+	mux.HandleFunc("POST "+controlPause, controlJobs(s, s.Pause))
+	mux.HandleFunc("POST "+controlResume, controlJobs(s, s.Resume))
+	mux.HandleFunc("POST "+controlReload, func(w http.ResponseWriter, r *http.Request) {
+		if err := reload(); err != nil {
+			writeControl(w, http.StatusUnprocessableEntity, ControlResponse{Error: err.Error()})
+			return
+		}
+		writeControl(w, http.StatusOK, ControlResponse{Jobs: s.Status()})
+	})
+
+	return mux
+}
+
+// controljobs applies a control to the job named by the job parameter, or to every job
+// without one. running and paused jobs are left out when triggering every job.
+func controlJobs(s *Scheduler, control func(name string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names := []string{r.URL.Query().Get("job")}
+		if names[0] == "" {
+			names = names[:0]
+			for _, status := range s.Status() {
+				names = append(names, status.Name)
+			}
+		}
+
+		done := make([]string, 0, len(names))
+		for _, name := range names {
+			err := control(name)
+			switch {
+			case err == nil:
+				done = append(done, name)
+			case (errors.Is(err, ErrJobRunning) || errors.Is(err, ErrJobPaused)) && len(names) > 1:
+			case errors.Is(err, ErrUnknownJob):
+				writeControl(w, http.StatusNotFound, ControlResponse{Error: err.Error()})
+				return
+			case errors.Is(err, ErrJobRunning) || errors.Is(err, ErrJobPaused):
+				writeControl(w, http.StatusConflict, ControlResponse{Error: err.Error()})
+				return
+			default:
+				writeControl(w, http.StatusInternalServerError, ControlResponse{Error: err.Error()})
+				return
+			}
+		}
+		writeControl(w, http.StatusOK, ControlResponse{Done: done})
+	}
+}
+
+// writecontrol writes a control api response as json
+func writeControl(w http.ResponseWriter, status int, response ControlResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// controlclient talks to the control api of a running daemon
+type ControlClient struct {
+	http *http.Client
+	base string
+}
+
+// newcontrolclient creates a client for the control api at address
+func NewControlClient(address string) (*ControlClient, error) {
+	network, addr, err := controlNetwork(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+
+	base := "http://" + addr
+	if network == "unix" {
+		// the host is ignored by the dialer
+		base = "http://s3sync"
+	}
+	return &ControlClient{
+		http: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		base: base,
+	}, nil
+}
+
+// status returns the status of every job
+func (c *ControlClient) Status(ctx context.Context) ([]JobStatus, error) {
+	response, err := c.do(ctx, http.MethodGet, controlStatus, "")
+	if err != nil {
+		return nil, err
+	}
+	return response.Jobs, nil
+}
+
+// trigger runs a job, or every job if name is empty, as soon as possible
+func (c *ControlClient) Trigger(ctx context.Context, name string) ([]string, error) {
+	response, err := c.do(ctx, http.MethodPost, controlTrigger, name)
+	if err != nil {
+		return nil, err
+	}
+	return response.Done, nil
+}
+
+// pause pauses a job, or every job if name is empty
+func (c *ControlClient) Pause(ctx context.Context, name string) ([]string, error) {
+	response, err := c.do(ctx, http.MethodPost, controlPause, name)
+	if err != nil {
+		return nil, err
+	}
+	return response.Done, nil
+}
+
+// resume resumes a job, or every job if name is empty
+func (c *ControlClient) Resume(ctx context.Context, name string) ([]string, error) {
+	response, err := c.do(ctx, http.MethodPost, controlResume, name)
+	if err != nil {
+		return nil, err
+	}
+	return response.Done, nil
+}
+
+// reload makes the daemon read its configuration again and returns the new job status
+func (c *ControlClient) Reload(ctx context.Context) ([]JobStatus, error) {
+	response, err := c.do(ctx, http.MethodPost, controlReload, "")
+	if err != nil {
+		return nil, err
+	}
+	return response.Jobs, nil
+}
+
+// do sends a control request and decodes its response
+func (c *ControlClient) do(ctx context.Context, method, route, job string) (*ControlResponse, error) {
+	target := c.base + route
+	if job != "" {
+		target += "?job=" + url.QueryEscape(job)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the daemon, is it running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read daemon response: %w", err)
+	}
+	var response ControlResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid daemon response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if response.Error == "" {
+			response.Error = resp.Status
+		}
+		return nil, errors.New(response.Error)
+	}
+	return &response, nil
+}
@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errlocked is returned when another daemon already holds the lock
+var ErrLocked = errors.New("another s3sync daemon is already running")
+
+// lockederror reports the lock file and the process id found in it
+type LockedError struct {
+	Path  string
+	Owner string // pid written by the holder, may be empty
+}
+
+func (e *LockedError) Error() string {
+	if e.Owner != "" {
+		return fmt.Sprintf("%v (pid %s, lock %s)", ErrLocked, e.Owner, e.Path)
+	}
+	return fmt.Sprintf("%v (lock %s)", ErrLocked, e.Path)
+}
+
+func (e *LockedError) Is(target error) bool { return target == ErrLocked }
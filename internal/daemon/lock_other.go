@@ -0,0 +1,39 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// lock is an exclusive lock file, held until release
+type Lock struct {
+	path string
+}
+
+// acquirelock creates the lock file at path, failing with errlocked if it exists. without
+// flock a crashed daemon leaves the file behind and it has to be removed by hand.
+func AcquireLock(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		owner, _ := os.ReadFile(path)
+		return nil, &LockedError{Path: path, Owner: string(owner)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+	file.WriteString(strconv.Itoa(os.Getpid()))
+	file.Close()
+	return &Lock{path: path}, nil
+}
+
+// release removes the lock file
+func (l *Lock) Release() error {
+	return os.Remove(l.path)
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// lock is an exclusive lock on a file, held until release or until the process exits
+type Lock struct {
+	file *os.File
+}
+
+// acquirelock takes the lock file at path, failing with errlocked if another process holds it.
+// the kernel drops the lock when the process dies, so a crash leaves no stale lock behind.
+func AcquireLock(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		owner, _ := os.ReadFile(path)
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, &LockedError{Path: path, Owner: string(owner)}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// record the owner for the error message of the next instance
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return &Lock{file: file}, nil
+}
+
+// release drops the lock
+func (l *Lock) Release() error {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	return l.file.Close()
+}
@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// monday, january 1st 2024
+	start := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", start, at(1, 1, 10, 45)},
+		{"5-10/2 * * * *", start, at(1, 1, 11, 5)},
+		{"0 9 * * *", start, at(1, 2, 9, 0)},
+		{"0 0 * 2 *", start, at(2, 1, 0, 0)},
+		{"@monthly", start, at(2, 1, 0, 0)},
+		{"@hourly", start, at(1, 1, 11, 0)},
+		// sunday is 0 or 7
+		{"0 0 * * 0", start, at(1, 7, 0, 0)},
+		{"0 0 * * 7", start, at(1, 7, 0, 0)},
+		{"0 0 * * 5-7", start, at(1, 5, 0, 0)},
+		// with both day fields restricted either one matches
+		{"0 0 2 * 5", start, at(1, 2, 0, 0)},
+		{"0 0 2 * 5", at(1, 3, 0, 0), at(1, 5, 0, 0)},
+		{"0 0 2 * 5", at(1, 5, 0, 0), at(1, 12, 0, 0)},
+		// with one of them * only the other one counts
+		{"0 0 2 * *", at(1, 3, 0, 0), at(2, 2, 0, 0)},
+		{"0 0 * * 5", at(1, 2, 0, 0), at(1, 5, 0, 0)},
+		{"0 0 30 2 *", start, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			schedule, err := ParseCron(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(test.from); !got.Equal(test.want) {
+				t.Errorf("next after %s is %s, want %s", test.from, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q was accepted", expr)
+		}
+	}
+}
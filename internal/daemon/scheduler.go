@@ -0,0 +1,454 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errors returned by the job controls
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrJobRunning = errors.New("job is already running")
+	ErrJobPaused  = errors.New("job is paused")
+)
+
+// job is a named task run on a schedule
+type Job struct {
+	Name        string
+	Description string // what the job does, shown in its status
+	Schedule    Schedule
+	Run         func(ctx context.Context, run *Run) error
+}
+
+// run is one execution of a job. the job reports its transfers and result through it while
+// the control api reads them.
+type Run struct {
+	mu        sync.Mutex
+	started   time.Time
+	transfers map[string]Transfer
+	result    json.RawMessage
+}
+
+// transfer is a file being synced by a running job
+type Transfer struct {
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Started   time.Time `json:"started"`
+}
+
+// begin records a transfer that started
+func (r *Run) Begin(transfer Transfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if transfer.Started.IsZero() {
+		transfer.Started = time.Now()
+	}
+	r.transfers[transfer.Path] = transfer
+}
+
+// end records that the transfer of a path finished
+func (r *Run) End(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.transfers, path)
+}
+
+// setresult records the outcome of the run, kept as the last result of the job
+func (r *Run) SetResult(result any) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result = data
+}
+
+// snapshot returns the transfers in flight ordered by start
+func (r *Run) snapshot() []Transfer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	transfers := make([]Transfer, 0, len(r.transfers))
+	for _, transfer := range r.transfers {
+		transfers = append(transfers, transfer)
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].Started.Before(transfers[j].Started)
+	})
+	return transfers
+}
+
+// jobstatus is the state of a job as reported by the control api
+type JobStatus struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Schedule    string     `json:"schedule"`
+	Running     bool       `json:"running"`
+	RunStarted  *time.Time `json:"run_started,omitempty"`
+	Transfers   []Transfer `json:"transfers"`
+	JobState
+}
+
+// scheduler runs jobs on their schedules, one at a time so that jobs sharing a directory or
+// a prefix never overlap. each run is delayed by a random jitter so that jobs due together,
+// and daemons on several hosts, do not hit s3 at the same moment. a failed job is retried
+// after a backoff that doubles with every consecutive failure instead of on its schedule.
+// jobs can be triggered, paused, resumed and replaced while the scheduler runs.
+type Scheduler struct {
+	Jobs       []Job
+	Jitter     time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// state is updated after every run and written to statepath, if set
+	State     *State
+	StatePath string
+
+	// onstart and onfinish are called around each run, if set
+	OnStart  func(job Job)
+	OnFinish func(job Job, state JobState, err error)
+
+	mu      sync.Mutex
+	running map[string]*Run
+	wake    chan struct{}
+}
+
+// run runs the jobs until ctx is done. jobs that never ran, or whose next run passed while
+// the daemon was stopped, run right away; paused jobs wait until they are resumed.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if len(s.Jobs) == 0 {
+		s.mu.Unlock()
+		return errors.New("no jobs configured")
+	}
+	s.init()
+	now := time.Now()
+	for _, job := range s.Jobs {
+		state := s.State.job(job.Name)
+		state.NextRun = s.nextRun(job, *state, now)
+	}
+	err := s.save()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for {
+		job, next, ok := s.due()
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if ok {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+
+		woken := false
+		select {
+		case <-ctx.Done():
+		case <-s.wake:
+			// the jobs or their schedule changed
+			woken = true
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if woken {
+			continue
+		}
+
+		if err := s.runJob(ctx, job); err != nil && !errors.Is(err, ErrJobRunning) {
+			return err
+		}
+	}
+}
+
+// runonce runs every job once in order and returns the errors of the failed ones
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	s.mu.Lock()
+	s.init()
+	jobs := s.Jobs
+	s.mu.Unlock()
+
+	var errs []error
+	for _, job := range jobs {
+		if err := s.runJob(ctx, job); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		lastError := s.State.Jobs[job.Name].LastError
+		s.mu.Unlock()
+		if lastError != "" {
+			errs = append(errs, fmt.Errorf("job %s: %s", job.Name, lastError))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// trigger runs a job as soon as the running job, if any, finished. paused jobs have to be
+// resumed first.
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.job(name); !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+	if s.running[name] != nil {
+		return fmt.Errorf("%w: %s", ErrJobRunning, name)
+	}
+	state := s.State.job(name)
+	if state.Paused {
+		return fmt.Errorf("%w: %s", ErrJobPaused, name)
+	}
+	state.NextRun = time.Now()
+	s.notify()
+	return nil
+}
+
+// pause stops a job from being scheduled until it is resumed; a running job finishes its run
+func (s *Scheduler) Pause(name string) error {
+	return s.setPaused(name, true)
+}
+
+// resume schedules a paused job again
+func (s *Scheduler) Resume(name string) error {
+	return s.setPaused(name, false)
+}
+
+// setpaused changes and saves the paused flag of a job
+func (s *Scheduler) setPaused(name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.job(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+	state := s.State.job(name)
+	if state.Paused == paused {
+		return nil
+	}
+	state.Paused = paused
+	if !paused && state.NextRun.Before(time.Now()) {
+		// the run missed while paused happens now
+		state.NextRun = s.nextRun(job, *state, time.Now())
+	}
+	s.notify()
+	return s.save()
+}
+
+// reload replaces the jobs and the retry settings. jobs keep their state by name; new jobs
+// run right away and jobs no longer configured are forgotten once their current run finished.
+func (s *Scheduler) Reload(jobs []Job, jitter, minBackoff, maxBackoff time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	s.Jobs = jobs
+	s.Jitter = jitter
+	s.MinBackoff = minBackoff
+	s.MaxBackoff = maxBackoff
+
+	now := time.Now()
+	configured := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		configured[job.Name] = true
+		state := s.State.job(job.Name)
+		if s.running[job.Name] == nil {
+			state.NextRun = s.nextRun(job, *state, now)
+		}
+	}
+	for name := range s.State.Jobs {
+		if !configured[name] && s.running[name] == nil {
+			delete(s.State.Jobs, name)
+		}
+	}
+	s.notify()
+	return s.save()
+}
+
+// status returns the state of every job in configuration order
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.Jobs))
+	for _, job := range s.Jobs {
+		status := JobStatus{
+			Name:        job.Name,
+			Description: job.Description,
+			Schedule:    job.Schedule.String(),
+			Transfers:   []Transfer{},
+		}
+		if state, ok := s.State.Jobs[job.Name]; ok {
+			status.JobState = *state
+		}
+		if run := s.running[job.Name]; run != nil {
+			status.Running = true
+			status.RunStarted = &run.started
+			status.Transfers = run.snapshot()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// runjob runs a job, records the outcome and schedules its next run. only failing to save
+// the state or cancellation of ctx is returned; job failures are recorded in the state.
+func (s *Scheduler) runJob(ctx context.Context, job Job) error {
+	run := &Run{started: time.Now(), transfers: make(map[string]Transfer)}
+	s.mu.Lock()
+	s.init()
+	if s.running[job.Name] != nil {
+		s.mu.Unlock()
+		return ErrJobRunning
+	}
+	s.running[job.Name] = run
+	s.mu.Unlock()
+
+	if s.OnStart != nil {
+		s.OnStart(job)
+	}
+	err := job.Run(ctx, run)
+
+	s.mu.Lock()
+	delete(s.running, job.Name)
+	if ctx.Err() != nil {
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+
+	state := s.State.job(job.Name)
+	state.LastRun = run.started
+	state.Duration = time.Since(run.started).Seconds()
+	state.LastResult = run.result
+	if err != nil {
+		state.LastError = err.Error()
+		state.Failures++
+	} else {
+		state.LastSuccess = run.started
+		state.LastError = ""
+		state.Failures = 0
+	}
+	state.NextRun = s.nextRun(job, *state, time.Now())
+	finished := *state
+	saveErr := s.save()
+	s.mu.Unlock()
+
+	if s.OnFinish != nil {
+		s.OnFinish(job, finished, err)
+	}
+	return saveErr
+}
+
+// nextrun returns when a job runs next: after its backoff if the last run failed, otherwise
+// at the next time of its schedule after the last run, plus jitter. runs that are already due
+// happen at now plus jitter.
+func (s *Scheduler) nextRun(job Job, state JobState, now time.Time) time.Time {
+	var next time.Time
+	switch {
+	case state.LastRun.IsZero():
+		next = now
+	case state.Failures > 0:
+		next = state.LastRun.Add(s.backoff(state.Failures))
+	default:
+		next = job.Schedule.Next(state.LastRun)
+		if next.IsZero() {
+			// the schedule never matches again; check back in a year
+			next = now.AddDate(1, 0, 0)
+		}
+	}
+	if next.Before(now) {
+		next = now
+	}
+	return next.Add(s.jitter())
+}
+
+// backoff returns the retry delay after the given number of consecutive failures. a zero
+// maxbackoff leaves the delay unbounded.
+func (s *Scheduler) backoff(failures int) time.Duration {
+	delay := s.MinBackoff
+	for i := 1; i < failures; i++ {
+		if s.MaxBackoff > 0 && delay >= s.MaxBackoff || delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if s.MaxBackoff > 0 && delay > s.MaxBackoff {
+		delay = s.MaxBackoff
+	}
+	return delay
+}
+
+// jitter returns a random delay below the configured jitter
+func (s *Scheduler) jitter() time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(s.Jitter)
+}
+
+// due returns the unpaused job with the earliest next run, if any
+func (s *Scheduler) due() (Job, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next Job
+	var nextRun time.Time
+	found := false
+	for _, job := range s.Jobs {
+		state := s.State.job(job.Name)
+		if state.Paused {
+			continue
+		}
+		if !found || state.NextRun.Before(nextRun) {
+			next, nextRun, found = job, state.NextRun, true
+		}
+	}
+	return next, nextRun, found
+}
+
+// job returns the configured job with a name
+func (s *Scheduler) job(name string) (Job, bool) {
+	for _, job := range s.Jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// init creates the run bookkeeping on first use; callers hold mu
+func (s *Scheduler) init() {
+	if s.running == nil {
+		s.running = make(map[string]*Run)
+		s.wake = make(chan struct{}, 1)
+	}
+}
+
+// notify wakes the run loop to pick up changed jobs; callers hold mu
+func (s *Scheduler) notify() {
+	s.init()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the state file, if one is configured; callers hold mu
+func (s *Scheduler) save() error {
+	if s.StatePath == "" {
+		return nil
+	}
+	return s.State.Save(s.StatePath)
+}
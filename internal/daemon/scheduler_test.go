@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		min, max time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{time.Minute, 10 * time.Minute, 1, time.Minute},
+		{time.Minute, 10 * time.Minute, 2, 2 * time.Minute},
+		{time.Minute, 10 * time.Minute, 4, 8 * time.Minute},
+		{time.Minute, 10 * time.Minute, 5, 10 * time.Minute},
+		{time.Minute, 10 * time.Minute, 100, 10 * time.Minute},
+		{time.Minute, 0, 3, 4 * time.Minute},
+	}
+
+	for _, test := range tests {
+		s := &Scheduler{MinBackoff: test.min, MaxBackoff: test.max}
+		if got := s.backoff(test.failures); got != test.want {
+			t.Errorf("backoff between %s and %s after %d failures is %s, want %s", test.min, test.max, test.failures, got, test.want)
+		}
+	}
+
+	// an unbounded backoff stops doubling before it overflows
+	s := &Scheduler{MinBackoff: time.Minute}
+	if got := s.backoff(1000); got < time.Minute {
+		t.Errorf("backoff after 1000 failures is %s", got)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	job := Job{Name: "job", Schedule: Every(time.Hour)}
+	s := &Scheduler{MinBackoff: time.Minute, MaxBackoff: time.Hour}
+
+	tests := []struct {
+		name  string
+		state JobState
+		want  time.Time
+	}{
+		{"never ran", JobState{}, now},
+		{"succeeded", JobState{LastRun: now.Add(-10 * time.Minute)}, now.Add(50 * time.Minute)},
+		{"overdue", JobState{LastRun: now.Add(-2 * time.Hour)}, now},
+		// a failed run is retried after its backoff rather than on the schedule
+		{"failed once", JobState{LastRun: now.Add(-30 * time.Second), Failures: 1}, now.Add(30 * time.Second)},
+		{"failed three times", JobState{LastRun: now, Failures: 3}, now.Add(4 * time.Minute)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := s.nextRun(job, test.state, now); !got.Equal(test.want) {
+				t.Errorf("next run at %s, want %s", got, test.want)
+			}
+		})
+	}
+}
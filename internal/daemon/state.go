@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// jobstate is what the daemon remembers about a job between runs and restarts
+type JobState struct {
+	LastRun     time.Time `json:"last_run"`             // start of the last run
+	LastSuccess time.Time `json:"last_success"`         // start of the last successful run
+	LastError   string    `json:"last_error,omitempty"` // error of the last run, if it failed
+	Duration    float64   `json:"duration_seconds"`     // length of the last run
+	Failures    int       `json:"failures"`             // consecutive failed runs
+	NextRun     time.Time `json:"next_run"`
+	Paused      bool      `json:"paused"`
+
+	LastResult json.RawMessage `json:"last_result,omitempty"` // outcome reported by the last run
+}
+
+// state holds the job states of the daemon, keyed by job name
+type State struct {
+	Jobs map[string]*JobState `json:"jobs"`
+}
+
+// loadstate reads the daemon state file, returning an empty state if it does not exist
+func LoadState(path string) (*State, error) {
+	state := &State{Jobs: make(map[string]*JobState)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read daemon state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse daemon state %s: %w", path, err)
+	}
+	if state.Jobs == nil {
+		state.Jobs = make(map[string]*JobState)
+	}
+	return state, nil
+}
+
+// save writes the state atomically so a crash never leaves a truncated file behind
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal daemon state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create daemon state directory: %w", err)
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write daemon state: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write daemon state: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to write daemon state: %w", err)
+	}
+	if err := os.Rename(tempFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to write daemon state: %w", err)
+	}
+	return nil
+}
+
+// job returns the state of a job, creating it on first use
+func (s *State) job(name string) *JobState {
+	state, ok := s.Jobs[name]
+	if !ok {
+		state = &JobState{}
+		s.Jobs[name] = state
+	}
+	return state
+}
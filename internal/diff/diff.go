@@ -0,0 +1,342 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxtextsize is the largest file shown as a line diff; larger files are compared by metadata only
+const MaxTextSize = 4 * 1024 * 1024
+
+// maxedits bounds the inserted and deleted lines of a line diff, whose cost grows with the lines
+// times the edits; texts that differ more are compared by metadata only
+const MaxEdits = 10000
+
+// errtoodifferent is returned for texts that differ in more than maxedits lines
+var ErrTooDifferent = errors.New("texts differ in too many lines to diff")
+
+// editkind is the type of a single line edit
+type editKind int
+
+const (
+	editEqual editKind = iota
+	editDelete
+	editInsert
+)
+
+// edit is a single line of a diff script
+type edit struct {
+	kind    editKind
+	oldLine int // index into the old lines, -1 for inserts
+	newLine int // index into the new lines, -1 for deletes
+	oldPos  int // old lines before this edit
+	newPos  int // new lines before this edit
+}
+
+// istext reports whether data looks like text: valid utf-8 without nul bytes in its first 8 kb
+func IsText(data []byte) bool {
+	sample := data
+	if len(sample) > 8*1024 {
+		sample = sample[:8*1024]
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return false
+	}
+	// a multi-byte rune may be cut at the end of the sample
+	for i := 0; i < utf8.UTFMax && len(sample) > 0 && !utf8.Valid(sample); i++ {
+		sample = sample[:len(sample)-1]
+	}
+	return utf8.Valid(sample)
+}
+
+// unified returns a unified diff of two texts with the given number of context lines,
+// or an empty string if they are equal
+func Unified(oldName, newName string, oldText, newText []byte, context int) (string, error) {
+	oldLines := splitLines(string(oldText))
+	newLines := splitLines(string(newText))
+
+	edits, err := lineEdits(oldLines, newLines)
+	if err != nil {
+		return "", err
+	}
+	hunks := groupHunks(edits, context)
+	if len(hunks) == 0 {
+		return "", nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	for _, hunk := range hunks {
+		oldStart, oldCount, newStart, newCount := hunkRange(hunk)
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", formatRange(oldStart, oldCount), formatRange(newStart, newCount))
+
+		for _, e := range hunk {
+			var prefix, line string
+			switch e.kind {
+			case editEqual:
+				prefix, line = " ", oldLines[e.oldLine]
+			case editDelete:
+				prefix, line = "-", oldLines[e.oldLine]
+			case editInsert:
+				prefix, line = "+", newLines[e.newLine]
+			}
+			b.WriteString(prefix)
+			b.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+
+	return b.String(), nil
+}
+
+// stats counts inserted and deleted lines between two texts
+func Stats(oldText, newText []byte) (inserted, deleted int, err error) {
+	edits, err := lineEdits(splitLines(string(oldText)), splitLines(string(newText)))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range edits {
+		switch e.kind {
+		case editInsert:
+			inserted++
+		case editDelete:
+			deleted++
+		}
+	}
+	return inserted, deleted, nil
+}
+
+// splitlines splits text into lines, keeping line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineedits computes a shortest edit script with the linear-space variant of the myers diff
+// algorithm, so that memory stays proportional to the number of lines however much they differ
+func lineEdits(a, b []string) ([]edit, error) {
+	// compare lines by number rather than by content
+	ids := make(map[string]int, len(a)+len(b))
+	number := func(lines []string) []int {
+		numbered := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			numbered[i] = id
+		}
+		return numbered
+	}
+
+	size := 2*(len(a)+len(b)) + 3
+	d := &differ{a: number(a), b: number(b), forward: make([]int, size), backward: make([]int, size)}
+	d.edits = make([]edit, 0, max(len(a), len(b)))
+	if !d.compare(0, len(a), 0, len(b)) {
+		return nil, ErrTooDifferent
+	}
+
+	oldPos, newPos := 0, 0
+	for i := range d.edits {
+		d.edits[i].oldPos, d.edits[i].newPos = oldPos, newPos
+		if d.edits[i].kind != editInsert {
+			oldPos++
+		}
+		if d.edits[i].kind != editDelete {
+			newPos++
+		}
+	}
+	return d.edits, nil
+}
+
+// differ holds the numbered lines and the diagonal buffers shared by the recursive steps
+type differ struct {
+	a, b     []int
+	forward  []int // furthest x on each diagonal of the forward search
+	backward []int // furthest x on each diagonal of the backward search, counted from the end
+	edits    []edit
+}
+
+// compare appends the edits turning a[aLo:aHi] into b[bLo:bHi], splitting the ranges at the
+// middle snake of a shortest edit script until one side is empty. it reports false when the
+// ranges differ in more than maxedits lines.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) bool {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.edits = append(d.edits, edit{kind: editEqual, oldLine: aLo, newLine: bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-1-suffix] == d.b[bHi-1-suffix] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi:
+		for y := bLo; y < bHi; y++ {
+			d.edits = append(d.edits, edit{kind: editInsert, oldLine: -1, newLine: y})
+		}
+	case bLo == bHi:
+		for x := aLo; x < aHi; x++ {
+			d.edits = append(d.edits, edit{kind: editDelete, oldLine: x, newLine: -1})
+		}
+	default:
+		// both sides differ at their ends, so the script has at least two edits and each half
+		// needs fewer
+		x, y, u, v, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+		if !ok || !d.compare(aLo, x, bLo, y) {
+			return false
+		}
+		for ; x < u; x, y = x+1, y+1 {
+			d.edits = append(d.edits, edit{kind: editEqual, oldLine: x, newLine: y})
+		}
+		if !d.compare(u, aHi, v, bHi) {
+			return false
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.edits = append(d.edits, edit{kind: editEqual, oldLine: aHi + i, newLine: bHi + i})
+	}
+	return true
+}
+
+// middlesnake searches a shortest edit script from both ends at once and returns the snake
+// (x, y) to (u, v) where the searches meet. each search step covers two edits, so it gives up
+// once the script is known to need more than maxedits.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	offset := limit + 1
+	forward, backward := d.forward, d.backward
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	for step := 0; step <= limit; step++ {
+		if 2*step-1 > MaxEdits {
+			return 0, 0, 0, 0, false
+		}
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// the backward search counts diagonals from the end, where this one is delta-k
+			if back := delta - k; odd && back >= -(step-1) && back <= step-1 && x+backward[offset+back] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y, true
+			}
+		}
+
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if ahead := delta - k; !odd && ahead >= -step && ahead <= step && x+forward[offset+ahead] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY, true
+			}
+		}
+	}
+
+	// the searches always meet by the time each has covered half the script
+	panic("diff: no middle snake")
+}
+
+// grouphunks splits an edit script into hunks of changes with surrounding context
+func groupHunks(edits []edit, context int) [][]edit {
+	var hunks [][]edit
+	start, end := -1, -1
+
+	for i, e := range edits {
+		if e.kind == editEqual {
+			continue
+		}
+		from := i - context
+		if from < 0 {
+			from = 0
+		}
+		to := i + context + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		if start >= 0 && from <= end {
+			end = to
+			continue
+		}
+		if start >= 0 {
+			hunks = append(hunks, edits[start:end])
+		}
+		start, end = from, to
+	}
+	if start >= 0 {
+		hunks = append(hunks, edits[start:end])
+	}
+
+	return hunks
+}
+
+// hunkrange returns the start lines and line counts of a hunk; an empty side
+// starts at the line it follows, as in diff -u
+func hunkRange(hunk []edit) (oldStart, oldCount, newStart, newCount int) {
+	for _, e := range hunk {
+		if e.kind != editInsert {
+			oldCount++
+		}
+		if e.kind != editDelete {
+			newCount++
+		}
+	}
+
+	oldStart, newStart = hunk[0].oldPos, hunk[0].newPos
+	if oldCount > 0 {
+		oldStart++
+	}
+	if newCount > 0 {
+		newStart++
+	}
+
+	return oldStart, oldCount, newStart, newCount
+}
+
+// formatrange formats a hunk range the way diff -u does
+func formatRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,100 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// editdistance is the number of inserted and deleted lines of a shortest edit script, by dynamic
+// programming over the longest common subsequence
+func editDistance(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return len(a) + len(b) - 2*lcs[0][0]
+}
+
+func TestLineEditsAreShortestScripts(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for round := 0; round < 500; round++ {
+		a := make([]string, random.Intn(30))
+		for i := range a {
+			a[i] = string(rune('a' + random.Intn(4)))
+		}
+		b := make([]string, random.Intn(30))
+		for i := range b {
+			b[i] = string(rune('a' + random.Intn(4)))
+		}
+
+		edits, err := lineEdits(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rebuilt []string
+		changes, oldLine := 0, 0
+		for _, e := range edits {
+			switch e.kind {
+			case editEqual:
+				if a[e.oldLine] != b[e.newLine] || e.oldLine != oldLine {
+					t.Fatalf("%v -> %v: bad equal edit %+v", a, b, e)
+				}
+				rebuilt = append(rebuilt, a[e.oldLine])
+				oldLine++
+			case editDelete:
+				if e.oldLine != oldLine {
+					t.Fatalf("%v -> %v: out of order delete %+v", a, b, e)
+				}
+				oldLine++
+				changes++
+			case editInsert:
+				rebuilt = append(rebuilt, b[e.newLine])
+				changes++
+			}
+		}
+		if strings.Join(rebuilt, "") != strings.Join(b, "") || oldLine != len(a) {
+			t.Fatalf("%v -> %v: script rebuilds %v", a, b, rebuilt)
+		}
+		if want := editDistance(a, b); changes != want {
+			t.Fatalf("%v -> %v: %d changes, shortest script has %d", a, b, changes, want)
+		}
+	}
+}
+
+func TestStatsOfLargeUnrelatedTexts(t *testing.T) {
+	var old, new strings.Builder
+	for i := 0; i < MaxEdits/2; i++ {
+		fmt.Fprintf(&old, "old line %d\n", i)
+		fmt.Fprintf(&new, "new line %d\n", i)
+	}
+	inserted, deleted, err := Stats([]byte(old.String()), []byte(new.String()))
+	if err != nil || inserted != MaxEdits/2 || deleted != MaxEdits/2 {
+		t.Errorf("got %d inserted and %d deleted lines (%v), want %d each", inserted, deleted, err, MaxEdits/2)
+	}
+
+	// texts differing in more lines give up early instead of taking minutes
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&old, "old line %d\n", i)
+		fmt.Fprintf(&new, "new line %d\n", i)
+	}
+	started := time.Now()
+	if _, _, err := Stats([]byte(old.String()), []byte(new.String())); !errors.Is(err, ErrTooDifferent) {
+		t.Errorf("got %v for texts differing in every line, want ErrTooDifferent", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("giving up took %v", elapsed)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package fileutils
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// accesstime returns the last access time of a file, or the zero time if it is unknown
+func AccessTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+}
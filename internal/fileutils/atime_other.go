@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package fileutils
+
+import (
+	"os"
+	"time"
+)
+
+// accesstime returns the zero time on platforms without access time support
+func AccessTime(info os.FileInfo) time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,58 @@
+package fileutils
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// matchesetag reports whether a file has the content an s3 etag describes. single-part etags
+// are the md5 of the object; multipart etags ("<md5>-<parts>") are the md5 of the part md5s,
+// which can only be reproduced when the object was uploaded with partSize parts. etags of
+// encrypted objects never match.
+func MatchesETag(filePath, etag string, partSize int64) (bool, error) {
+	etag = strings.Trim(etag, "\"")
+	digest, partsField, multipart := strings.Cut(etag, "-")
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	if !multipart {
+		hash := md5.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return false, err
+		}
+		return fmt.Sprintf("%x", hash.Sum(nil)) == digest, nil
+	}
+
+	parts, err := strconv.Atoi(partsField)
+	if err != nil || parts <= 0 || partSize <= 0 {
+		return false, nil
+	}
+
+	combined := md5.New()
+	count := 0
+	for {
+		part := md5.New()
+		written, err := io.CopyN(part, file, partSize)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if written == 0 {
+			break
+		}
+		combined.Write(part.Sum(nil))
+		count++
+		if written < partSize {
+			break
+		}
+	}
+
+	return count == parts && fmt.Sprintf("%x", combined.Sum(nil)) == digest, nil
+}
@@ -6,16 +6,24 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // fileinfo represents metadata about a file
 type FileInfo struct {
-	Path         string    `json:"path"`
-	Size         int64     `json:"size"`
-	ModTime      time.Time `json:"mod_time"`
-	Checksum     string    `json:"checksum"`
-	RelativePath string    `json:"relative_path"`
+	Path         string      `json:"path"`
+	Size         int64       `json:"size"`
+	ModTime      time.Time   `json:"mod_time"`
+	Checksum     string      `json:"checksum"`
+	ETag         string      `json:"etag,omitempty"`        // remote etag, set for s3 objects and synced manifest entries
+	Mode         os.FileMode `json:"mode,omitempty"`        // permission bits, recorded with --preserve
+	UID          *int        `json:"uid,omitempty"`         // owner, recorded with --preserve
+	GID          *int        `json:"gid,omitempty"`         // group, recorded with --preserve
+	LinkTarget   string      `json:"link_target,omitempty"` // target of a preserved symlink
+	PartSize     int64       `json:"part_size,omitempty"`   // part size of a delta upload
+	PartHashes   []string    `json:"part_hashes,omitempty"` // sha256 of each uploaded part, for delta uploads
+	RelativePath string      `json:"relative_path"`
 }
 
 // scandirectory walks a directory and returns a list of files.
@@ -38,63 +46,48 @@ func ScanDirectory(rootDir string) ([]string, error) {
 	return files, nil
 }
 
-// scandirectorywithinfo walks a directory and returns detailed file information
-func ScanDirectoryWithInfo(rootDir string) ([]FileInfo, error) {
-	var files []FileInfo
-
-	// ensure root directory exists
-	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory does not exist: %s", rootDir)
-	}
-
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// skip hidden files and system files
-		if filepath.Base(path)[0] == '.' {
-			return nil
-		}
-
-		// get relative path from root
-		relPath, err := filepath.Rel(rootDir, path)
-		if err != nil {
-			return err
-		}
+// symlinkpolicy decides how symbolic links are handled while scanning and transferring
+type SymlinkPolicy string
 
-		// calculate file checksum
-		checksum, err := calculateFileChecksum(path)
-		if err != nil {
-			return fmt.Errorf("failed to calculate checksum for %s: %w", path, err)
-		}
+const (
+	SymlinkFollow   SymlinkPolicy = "follow"   // sync the file or directory a link points to
+	SymlinkPreserve SymlinkPolicy = "preserve" // sync the link itself, storing its target in object metadata
+	SymlinkSkip     SymlinkPolicy = "skip"     // ignore links
+)
 
-		fileInfo := FileInfo{
-			Path:         path,
-			Size:         info.Size(),
-			ModTime:      info.ModTime(),
-			Checksum:     checksum,
-			RelativePath: relPath,
-		}
+// parsesymlinkpolicy validates a symlink policy name; an empty name means follow
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(strings.ToLower(name)); policy {
+	case "":
+		return SymlinkFollow, nil
+	case SymlinkFollow, SymlinkPreserve, SymlinkSkip:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid symlink policy %q (expected follow, preserve or skip)", name)
+}
 
-		files = append(files, fileInfo)
-		return nil
-	})
+// scanoptions controls how a directory is scanned
+type ScanOptions struct {
+	Symlinks SymlinkPolicy
+	Preserve bool       // record permissions and ownership
+	Cache    *HashCache // reuse checksums of files whose inode, size and mtime are unchanged
+	Checksum bool       // hash every file even when the cache has an entry, refreshing the cache
+	Workers  int        // files hashed concurrently, defaults to the number of cpus
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error scanning directory: %w", err)
-	}
+// scandirectorywithinfo walks a directory and returns detailed file information, following symlinks
+func ScanDirectoryWithInfo(rootDir string) ([]FileInfo, error) {
+	return ScanDirectoryWithOptions(rootDir, ScanOptions{Symlinks: SymlinkFollow})
+}
 
-	return files, nil
+// linkchecksum returns the checksum recorded for a symlink: the sha256 of its target, which is
+// also the content of the object a preserved link is uploaded as
+func LinkChecksum(target string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(target)))
 }
 
-// calculatefilechecksum computes the sha256 checksum of a file
-func calculateFileChecksum(filePath string) (string, error) {
+// filechecksum computes the sha256 checksum of a file
+func FileChecksum(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
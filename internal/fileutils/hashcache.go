@@ -0,0 +1,133 @@
+package fileutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hashcacheversion is the format version of the hash cache file
+const hashCacheVersion = 1
+
+// racywindow is how recently a file may have been modified and still be cached. a write in the
+// same timestamp tick as the hash would otherwise go unnoticed.
+const racyWindow = 2 * time.Second
+
+// hashcacheentry is the cached checksum of a file together with the stat data it is valid for
+type hashCacheEntry struct {
+	Inode     uint64 `json:"inode,omitempty"`
+	Size      int64  `json:"size"`
+	ModTimeNs int64  `json:"mtime_ns"`
+	Checksum  string `json:"sha256"`
+}
+
+// hashcachefile is the on-disk form of a hash cache
+type hashCacheFile struct {
+	Version int                       `json:"version"`
+	Entries map[string]hashCacheEntry `json:"entries"`
+}
+
+// hashcache remembers file checksums keyed by relative path, inode, size and modification time
+// so unchanged files are not re-read on every scan. it is safe for concurrent use.
+type HashCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]hashCacheEntry // loaded from disk
+	seen    map[string]hashCacheEntry // entries confirmed or added by the current scan
+	dirty   bool
+}
+
+// loadhashcache reads a hash cache file; a missing or unreadable cache starts empty
+func LoadHashCache(path string) *HashCache {
+	cache := &HashCache{
+		path:    path,
+		entries: make(map[string]hashCacheEntry),
+		seen:    make(map[string]hashCacheEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	var file hashCacheFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != hashCacheVersion {
+		// a corrupt or outdated cache only costs a rehash
+		cache.dirty = true
+		return cache
+	}
+	if file.Entries != nil {
+		cache.entries = file.Entries
+	}
+
+	return cache
+}
+
+// lookup returns the cached checksum of a file if its inode, size and modification time match
+func (c *HashCache) Lookup(relativePath string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[relativePath]
+	if !ok || entry != newHashCacheEntry(info, entry.Checksum) {
+		return "", false
+	}
+	c.seen[relativePath] = entry
+	return entry.Checksum, true
+}
+
+// store records the checksum of a file. files modified within the racy window are not cached.
+func (c *HashCache) Store(relativePath string, info os.FileInfo, checksum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(info.ModTime()) < racyWindow {
+		return
+	}
+	entry := newHashCacheEntry(info, checksum)
+	if old, ok := c.entries[relativePath]; !ok || old != entry {
+		c.dirty = true
+	}
+	c.seen[relativePath] = entry
+}
+
+// save writes the entries used by the current scan, dropping files that no longer exist.
+// nothing is written when the cache is unchanged.
+func (c *HashCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty && len(c.seen) == len(c.entries) {
+		return nil
+	}
+
+	data, err := json.Marshal(hashCacheFile{Version: hashCacheVersion, Entries: c.seen})
+	if err != nil {
+		return fmt.Errorf("failed to marshal hash cache: %w", err)
+	}
+	if err := CreateDirIfNotExists(filepath.Dir(c.path)); err != nil {
+		return fmt.Errorf("failed to create hash cache directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+
+	c.entries = make(map[string]hashCacheEntry, len(c.seen))
+	for relativePath, entry := range c.seen {
+		c.entries[relativePath] = entry
+	}
+	c.dirty = false
+	return nil
+}
+
+// newhashcacheentry builds the cache key of a file with its checksum
+func newHashCacheEntry(info os.FileInfo, checksum string) hashCacheEntry {
+	return hashCacheEntry{
+		Inode:     FileInode(info),
+		Size:      info.Size(),
+		ModTimeNs: info.ModTime().UnixNano(),
+		Checksum:  checksum,
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileutils
+
+import "os"
+
+// fileinode returns 0 on platforms without inode numbers; size and mtime still key the cache
+func FileInode(info os.FileInfo) uint64 {
+	return 0
+}
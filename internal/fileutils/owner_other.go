@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileutils
+
+import "os"
+
+// fileowner reports no ownership on platforms without posix user and group ids
+func FileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
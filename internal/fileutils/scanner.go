@@ -0,0 +1,367 @@
+package fileutils
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// scanqueuefactor bounds the files walked ahead of the consumer to this many per worker
+const scanQueueFactor = 4
+
+// scandirectorywithoptions walks a directory and returns detailed file information
+func ScanDirectoryWithOptions(rootDir string, opts ScanOptions) ([]FileInfo, error) {
+	var files []FileInfo
+	for file, err := range ScanDirectorySeq(context.Background(), rootDir, opts) {
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// scandirectoryseq streams the files below a directory in lexical path order while a bounded
+// pool of workers hashes them. followed directory links are walked under their own path unless
+// they point to a directory the walk is already inside, which breaks symlink loops. iteration stops at the first error, which is yielded with an empty
+// fileinfo; stopping the loop early cancels the walk.
+func ScanDirectorySeq(ctx context.Context, rootDir string, opts ScanOptions) iter.Seq2[FileInfo, error] {
+	return scan(ctx, rootDir, nil, opts)
+}
+
+// scanpathsseq streams the files at the given paths relative to a directory, descending into
+// the ones that are directories. missing and hidden paths are skipped, so a list of changed
+// paths can be rescanned as is. relative paths and the hash cache stay relative to rootDir.
+func ScanPathsSeq(ctx context.Context, rootDir string, paths []string, opts ScanOptions) iter.Seq2[FileInfo, error] {
+	return scan(ctx, rootDir, paths, opts)
+}
+
+// scan streams the files below rootDir, or only those at paths when paths is not nil
+func scan(ctx context.Context, rootDir string, paths []string, opts ScanOptions) iter.Seq2[FileInfo, error] {
+	return func(yield func(FileInfo, error) bool) {
+		// ensure root directory exists
+		if _, err := os.Stat(rootDir); os.IsNotExist(err) {
+			yield(FileInfo{}, fmt.Errorf("directory does not exist: %s", rootDir))
+			return
+		}
+
+		workers := opts.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		s := &scanner{
+			ctx:     ctx,
+			root:    rootDir,
+			paths:   paths,
+			opts:    opts,
+			ordered: make(chan *scanJob, workers*scanQueueFactor),
+			work:    make(chan *scanJob, workers),
+		}
+
+		for i := 0; i < workers; i++ {
+			go s.hashWorker()
+		}
+		go s.run()
+
+		for job := range s.ordered {
+			<-job.done
+			if job.err != nil {
+				yield(FileInfo{}, fmt.Errorf("error scanning directory: %w", job.err))
+				return
+			}
+			if !yield(job.file, nil) {
+				return
+			}
+		}
+
+		// a cancelled walk ends early without queueing an error
+		if err := ctx.Err(); err != nil {
+			yield(FileInfo{}, err)
+		}
+	}
+}
+
+// scanjob is a walked entry waiting for its checksum; done is closed once file or err is set
+type scanJob struct {
+	path string
+	info os.FileInfo
+	file FileInfo
+	err  error
+	done chan struct{}
+}
+
+// scanner walks a directory tree, queueing entries in walk order and hashing them in parallel
+type scanner struct {
+	ctx     context.Context
+	root    string
+	paths   []string // paths below root to scan instead of the whole tree, if not nil
+	opts    ScanOptions
+	ordered chan *scanJob // every job in walk order, read by the consumer
+	work    chan *scanJob // files still to hash, read by the workers
+
+	// the real directories the walk is inside, one span per followed link: from the directory
+	// a walk started at down to the parent of the link it is following
+	current []dirSpan
+}
+
+// dirspan is a chain of real directories from top down to bottom
+type dirSpan struct {
+	top    string
+	bottom string
+}
+
+// inside reports whether the walk is inside the real directory dir
+func (s *scanner) inside(dir string) bool {
+	for _, span := range s.current {
+		if withinDir(dir, span.top) && withinDir(span.bottom, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// withindir reports whether path is dir or lies below it
+func withinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// run walks the tree and closes both queues when done; a walk error is queued as a failed job
+func (s *scanner) run() {
+	defer close(s.ordered)
+	defer close(s.work)
+
+	walk := s.walk
+	if s.paths != nil {
+		walk = func(string) error { return s.walkPaths() }
+	}
+	if err := walk(s.root); err != nil && s.ctx.Err() == nil {
+		job := &scanJob{err: err, done: make(chan struct{})}
+		close(job.done)
+		s.queue(job, false)
+	}
+}
+
+// hashworker hashes queued files until the walk is finished
+func (s *scanner) hashWorker() {
+	for job := range s.work {
+		if job.err = s.ctx.Err(); job.err == nil {
+			job.file, job.err = s.fileInfo(job.path, job.info)
+		}
+		close(job.done)
+	}
+}
+
+// queue hands a job to the consumer and, if it still needs hashing, to the workers
+func (s *scanner) queue(job *scanJob, hash bool) error {
+	select {
+	case s.ordered <- job:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	if !hash {
+		return nil
+	}
+	select {
+	case s.work <- job:
+		return nil
+	case <-s.ctx.Done():
+		job.err = s.ctx.Err()
+		close(job.done)
+		return s.ctx.Err()
+	}
+}
+
+// walk scans one directory tree in lexical order, descending into followed directory links.
+// walkdir does not descend into a link, so the real directory is walked while the paths handed
+// on stay below dir, which keeps them relative to the root when dir or the root is a link.
+// a directory reached through several links is walked under each of them.
+func (s *scanner) walk(dir string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if s.inside(realDir) {
+		// symlink loop
+		return nil
+	}
+
+	return filepath.WalkDir(realDir, func(realPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if realPath == realDir {
+			return nil
+		}
+
+		// skip hidden files and directories such as .s3sync and .git
+		if d.Name()[0] == '.' {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relPath, err := filepath.Rel(realDir, realPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, relPath)
+
+		switch {
+		case d.IsDir():
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			// walkdir does not follow links, so the directories above the link are real
+			s.current = append(s.current, dirSpan{top: realDir, bottom: filepath.Dir(realPath)})
+			err := s.walkLink(path)
+			s.current = s.current[:len(s.current)-1]
+			return err
+		case !d.Type().IsRegular():
+			// sockets, devices and named pipes are not synced
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return s.queue(&scanJob{path: path, info: info, done: make(chan struct{})}, true)
+	})
+}
+
+// walkpaths scans each of the selected paths as a file, link or directory tree
+func (s *scanner) walkPaths() error {
+	for _, relPath := range s.paths {
+		if hiddenPath(relPath) {
+			continue
+		}
+		path := filepath.Join(s.root, relPath)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			err = s.walk(path)
+		case info.Mode()&os.ModeSymlink != 0:
+			err = s.walkLink(path)
+		case info.Mode().IsRegular():
+			err = s.queue(&scanJob{path: path, info: info, done: make(chan struct{})}, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hiddenpath reports whether a relative path lies in or is a hidden file or directory
+func hiddenPath(relPath string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if name != "" && name != "." && name[0] == '.' {
+			return true
+		}
+	}
+	return false
+}
+
+// walklink handles a symlink according to the symlink policy
+func (s *scanner) walkLink(path string) error {
+	switch s.opts.Symlinks {
+	case SymlinkSkip:
+		return nil
+	case SymlinkPreserve:
+		job := &scanJob{path: path, done: make(chan struct{})}
+		job.file, job.err = s.linkInfo(path)
+		close(job.done)
+		return s.queue(job, false)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		// dangling link, nothing to follow
+		return nil
+	}
+	if info.IsDir() {
+		return s.walk(path)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	return s.queue(&scanJob{path: path, info: info, done: make(chan struct{})}, true)
+}
+
+// fileinfo describes a regular file, taking its checksum from the cache when possible
+func (s *scanner) fileInfo(path string, info os.FileInfo) (FileInfo, error) {
+	relPath, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	checksum, cached := "", false
+	if s.opts.Cache != nil && !s.opts.Checksum {
+		checksum, cached = s.opts.Cache.Lookup(relPath, info)
+	}
+	if !cached {
+		checksum, err = FileChecksum(path)
+		if err != nil {
+			return FileInfo{}, fmt.Errorf("failed to calculate checksum for %s: %w", path, err)
+		}
+		if s.opts.Cache != nil {
+			s.opts.Cache.Store(relPath, info, checksum)
+		}
+	}
+
+	return s.newFileInfo(path, relPath, info, checksum, ""), nil
+}
+
+// linkinfo describes a preserved symlink; its checksum covers the link target
+func (s *scanner) linkInfo(path string) (FileInfo, error) {
+	relPath, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to read symlink %s: %w", path, err)
+	}
+
+	return s.newFileInfo(path, relPath, info, LinkChecksum(target), target), nil
+}
+
+// newfileinfo builds a scanned entry, recording permissions and ownership with preserve
+func (s *scanner) newFileInfo(path, relPath string, info os.FileInfo, checksum, linkTarget string) FileInfo {
+	fileInfo := FileInfo{
+		Path:         path,
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		Checksum:     checksum,
+		LinkTarget:   linkTarget,
+		RelativePath: relPath,
+	}
+	if s.opts.Preserve {
+		fileInfo.Mode = info.Mode().Perm()
+		if uid, gid, ok := FileOwner(info); ok {
+			fileInfo.UID, fileInfo.GID = &uid, &gid
+		}
+	}
+	return fileInfo
+}
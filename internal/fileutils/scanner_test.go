@@ -0,0 +1,106 @@
+package fileutils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writetestfile creates a file and its parent directories below dir
+func writeTestFile(t *testing.T, dir, relPath, content string) {
+	t.Helper()
+	path := filepath.Join(dir, relPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// scannedpaths returns the relative paths of a scan in scan order
+func scannedPaths(t *testing.T, root string, opts ScanOptions) []string {
+	t.Helper()
+	files, err := ScanDirectoryWithOptions(root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, filepath.ToSlash(file.RelativePath))
+	}
+	return paths
+}
+
+func TestScanSymlinkedRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "real/a.txt", "a")
+	writeTestFile(t, dir, "real/sub/b.txt", "b")
+	root := filepath.Join(dir, "root")
+	if err := os.Symlink(filepath.Join(dir, "real"), root); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	files, err := ScanDirectoryWithOptions(root, ScanOptions{Symlinks: SymlinkFollow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, filepath.ToSlash(file.RelativePath))
+		if !strings.HasPrefix(file.Path, root+string(filepath.Separator)) {
+			t.Errorf("path %s is not below the root link %s", file.Path, root)
+		}
+	}
+	if want := []string{"a.txt", "sub/b.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("scanned %v, want %v", paths, want)
+	}
+}
+
+func TestScanFollowsSymlinkedSubdirectory(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTestFile(t, root, "a.txt", "a")
+	writeTestFile(t, dir, "other/b.txt", "b")
+	if err := os.Symlink(filepath.Join(dir, "other"), filepath.Join(root, "linked")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	if paths, want := scannedPaths(t, root, ScanOptions{Symlinks: SymlinkFollow}), []string{"a.txt", "linked/b.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("follow scanned %v, want %v", paths, want)
+	}
+	if paths, want := scannedPaths(t, root, ScanOptions{Symlinks: SymlinkSkip}), []string{"a.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("skip scanned %v, want %v", paths, want)
+	}
+}
+
+func TestScanSymlinkLoop(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "dir/a.txt", "a")
+	if err := os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "dir", "loop")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	if paths, want := scannedPaths(t, root, ScanOptions{Symlinks: SymlinkFollow}), []string{"dir/a.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("scanned %v, want %v", paths, want)
+	}
+}
+
+func TestScanSymlinkToSiblingDirectory(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "b/x.txt", "x")
+	// one link sorts before its target and one after, both are walked under their own path
+	if err := os.Symlink(filepath.Join(root, "b"), filepath.Join(root, "a")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "b"), filepath.Join(root, "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a/x.txt", "b/x.txt", "c/x.txt"}
+	if paths := scannedPaths(t, root, ScanOptions{Symlinks: SymlinkFollow}); !slices.Equal(paths, want) {
+		t.Errorf("scanned %v, want %v", paths, want)
+	}
+}
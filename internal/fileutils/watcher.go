@@ -0,0 +1,202 @@
+package fileutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchmaxdelayfactor bounds how long a steady stream of events delays a batch, in debounce intervals
+const watchMaxDelayFactor = 10
+
+// watchbatch is the set of paths changed during one debounce window
+type WatchBatch struct {
+	Paths  []string // changed paths relative to the root, without paths below another changed path
+	Rescan bool     // events were lost and the whole tree has to be rescanned
+}
+
+// watcher reports changes below a directory tree in debounced batches. directories are watched
+// as they appear; directories reached through symlinks are not watched.
+type Watcher struct {
+	root     string
+	debounce time.Duration
+	watcher  *fsnotify.Watcher
+	dirs     map[string]bool // watched directories
+}
+
+// newwatcher starts watching every non-hidden directory below root
+func NewWatcher(root string, debounce time.Duration) (*Watcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start file watcher: %w", err)
+	}
+
+	w := &Watcher{
+		root:     filepath.Clean(root),
+		debounce: debounce,
+		watcher:  fsWatcher,
+		dirs:     make(map[string]bool),
+	}
+	if err := w.addTree(w.root); err != nil {
+		fsWatcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", root, err)
+	}
+	return w, nil
+}
+
+// close stops watching
+func (w *Watcher) Close() error {
+	return w.watcher.Close()
+}
+
+// run delivers batches of changes to handle until ctx is done or handle fails. a batch is
+// delivered once no event arrived for the debounce interval, and at the latest ten intervals
+// after its first event. when the kernel queue overflows the batch asks for a full rescan.
+func (w *Watcher) Run(ctx context.Context, handle func(WatchBatch) error) error {
+	pending := make(map[string]bool)
+	rescan := false
+	var first time.Time
+
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	schedule := func() {
+		if first.IsZero() {
+			first = time.Now()
+		}
+		delay := w.debounce
+		if remaining := watchMaxDelayFactor*w.debounce - time.Since(first); remaining < delay {
+			delay = max(remaining, 0)
+		}
+		timer.Reset(delay)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return nil
+			}
+			relPath, ok := w.relative(event.Name)
+			if !ok {
+				continue
+			}
+			if err := w.track(event); err != nil {
+				// the new directory cannot be watched; a rescan still picks up its files
+				rescan = true
+			}
+			pending[relPath] = true
+			schedule()
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				return fmt.Errorf("file watcher failed: %w", err)
+			}
+			rescan = true
+			schedule()
+
+		case <-timer.C:
+			batch := WatchBatch{Paths: collapsePaths(pending), Rescan: rescan}
+			pending = make(map[string]bool)
+			rescan = false
+			first = time.Time{}
+			if err := handle(batch); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// track keeps the set of watched directories in line with an event: created or moved-in
+// directories are watched with everything below them, removed or moved-out ones are dropped
+func (w *Watcher) track(event fsnotify.Event) error {
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		prefix := event.Name + string(filepath.Separator)
+		for dir := range w.dirs {
+			if dir == event.Name || strings.HasPrefix(dir, prefix) {
+				w.watcher.Remove(dir)
+				delete(w.dirs, dir)
+			}
+		}
+	}
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+			return w.addTree(event.Name)
+		}
+	}
+	return nil
+}
+
+// addtree watches a directory and every non-hidden directory below it
+func (w *Watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed while walking
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != w.root && d.Name()[0] == '.' {
+			return filepath.SkipDir
+		}
+		if w.dirs[path] {
+			return nil
+		}
+		if err := w.watcher.Add(path); err != nil {
+			return err
+		}
+		w.dirs[path] = true
+		return nil
+	})
+}
+
+// relative returns the path of an event relative to the root, rejecting the root itself and
+// hidden paths such as .s3sync
+func (w *Watcher) relative(path string) (string, bool) {
+	relPath, err := filepath.Rel(w.root, path)
+	if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") || hiddenPath(relPath) {
+		return "", false
+	}
+	return relPath, true
+}
+
+// collapsepaths returns the paths in order, leaving out paths below another path in the set
+func collapsePaths(paths map[string]bool) []string {
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	collapsed := make([]string, 0, len(sorted))
+	for _, path := range sorted {
+		covered := false
+		for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if paths[dir] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			collapsed = append(collapsed, path)
+		}
+	}
+	return collapsed
+}
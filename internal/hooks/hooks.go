@@ -0,0 +1,245 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/config"
+	"github.com/jvkec/aws-s3sync/internal/sync"
+)
+
+// hook events
+const (
+	PreSync  = "pre-sync"  // before a run; a failure aborts the run
+	PostSync = "post-sync" // after a successful run
+	OnError  = "on-error"  // after a failed run
+	PerFile  = "per-file"  // after each executed action
+)
+
+// events lists the hook events in the order they happen
+var events = []string{PreSync, PerFile, PostSync, OnError}
+
+// defaulttimeout limits a hook attempt without a configured timeout
+const defaultTimeout = 30 * time.Second
+
+// signatureheader carries the hmac of a posted event
+const signatureHeader = "X-S3sync-Signature"
+
+// event is the json document a hook receives
+type Event struct {
+	Event     string             `json:"event"`
+	Time      time.Time          `json:"time"`
+	Job       string             `json:"job,omitempty"` // daemon job running the sync
+	Direction sync.Direction     `json:"direction"`
+	Bucket    string             `json:"bucket"`
+	Prefix    string             `json:"prefix,omitempty"`
+	LocalPath string             `json:"local_path"`
+	Summary   *sync.Summary      `json:"summary,omitempty"` // executed actions, for post-sync and on-error
+	Result    *sync.ActionResult `json:"result,omitempty"`  // the executed action, for per-file
+	Error     string             `json:"error,omitempty"`   // why the run failed, for on-error
+}
+
+// hooks runs the configured hooks of each event
+type Hooks struct {
+	hooks []config.HookConfig
+}
+
+// new validates the configured hooks
+func New(configs []config.HookConfig) (*Hooks, error) {
+	for i, hook := range configs {
+		name := hookName(hook)
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		if len(hook.Events) == 0 {
+			return nil, fmt.Errorf("hook %s has no events, use %v", name, events)
+		}
+		for _, event := range hook.Events {
+			if !slices.Contains(events, event) {
+				return nil, fmt.Errorf("hook %s: unknown event %q, use %v", name, event, events)
+			}
+		}
+		switch {
+		case hook.Command != "" && hook.URL != "":
+			return nil, fmt.Errorf("hook %s: set either command or url, not both", name)
+		case hook.Command == "" && hook.URL == "":
+			return nil, fmt.Errorf("hook %s: command or url is required", name)
+		case hook.URL != "":
+			if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("hook %s: invalid url, expected an http or https url", name)
+			}
+		}
+		if hook.Timeout < 0 || hook.Retries < 0 {
+			return nil, fmt.Errorf("hook %s: timeout and retries cannot be negative", name)
+		}
+	}
+	return &Hooks{hooks: configs}, nil
+}
+
+// has reports whether any hook runs on an event
+func (h *Hooks) Has(event string) bool {
+	if h == nil {
+		return false
+	}
+	for _, hook := range h.hooks {
+		if slices.Contains(hook.Events, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// fire runs every hook of the event in configuration order and returns their failures
+func (h *Hooks) Fire(ctx context.Context, event Event) error {
+	if !h.Has(event.Event) {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event.Event, err)
+	}
+
+	var errs []error
+	for _, hook := range h.hooks {
+		if !slices.Contains(hook.Events, event.Event) {
+			continue
+		}
+		if err := run(ctx, hook, event.Event, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s hook %s failed: %w", event.Event, hookName(hook), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run delivers an event to a hook, retrying failed attempts with a doubling delay
+func run(ctx context.Context, hook config.HookConfig, event string, body []byte) error {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	var err error
+	for attempt := 0; attempt <= hook.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(time.Second << min(attempt-1, 5)):
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		if hook.URL != "" {
+			err = post(attemptCtx, hook, event, body)
+		} else {
+			err = command(attemptCtx, hook, event, body)
+		}
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	if hook.Retries > 0 {
+		return fmt.Errorf("%w (after %d attempts)", err, hook.Retries+1)
+	}
+	return err
+}
+
+// command runs a hook command through the shell with the event on stdin. its output goes to
+// stderr so that it does not mix with structured output.
+func command(ctx context.Context, hook config.HookConfig, event string, body []byte) error {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	cmd := exec.CommandContext(ctx, shell, flag, hook.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "S3SYNC_EVENT="+event)
+	if hook.Secret != "" {
+		cmd.Env = append(cmd.Env, "S3SYNC_SIGNATURE="+sign(hook.Secret, body))
+	}
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out: %w", ctx.Err())
+		}
+		return err
+	}
+	return nil
+}
+
+// post sends the event to a hook url; any status other than 2xx is a failure
+func post(ctx context.Context, hook config.HookConfig, event string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "s3sync")
+	request.Header.Set("X-S3sync-Event", event)
+	if hook.Secret != "" {
+		request.Header.Set(signatureHeader, sign(hook.Secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		// the url may carry a token, so only the underlying error is reported
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("responded %s", resp.Status)
+	}
+	return nil
+}
+
+// sign returns the signature of an event body: sha256= followed by the hex hmac-sha256 of the
+// body keyed with the secret
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// hookname names a hook in messages: its name, or else its command or the host of its url,
+// since webhook urls often embed a token
+func hookName(hook config.HookConfig) string {
+	switch {
+	case hook.Name != "":
+		return hook.Name
+	case hook.URL != "":
+		if u, err := url.Parse(hook.URL); err == nil && u.Host != "" {
+			return u.Scheme + "://" + u.Host
+		}
+		return "url"
+	default:
+		return hook.Command
+	}
+}
@@ -0,0 +1,99 @@
+package hooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/config"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		{"", "", "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+		{"key", "The quick brown fox jumps over the lazy dog", "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+	}
+
+	for _, test := range tests {
+		if got := sign(test.secret, []byte(test.body)); got != test.want {
+			t.Errorf("sign(%q, %q) = %s, want %s", test.secret, test.body, got, test.want)
+		}
+	}
+}
+
+func TestWebhookIsSigned(t *testing.T) {
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body, signature = string(data), r.Header.Get(signatureHeader)
+	}))
+	defer server.Close()
+
+	h, err := New([]config.HookConfig{{Events: []string{PostSync}, URL: server.URL, Secret: "shared"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Fire(context.Background(), Event{Event: PostSync, Bucket: "bucket"}); err != nil {
+		t.Fatal(err)
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign("shared", []byte(body)))) {
+		t.Errorf("signature %q does not match body %s", signature, body)
+	}
+}
+
+func TestFirePreSync(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands run through sh")
+	}
+	signed := filepath.Join(t.TempDir(), "signature")
+
+	tests := []struct {
+		name    string
+		hook    config.HookConfig
+		wantErr bool
+	}{
+		{"succeeds", config.HookConfig{Command: "true"}, false},
+		{"fails", config.HookConfig{Name: "check", Command: "exit 3"}, true},
+		{"retried", config.HookConfig{Command: "exit 3", Retries: 1}, true},
+		{"other event", config.HookConfig{Command: "exit 3", Events: []string{PostSync}}, false},
+		{"signed", config.HookConfig{Command: `printf %s "$S3SYNC_SIGNATURE" > ` + signed, Secret: "shared"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.hook.Events == nil {
+				test.hook.Events = []string{PreSync}
+			}
+			h, err := New([]config.HookConfig{test.hook})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = h.Fire(context.Background(), Event{Event: PreSync})
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want error: %v", err, test.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), PreSync+" hook") {
+				t.Errorf("error %q does not name the event", err)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "sha256=") || len(data) != len("sha256=")+64 {
+		t.Errorf("command got signature %q", data)
+	}
+}
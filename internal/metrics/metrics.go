@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// transfer directions
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+)
+
+// registry holds every s3sync metric; metrics are recorded whether or not they are served
+var registry = prometheus.NewRegistry()
+
+var (
+	transferredBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3sync_transferred_bytes_total",
+		Help: "Bytes of file data transferred, by direction.",
+	}, []string{"direction"})
+
+	transferredObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3sync_transferred_objects_total",
+		Help: "Files uploaded, downloaded, moved or copied, by direction.",
+	}, []string{"direction"})
+
+	failedActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3sync_failed_actions_total",
+		Help: "Sync actions that failed, by operation.",
+	}, []string{"operation"})
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3sync_s3_requests_total",
+		Help: "S3 operations, by operation and result (ok or error).",
+	}, []string{"operation", "result"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "s3sync_s3_request_duration_seconds",
+		Help:    "Latency of S3 operations including retries, by operation.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"operation"})
+
+	retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3sync_s3_retries_total",
+		Help: "Retried S3 request attempts, by operation.",
+	}, []string{"operation"})
+
+	throttles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3sync_s3_throttles_total",
+		Help: "S3 request attempts rejected as throttled, by operation.",
+	}, []string{"operation"})
+
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "s3sync_sync_duration_seconds",
+		Help:    "Duration of sync runs, by job and result (ok or error).",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 16),
+	}, []string{"job", "result"})
+
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "s3sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful sync run, by job.",
+	}, []string{"job"})
+
+	pendingActions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "s3sync_pending_actions",
+		Help: "Actions planned by the last sync run and not yet carried out, by job and operation.",
+	}, []string{"job", "operation"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		transferredBytes,
+		transferredObjects,
+		failedActions,
+		requests,
+		requestDuration,
+		retries,
+		throttles,
+		syncDuration,
+		lastSuccess,
+		pendingActions,
+	)
+}
+
+// handler serves the metrics in the prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// observerequest records one s3 operation with the number of retried and throttled attempts
+func ObserveRequest(operation string, duration time.Duration, err error, retried, throttled int) {
+	requests.WithLabelValues(operation, result(err)).Inc()
+	requestDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if retried > 0 {
+		retries.WithLabelValues(operation).Add(float64(retried))
+	}
+	if throttled > 0 {
+		throttles.WithLabelValues(operation).Add(float64(throttled))
+	}
+}
+
+// observetransfer records a file transferred in a direction
+func ObserveTransfer(direction string, bytes int64) {
+	transferredObjects.WithLabelValues(direction).Inc()
+	transferredBytes.WithLabelValues(direction).Add(float64(bytes))
+}
+
+// observefailure records an action that failed
+func ObserveFailure(operation string) {
+	failedActions.WithLabelValues(operation).Inc()
+}
+
+// observesync records a finished sync run of a job
+func ObserveSync(job string, duration time.Duration, err error) {
+	syncDuration.WithLabelValues(job, result(err)).Observe(duration.Seconds())
+	if err == nil {
+		lastSuccess.WithLabelValues(job).Set(float64(time.Now().Unix()))
+	}
+}
+
+// setpending replaces the pending action counts of a job
+func SetPending(job string, counts map[string]int) {
+	pendingActions.DeletePartialMatch(prometheus.Labels{"job": job})
+	for operation, count := range counts {
+		pendingActions.WithLabelValues(job, operation).Set(float64(count))
+	}
+}
+
+// result labels the outcome of an operation
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
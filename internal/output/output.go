@@ -0,0 +1,171 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// format selects how command results are rendered
+type Format string
+
+const (
+	FormatPlain  Format = "plain"
+	FormatTable  Format = "table"
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+)
+
+// parseformat validates an output format name
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case FormatPlain, FormatTable, FormatJSON, FormatNDJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid output format %q (expected json, ndjson, table or plain)", name)
+}
+
+// view describes a command result in every supported format
+type View struct {
+	// data is serialized for json; for ndjson a slice is written one element per line
+	Data interface{}
+	// records, if set, replaces data for ndjson output
+	Records []interface{}
+	// columns and rows are used for table output
+	Columns []string
+	Rows    [][]string
+	// text writes the human-readable form used for plain output
+	Text func(w io.Writer)
+}
+
+// event is a single ndjson record emitted while a command runs
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// renderer writes command results in the selected format
+type Renderer struct {
+	format Format
+	out    io.Writer
+}
+
+// newrenderer creates a renderer writing to out
+func NewRenderer(out io.Writer, format Format) *Renderer {
+	return &Renderer{
+		format: format,
+		out:    out,
+	}
+}
+
+// format returns the selected output format
+func (r *Renderer) Format() Format {
+	return r.format
+}
+
+// structured reports whether output is machine-readable
+func (r *Renderer) Structured() bool {
+	return r.format == FormatJSON || r.format == FormatNDJSON
+}
+
+// printf writes human-readable progress text, suppressed for structured formats
+func (r *Renderer) Printf(format string, args ...interface{}) {
+	if r.Structured() {
+		return
+	}
+	fmt.Fprintf(r.out, format, args...)
+}
+
+// println writes a line of human-readable text, suppressed for structured formats
+func (r *Renderer) Println(args ...interface{}) {
+	if r.Structured() {
+		return
+	}
+	fmt.Fprintln(r.out, args...)
+}
+
+// event writes a typed record immediately in ndjson mode and is a no-op otherwise
+func (r *Renderer) Event(eventType string, data interface{}) error {
+	if r.format != FormatNDJSON {
+		return nil
+	}
+	return r.writeLine(Event{Type: eventType, Data: data})
+}
+
+// render writes the final result of a command
+func (r *Renderer) Render(view View) error {
+	switch r.format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(view.Data, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+		_, err = fmt.Fprintln(r.out, string(data))
+		return err
+
+	case FormatNDJSON:
+		if view.Records != nil {
+			for _, record := range view.Records {
+				if err := r.writeLine(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		value := reflect.ValueOf(view.Data)
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				if err := r.writeLine(value.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return r.writeLine(view.Data)
+
+	case FormatTable:
+		if view.Columns != nil {
+			return r.writeTable(view.Columns, view.Rows)
+		}
+	}
+
+	// plain output, also used for tables without columns
+	if view.Text != nil {
+		view.Text(r.out)
+		return nil
+	}
+	for _, row := range view.Rows {
+		fmt.Fprintln(r.out, strings.Join(row, " "))
+	}
+	return nil
+}
+
+// writeline encodes a value as a single json line
+func (r *Renderer) writeLine(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	_, err = fmt.Fprintln(r.out, string(data))
+	return err
+}
+
+// writetable prints aligned columns with an upper-case header
+func (r *Renderer) writeTable(columns []string, rows [][]string) error {
+	w := tabwriter.NewWriter(r.out, 0, 4, 2, ' ', 0)
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = strings.ToUpper(column)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	return w.Flush()
+}
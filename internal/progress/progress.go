@@ -0,0 +1,212 @@
+package progress
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// tracker follows the bytes and files of a sync run. it is safe for concurrent use.
+type Tracker struct {
+	mu          sync.Mutex
+	started     time.Time
+	files       int
+	filesDone   int
+	bytes       int64 // file data the run is expected to move
+	done        int64 // bytes transferred or skipped, counted towards bytes
+	transferred int64 // bytes actually sent or received, for the throughput
+	active      []*Transfer
+}
+
+// transfer counts the bytes of one file
+type Transfer struct {
+	tracker   *Tracker
+	operation string
+	path      string
+	size      int64
+	done      int64
+	started   time.Time
+}
+
+// snapshot is the progress of a run at one moment
+type Snapshot struct {
+	Files          int                `json:"files"`
+	FilesDone      int                `json:"files_done"`
+	Bytes          int64              `json:"bytes"`
+	BytesDone      int64              `json:"bytes_done"`
+	BytesPerSecond float64            `json:"bytes_per_second"`
+	Elapsed        float64            `json:"elapsed_seconds"`
+	ETA            float64            `json:"eta_seconds,omitempty"` // unknown until data moves
+	Transfers      []TransferSnapshot `json:"transfers,omitempty"`
+}
+
+// transfersnapshot is the progress of one file at one moment
+type TransferSnapshot struct {
+	Operation      string  `json:"operation"`
+	Path           string  `json:"path"`
+	Size           int64   `json:"size"`
+	BytesDone      int64   `json:"bytes_done"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ETA            float64 `json:"eta_seconds,omitempty"`
+}
+
+// newtracker creates a tracker for a run of files moving bytes of file data
+func NewTracker(files int, bytes int64) *Tracker {
+	return &Tracker{started: time.Now(), files: files, bytes: bytes}
+}
+
+// start begins a transfer of size bytes of file data; size is zero for actions moving no data
+func (t *Tracker) Start(operation, path string, size int64) *Transfer {
+	transfer := &Transfer{tracker: t, operation: operation, path: path, size: size, started: time.Now()}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active = append(t.active, transfer)
+	return transfer
+}
+
+// finish ends the transfer. the file counts as done whether or not it succeeded, so that the
+// remaining bytes only cover files still to come.
+func (tr *Transfer) Finish() {
+	t := tr.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active = slices.DeleteFunc(t.active, func(active *Transfer) bool { return active == tr })
+	if tr.done < tr.size {
+		t.done += tr.size - tr.done
+	}
+	t.filesDone++
+}
+
+// add counts bytes sent or received; rewinds of a retried body count negative
+func (tr *Transfer) add(n int64) {
+	t := tr.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr.done += n
+	t.done += n
+	t.transferred += n
+	// deferred copies that turn into uploads move more data than planned
+	t.bytes = max(t.bytes, t.done)
+}
+
+// skip counts bytes that are done without moving, like parts reused by a delta upload
+func (tr *Transfer) skip(n int64) {
+	t := tr.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr.done += n
+	t.done += n
+	t.bytes = max(t.bytes, t.done)
+}
+
+// snapshot returns the current progress. throughput is averaged over the run, or over the
+// transfer for a single file.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(t.started).Seconds()
+	snapshot := Snapshot{
+		Files:     t.files,
+		FilesDone: t.filesDone,
+		Bytes:     t.bytes,
+		BytesDone: t.done,
+		Elapsed:   elapsed,
+	}
+	snapshot.BytesPerSecond, snapshot.ETA = rate(t.transferred, t.bytes-t.done, elapsed)
+
+	for _, tr := range t.active {
+		transfer := TransferSnapshot{Operation: tr.operation, Path: tr.path, Size: tr.size, BytesDone: tr.done}
+		transfer.BytesPerSecond, transfer.ETA = rate(tr.done, tr.size-tr.done, now.Sub(tr.started).Seconds())
+		snapshot.Transfers = append(snapshot.Transfers, transfer)
+	}
+	return snapshot
+}
+
+// rate returns the throughput of moving bytes in elapsed seconds and the seconds left for remaining
+func rate(bytes, remaining int64, elapsed float64) (float64, float64) {
+	if bytes <= 0 || elapsed <= 0 {
+		return 0, 0
+	}
+	perSecond := float64(bytes) / elapsed
+	return perSecond, float64(max(remaining, 0)) / perSecond
+}
+
+// transferkey is the context key of the transfer counting the bytes of a call
+type transferKey struct{}
+
+// withtransfer returns a context whose reads and writes wrapped by newreader and newwriter count
+// towards the transfer
+func WithTransfer(ctx context.Context, transfer *Transfer) context.Context {
+	return context.WithValue(ctx, transferKey{}, transfer)
+}
+
+// fromcontext returns the transfer of a context, or nil
+func fromContext(ctx context.Context) *Transfer {
+	transfer, _ := ctx.Value(transferKey{}).(*Transfer)
+	return transfer
+}
+
+// newreader counts the bytes read from r towards the transfer of ctx, if there is one. the
+// reader stays seekable so the sdk can rewind it for retries, which takes back the bytes counted.
+func NewReader(ctx context.Context, r io.ReadSeeker) io.ReadSeeker {
+	transfer := fromContext(ctx)
+	if transfer == nil {
+		return r
+	}
+	return &reader{r: r, transfer: transfer}
+}
+
+// reader counts the bytes read up to its position
+type reader struct {
+	r        io.ReadSeeker
+	transfer *Transfer
+	pos      int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.pos += int64(n)
+	r.transfer.add(int64(n))
+	return n, err
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.r.Seek(offset, whence)
+	if err == nil {
+		r.transfer.add(pos - r.pos)
+		r.pos = pos
+	}
+	return pos, err
+}
+
+// newwriter counts the bytes written to w towards the transfer of ctx, if there is one
+func NewWriter(ctx context.Context, w io.Writer) io.Writer {
+	transfer := fromContext(ctx)
+	if transfer == nil {
+		return w
+	}
+	return &writer{w: w, transfer: transfer}
+}
+
+// writer counts the bytes written
+type writer struct {
+	w        io.Writer
+	transfer *Transfer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.transfer.add(int64(n))
+	return n, err
+}
+
+// skip counts bytes of the transfer of ctx that are done without moving
+func Skip(ctx context.Context, n int64) {
+	if transfer := fromContext(ctx); transfer != nil {
+		transfer.skip(n)
+	}
+}
@@ -0,0 +1,111 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// conflictpolicy decides how files changed on both sides are resolved
+type ConflictPolicy string
+
+const (
+	ConflictNewer    ConflictPolicy = "newer"     // keep the side with the later modification time
+	ConflictLocal    ConflictPolicy = "local"     // upload the local file over the remote one
+	ConflictRemote   ConflictPolicy = "remote"    // download the remote file over the local one
+	ConflictKeepBoth ConflictPolicy = "keep-both" // rename the local file aside and download the remote one
+	ConflictSkip     ConflictPolicy = "skip"      // leave both sides untouched
+	ConflictPrompt   ConflictPolicy = "prompt"    // ask for each conflict
+)
+
+// parseconflictpolicy validates a conflict policy name
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(name)); policy {
+	case ConflictNewer, ConflictLocal, ConflictRemote, ConflictKeepBoth, ConflictSkip, ConflictPrompt:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q (expected newer, local, remote, keep-both, skip or prompt)", name)
+}
+
+// conflictresolver asks for the resolution of a single conflict
+type ConflictResolver func(action SyncAction) (ConflictPolicy, error)
+
+// resolveconflicts sets the resolution of every conflict action according to the policy.
+// prompt is only called for the prompt policy and may itself return newer. resolutions that
+// would update the side the direction leaves alone are skipped, see directionresolution.
+func ResolveConflicts(actions []SyncAction, direction Direction, policy ConflictPolicy, prompt ConflictResolver) error {
+	for i := range actions {
+		action := &actions[i]
+		if action.Operation != SyncOpConflict {
+			continue
+		}
+
+		resolution := policy
+		if policy == ConflictPrompt {
+			if prompt == nil {
+				return fmt.Errorf("conflict policy prompt requires an interactive terminal")
+			}
+			chosen, err := prompt(*action)
+			if err != nil {
+				return fmt.Errorf("failed to resolve conflict for %s: %w", action.RelativePath, err)
+			}
+			resolution = chosen
+		}
+
+		if resolution == ConflictNewer {
+			resolution = ConflictRemote
+			if action.RemoteFile == nil || action.File.ModTime.After(action.RemoteFile.ModTime) {
+				resolution = ConflictLocal
+			}
+		}
+
+		action.Resolution = DirectionResolution(direction, resolution)
+	}
+
+	return nil
+}
+
+// directionresolution returns the resolution of a conflict as executed in a direction. push only
+// uploads and pull only downloads, so a resolution for the other side becomes skip; keep-both
+// renames local files aside and only runs in the sync direction.
+func DirectionResolution(direction Direction, resolution ConflictPolicy) ConflictPolicy {
+	switch {
+	case direction == DirectionPush && resolution != ConflictLocal,
+		direction == DirectionPull && resolution != ConflictRemote:
+		return ConflictSkip
+	}
+	return resolution
+}
+
+// conflictcopyname returns the path a local file is renamed to when both versions are kept
+func ConflictCopyName(relativePath, host string, now time.Time) string {
+	return fmt.Sprintf("%s.conflict-%s-%s", relativePath, host, now.UTC().Format("20060102T150405Z"))
+}
+
+// keeplocalcopy renames a conflicting local file aside and returns the new relative path
+func keepLocalCopy(localPath, relativePath string) (string, error) {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "local"
+	}
+
+	copyPath := ConflictCopyName(relativePath, host, time.Now())
+	if err := os.Rename(filepath.Join(localPath, relativePath), filepath.Join(localPath, copyPath)); err != nil {
+		return "", fmt.Errorf("failed to keep local copy of %s: %w", relativePath, err)
+	}
+
+	return copyPath, nil
+}
+
+// pendingactions counts the actions with an operation in ops that will transfer data
+func PendingActions(actions []SyncAction, ops []SyncOp) int {
+	count := 0
+	for _, action := range actions {
+		if ContainsOp(ops, action.Operation) && action.EffectiveOp() != SyncOpSkip {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		direction Direction
+		policy    ConflictPolicy
+		localTime time.Time
+		want      ConflictPolicy
+		wantOp    SyncOp
+	}{
+		{"sync newer local", DirectionSync, ConflictNewer, newer, ConflictLocal, SyncOpUpload},
+		{"sync newer remote", DirectionSync, ConflictNewer, older, ConflictRemote, SyncOpDownload},
+		{"sync keep both", DirectionSync, ConflictKeepBoth, newer, ConflictKeepBoth, SyncOpDownload},
+		{"push newer local", DirectionPush, ConflictNewer, newer, ConflictLocal, SyncOpUpload},
+		{"push newer remote", DirectionPush, ConflictNewer, older, ConflictSkip, SyncOpSkip},
+		{"push remote", DirectionPush, ConflictRemote, newer, ConflictSkip, SyncOpSkip},
+		{"push keep both", DirectionPush, ConflictKeepBoth, newer, ConflictSkip, SyncOpSkip},
+		{"pull newer remote", DirectionPull, ConflictNewer, older, ConflictRemote, SyncOpDownload},
+		{"pull newer local", DirectionPull, ConflictNewer, newer, ConflictSkip, SyncOpSkip},
+		{"pull local", DirectionPull, ConflictLocal, older, ConflictSkip, SyncOpSkip},
+		{"pull keep both", DirectionPull, ConflictKeepBoth, older, ConflictSkip, SyncOpSkip},
+		{"skip", DirectionSync, ConflictSkip, newer, ConflictSkip, SyncOpSkip},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := fileutils.FileInfo{RelativePath: "a.txt", ModTime: older.Add(30 * time.Minute)}
+			actions := []SyncAction{
+				{Operation: SyncOpUpload, RelativePath: "b.txt"},
+				{
+					Operation:    SyncOpConflict,
+					RelativePath: "a.txt",
+					File:         fileutils.FileInfo{RelativePath: "a.txt", ModTime: test.localTime},
+					RemoteFile:   &remote,
+				},
+			}
+			if err := ResolveConflicts(actions, test.direction, test.policy, nil); err != nil {
+				t.Fatal(err)
+			}
+			if actions[0].Resolution != "" {
+				t.Errorf("upload got resolution %q", actions[0].Resolution)
+			}
+			if got := actions[1].Resolution; got != test.want {
+				t.Errorf("resolution %q, want %q", got, test.want)
+			}
+			if got := actions[1].EffectiveOp(); got != test.wantOp {
+				t.Errorf("effective op %q, want %q", got, test.wantOp)
+			}
+		})
+	}
+}
+
+func TestResolveConflictsPrompt(t *testing.T) {
+	actions := []SyncAction{{Operation: SyncOpConflict, RelativePath: "a.txt"}}
+	if err := ResolveConflicts(actions, DirectionSync, ConflictPrompt, nil); err == nil {
+		t.Error("prompt without a resolver succeeded")
+	}
+
+	prompt := func(SyncAction) (ConflictPolicy, error) { return ConflictRemote, nil }
+	if err := ResolveConflicts(actions, DirectionPush, ConflictPrompt, prompt); err != nil {
+		t.Fatal(err)
+	}
+	if actions[0].Resolution != ConflictSkip {
+		t.Errorf("push kept prompted resolution %q, want skip", actions[0].Resolution)
+	}
+}
@@ -0,0 +1,340 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/aws"
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+	"github.com/jvkec/aws-s3sync/internal/metrics"
+	"github.com/jvkec/aws-s3sync/internal/progress"
+)
+
+// action result statuses
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// actionresult records the outcome of executing a single sync action
+type ActionResult struct {
+	Operation    SyncOp         `json:"operation"`
+	RelativePath string         `json:"relative_path"`
+	Source       string         `json:"source,omitempty"` // object copied by moves and copies
+	Resolution   ConflictPolicy `json:"resolution,omitempty"`
+	Status       string         `json:"status"`
+	Error        string         `json:"error,omitempty"`
+	ConflictCopy string         `json:"conflict_copy,omitempty"` // local copy kept for keep-both conflicts
+	ETag         string         `json:"etag,omitempty"`
+	Checksum     string         `json:"checksum,omitempty"`
+	VersionID    string         `json:"version_id,omitempty"`
+	Bytes        int64          `json:"bytes"`
+	BytesSaved   int64          `json:"bytes_saved,omitempty"` // data not transferred thanks to a move, copy or delta
+	DurationMs   int64          `json:"duration_ms"`
+	PartSize     int64          `json:"part_size,omitempty"`
+	PartHashes   []string       `json:"-"` // part hashes of a delta upload, recorded in the manifest
+
+	err error
+}
+
+// err returns the underlying error of a failed result
+func (r ActionResult) Err() error {
+	return r.err
+}
+
+// effectiveop returns the transfer that was performed, resolving conflicts to their chosen side
+func (r ActionResult) EffectiveOp() SyncOp {
+	return SyncAction{Operation: r.Operation, Resolution: r.Resolution}.EffectiveOp()
+}
+
+// summary counts the actions of a sync plan and the outcome of executing them
+type Summary struct {
+	Uploads          int   `json:"uploads"`
+	Downloads        int   `json:"downloads"`
+	Moves            int   `json:"moves"`
+	Copies           int   `json:"copies"`
+	Deletes          int   `json:"deletes"`
+	Conflicts        int   `json:"conflicts"`
+	Skipped          int   `json:"skipped"`
+	Succeeded        int   `json:"succeeded"`
+	Failed           int   `json:"failed"`
+	BytesTransferred int64 `json:"bytes_transferred"`
+	BytesSaved       int64 `json:"bytes_saved"`
+	DryRun           bool  `json:"dry_run"`
+}
+
+// summarize counts planned actions by operation
+func Summarize(actions []SyncAction) Summary {
+	var summary Summary
+	for _, action := range actions {
+		switch action.Operation {
+		case SyncOpUpload:
+			summary.Uploads++
+		case SyncOpDownload:
+			summary.Downloads++
+		case SyncOpMove, SyncOpLocalMove:
+			summary.Moves++
+		case SyncOpCopy, SyncOpLocalCopy:
+			summary.Copies++
+		case SyncOpDelete:
+			summary.Deletes++
+		case SyncOpConflict:
+			summary.Conflicts++
+		case SyncOpSkip:
+			summary.Skipped++
+		}
+	}
+	return summary
+}
+
+// addresults folds executed action results into the summary
+func (s *Summary) AddResults(results []ActionResult) {
+	for _, result := range results {
+		if result.Status == StatusOK {
+			s.Succeeded++
+			s.BytesTransferred += result.Bytes
+			s.BytesSaved += result.BytesSaved
+		} else {
+			s.Failed++
+		}
+	}
+}
+
+// executor applies sync actions between a local directory and an s3 bucket
+type Executor struct {
+	Client    *aws.Client
+	Bucket    string
+	Prefix    string // key prefix of the synced objects
+	LocalPath string
+
+	// onstart and onresult are called around each executed action, if set
+	OnStart  func(action SyncAction)
+	OnResult func(result ActionResult)
+
+	// progress counts the bytes of each transfer, if set
+	Progress *progress.Tracker
+}
+
+// execute runs every action whose operation is in ops and returns one result per executed action.
+// conflicts resolved to skip are not executed. copies of files uploaded in the same run go last;
+// when their source was not uploaded they upload their own content instead. individual failures
+// are recorded in the results; only cancellation of ctx stops execution early.
+func (e *Executor) Execute(ctx context.Context, actions []SyncAction, ops ...SyncOp) ([]ActionResult, error) {
+	results := make([]ActionResult, 0)
+	uploaded := make(map[string]bool)
+
+	for _, deferred := range []bool{false, true} {
+		for _, action := range actions {
+			if action.SourcePending != deferred || !ContainsOp(ops, action.Operation) || action.EffectiveOp() == SyncOpSkip {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			if deferred && !uploaded[action.Source] {
+				action.Operation = SyncOpUpload
+				action.Source = ""
+				action.SourcePending = false
+			}
+
+			result, err := e.runAction(ctx, action)
+			if err != nil {
+				return results, err
+			}
+			if result.Status == StatusOK && result.EffectiveOp() == SyncOpUpload {
+				uploaded[result.RelativePath] = true
+			}
+
+			results = append(results, result)
+			if e.OnResult != nil {
+				e.OnResult(result)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// runaction executes a single action and records its result. it only returns an error when
+// ctx was cancelled during the transfer.
+func (e *Executor) runAction(ctx context.Context, action SyncAction) (ActionResult, error) {
+	if e.OnStart != nil {
+		e.OnStart(action)
+	}
+
+	if e.Progress != nil {
+		tracked := e.Progress.Start(string(action.EffectiveOp()), action.RelativePath, TransferSize(action))
+		defer tracked.Finish()
+		ctx = progress.WithTransfer(ctx, tracked)
+	}
+
+	started := time.Now()
+	transfer, conflictCopy, err := e.executeAction(ctx, action)
+	if err != nil && ctx.Err() != nil {
+		return ActionResult{}, ctx.Err()
+	}
+
+	result := ActionResult{
+		Operation:    action.Operation,
+		RelativePath: action.RelativePath,
+		Source:       action.Source,
+		Resolution:   action.Resolution,
+		Status:       StatusOK,
+		ConflictCopy: conflictCopy,
+		Bytes:        action.File.Size,
+		DurationMs:   time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		result.Bytes = 0
+		result.err = err
+	} else if transfer != nil {
+		result.ETag = transfer.ETag
+		result.Checksum = transfer.Checksum
+		result.VersionID = transfer.VersionID
+		result.Bytes = transfer.Size - transfer.Reused
+		result.BytesSaved = transfer.Reused
+		result.PartSize = transfer.PartSize
+		result.PartHashes = transfer.PartHashes
+	}
+	if action.Operation == SyncOpDelete {
+		result.Bytes = 0
+	}
+	if action.Source != "" {
+		// moves and copies transfer no file data
+		result.Bytes = 0
+		if err == nil {
+			result.BytesSaved = action.File.Size
+		}
+	}
+	observeResult(result)
+
+	return result, nil
+}
+
+// transfersize returns the bytes of file data an action sends or receives; moves, copies and
+// deletes send none
+func TransferSize(action SyncAction) int64 {
+	switch action.EffectiveOp() {
+	case SyncOpUpload, SyncOpDownload:
+		return action.File.Size
+	default:
+		return 0
+	}
+}
+
+// observeresult records an executed action in the transfer metrics
+func observeResult(result ActionResult) {
+	op := result.EffectiveOp()
+	if result.Status != StatusOK {
+		metrics.ObserveFailure(string(op))
+		return
+	}
+	switch op {
+	case SyncOpUpload, SyncOpMove, SyncOpCopy:
+		metrics.ObserveTransfer(metrics.DirectionUpload, result.Bytes)
+	case SyncOpDownload, SyncOpLocalMove, SyncOpLocalCopy:
+		metrics.ObserveTransfer(metrics.DirectionDownload, result.Bytes)
+	}
+}
+
+// executeaction performs the transfer for a single action. for keep-both conflicts the local
+// file is renamed aside first and its new relative path is returned.
+func (e *Executor) executeAction(ctx context.Context, action SyncAction) (*aws.TransferResult, string, error) {
+	localFilePath := filepath.Join(e.LocalPath, action.RelativePath)
+
+	conflictCopy := ""
+	if action.Operation == SyncOpConflict && action.Resolution == ConflictKeepBoth {
+		copyPath, err := keepLocalCopy(e.LocalPath, action.RelativePath)
+		if err != nil {
+			return nil, "", err
+		}
+		conflictCopy = copyPath
+	}
+
+	switch action.EffectiveOp() {
+	case SyncOpUpload:
+		var base fileutils.FileInfo
+		if action.Base != nil {
+			base = *action.Base
+		}
+		transfer, err := e.Client.UploadFileDelta(ctx, localFilePath, e.Bucket, e.key(action.RelativePath), base)
+		return transfer, "", err
+	case SyncOpDownload:
+		transfer, err := e.Client.DownloadFile(ctx, e.Bucket, e.key(action.RelativePath), localFilePath)
+		if err != nil && conflictCopy != "" {
+			// put the local version back so nothing is lost
+			os.Rename(filepath.Join(e.LocalPath, conflictCopy), localFilePath)
+			conflictCopy = ""
+		}
+		return transfer, conflictCopy, err
+	case SyncOpCopy:
+		transfer, err := e.Client.CopyFile(ctx, localFilePath, e.Bucket, e.key(action.Source), e.key(action.RelativePath))
+		return transfer, "", err
+	case SyncOpMove:
+		transfer, err := e.Client.CopyFile(ctx, localFilePath, e.Bucket, e.key(action.Source), e.key(action.RelativePath))
+		if err != nil {
+			return nil, "", err
+		}
+		if err := e.Client.DeleteObject(ctx, e.Bucket, e.key(action.Source)); err != nil {
+			return nil, "", fmt.Errorf("copied from %s but could not remove it: %w", action.Source, err)
+		}
+		return transfer, "", nil
+	case SyncOpDelete:
+		return nil, "", e.Client.DeleteObject(ctx, e.Bucket, e.key(action.RelativePath))
+	case SyncOpLocalMove:
+		if err := fileutils.CreateDirIfNotExists(filepath.Dir(localFilePath)); err != nil {
+			return nil, "", fmt.Errorf("failed to create directory for %s: %w", action.RelativePath, err)
+		}
+		if err := os.Rename(filepath.Join(e.LocalPath, action.Source), localFilePath); err != nil {
+			return nil, "", fmt.Errorf("failed to move %s: %w", action.Source, err)
+		}
+		return localTransfer(action), "", nil
+	case SyncOpLocalCopy:
+		if err := fileutils.CopyFile(filepath.Join(e.LocalPath, action.Source), localFilePath); err != nil {
+			return nil, "", err
+		}
+		return localTransfer(action), "", nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported operation %s for %s", action.Operation, action.RelativePath)
+}
+
+// objectkey returns the key of the object for a relative path below prefix
+func ObjectKey(prefix, relativePath string) string {
+	key := filepath.ToSlash(relativePath)
+	if prefix == "" {
+		return key
+	}
+	return path.Join(prefix, key)
+}
+
+// key returns the object key of a relative path
+func (e *Executor) key(relativePath string) string {
+	return ObjectKey(e.Prefix, relativePath)
+}
+
+// localtransfer describes a file moved or copied locally in place of a download
+func localTransfer(action SyncAction) *aws.TransferResult {
+	return &aws.TransferResult{
+		ETag:     action.File.ETag,
+		Checksum: action.File.Checksum,
+		ModTime:  action.File.ModTime,
+		Size:     action.File.Size,
+	}
+}
+
+// containsop reports whether op is one of ops
+func ContainsOp(ops []SyncOp, op SyncOp) bool {
+	for _, candidate := range ops {
+		if candidate == op {
+			return true
+		}
+	}
+	return false
+}
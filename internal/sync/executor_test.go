@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/aws/awstest"
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+func TestExecuteDuplicateUploads(t *testing.T) {
+	tests := []struct {
+		name         string
+		removeSource bool // the upload of the shared content fails
+		wantOp       SyncOp
+		wantPuts     int
+		wantCopies   int
+	}{
+		{"copied from source", false, SyncOpCopy, 1, 1},
+		{"source failed", true, SyncOpUpload, 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, name := range []string{"a.txt", "b.txt"} {
+				if err := os.WriteFile(filepath.Join(root, name), []byte("shared"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			files, err := fileutils.ScanDirectoryWithOptions(root, fileutils.ScanOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			local := manifestOf(files...)
+			actions := ComputeSyncActions(local, manifestOf(), manifestOf())
+			if got := plannedMoves(actions)["b.txt"]; got != (plannedMove{SyncOpCopy, "a.txt"}) {
+				t.Fatalf("b.txt planned as %+v, want a copy of a.txt", got)
+			}
+
+			if test.removeSource {
+				if err := os.Remove(filepath.Join(root, "a.txt")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			server := awstest.NewServer(t)
+			executor := &Executor{Client: server.Client(nil), Bucket: "bucket", LocalPath: root}
+			results, err := executor.Execute(context.Background(), actions, SyncOpUpload, SyncOpCopy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(results) != 2 || results[1].RelativePath != "b.txt" {
+				t.Fatalf("results %+v, want a.txt then b.txt", results)
+			}
+			if result := results[1]; result.Operation != test.wantOp || result.Status != StatusOK {
+				t.Errorf("b.txt ran as %s with status %s (%s), want %s", result.Operation, result.Status, result.Error, test.wantOp)
+			}
+			if object := server.Get("bucket", "b.txt"); object == nil || string(object.Data) != "shared" {
+				t.Error("b.txt was not stored")
+			}
+			if puts := server.Requests("PutObject"); puts != test.wantPuts {
+				t.Errorf("%d uploads, want %d", puts, test.wantPuts)
+			}
+			if copies := server.Requests("CopyObject"); copies != test.wantCopies {
+				t.Errorf("%d copies, want %d", copies, test.wantCopies)
+			}
+		})
+	}
+}
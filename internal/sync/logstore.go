@@ -0,0 +1,422 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// log record operations
+const (
+	logOpMeta   = "meta"   // bucket, prefix and last sync time
+	logOpPut    = "put"    // a file entry, replacing any earlier entry for its path
+	logOpDelete = "delete" // removal of a file entry
+)
+
+// compactminrecords is the number of superseded records tolerated before the log is rewritten
+const compactMinRecords = 1000
+
+// logrecord is one line of the manifest log
+type logRecord struct {
+	Op       string              `json:"op"`
+	File     *fileutils.FileInfo `json:"file,omitempty"`
+	Path     string              `json:"path,omitempty"`
+	Version  int                 `json:"version,omitempty"`
+	Bucket   string              `json:"bucket,omitempty"`
+	Prefix   string              `json:"prefix,omitempty"`
+	LastSync *time.Time          `json:"last_sync,omitempty"`
+}
+
+// logstore keeps the manifest in an append-only log of json lines. changes are appended, so a
+// sync that touches a few files writes a few lines; the log is compacted once superseded
+// records outnumber the live ones. a torn final line left by a crash is ignored.
+//
+// the store itself holds no file entries in memory, only where the latest record of each path
+// lies in the log and a digest of it: entries streams records back from disk and put and save
+// append only the entries whose encoding changed. load still builds the full manifest, so a run
+// needs memory for every entry; what the log saves is rewriting the whole manifest on every run.
+type logStore struct {
+	path    string
+	loaded  bool
+	meta    logRecord           // latest meta record
+	index   map[string]logEntry // latest put record of each live path
+	size    int64               // bytes of complete records in the log
+	records int                 // lines in the log, live or superseded
+}
+
+// logentry locates the latest put record of a path in the log
+type logEntry struct {
+	offset int64
+	length int64  // bytes of the record, newline included
+	digest uint64 // fnv-1a hash of the record, to skip writing unchanged entries
+}
+
+// newlogstore creates a store backed by an append-only log
+func NewLogStore(path string) ManifestStore {
+	return &logStore{path: path}
+}
+
+func (s *logStore) Exists() bool {
+	return fileutils.FileExists(s.path)
+}
+
+func (s *logStore) Load() (*Manifest, error) {
+	manifest := emptyManifest()
+	err := s.replay(func(record logRecord) {
+		if record.Op == logOpPut && record.File != nil {
+			manifest.Files[record.File.RelativePath] = *record.File
+		} else if record.Op == logOpDelete {
+			delete(manifest.Files, record.Path)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Version = s.meta.Version
+	manifest.Bucket = s.meta.Bucket
+	manifest.Prefix = s.meta.Prefix
+	if s.meta.LastSync != nil {
+		manifest.LastSync = *s.meta.LastSync
+	}
+	return manifest, nil
+}
+
+func (s *logStore) Entries() iter.Seq2[fileutils.FileInfo, error] {
+	return func(yield func(fileutils.FileInfo, error) bool) {
+		if err := s.replay(nil); err != nil {
+			yield(fileutils.FileInfo{}, err)
+			return
+		}
+		if len(s.index) == 0 {
+			return
+		}
+
+		file, err := os.Open(s.path)
+		if err != nil {
+			yield(fileutils.FileInfo{}, fmt.Errorf("failed to read manifest log: %w", err))
+			return
+		}
+		defer file.Close()
+
+		for _, relativePath := range slices.Sorted(maps.Keys(s.index)) {
+			entry := s.index[relativePath]
+			line := make([]byte, entry.length)
+			if _, err := file.ReadAt(line, entry.offset); err != nil {
+				yield(fileutils.FileInfo{}, fmt.Errorf("failed to read manifest log: %w", err))
+				return
+			}
+			var record logRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				yield(fileutils.FileInfo{}, fmt.Errorf("failed to parse manifest log entry %s: %w", relativePath, err))
+				return
+			}
+			if record.File == nil {
+				yield(fileutils.FileInfo{}, fmt.Errorf("manifest log entry %s has no file", relativePath))
+				return
+			}
+			if !yield(*record.File, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (s *logStore) Put(files ...fileutils.FileInfo) error {
+	if err := s.replay(nil); err != nil {
+		return err
+	}
+
+	lines := make([][]byte, 0, len(files))
+	for i := range files {
+		line, changed, err := s.putLine(files[i])
+		if err != nil {
+			return err
+		}
+		if changed {
+			lines = append(lines, line)
+		}
+	}
+	return s.append(lines)
+}
+
+func (s *logStore) Delete(relativePaths ...string) error {
+	if err := s.replay(nil); err != nil {
+		return err
+	}
+
+	lines := make([][]byte, 0, len(relativePaths))
+	for _, relativePath := range relativePaths {
+		if _, ok := s.index[relativePath]; !ok {
+			continue
+		}
+		line, err := encodeRecord(logRecord{Op: logOpDelete, Path: relativePath})
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	return s.append(lines)
+}
+
+func (s *logStore) Save(manifest *Manifest) error {
+	if err := s.replay(nil); err != nil {
+		return err
+	}
+
+	// append only the entries that differ from the stored state
+	var lines [][]byte
+	for relativePath := range s.index {
+		if _, ok := manifest.Files[relativePath]; !ok {
+			line, err := encodeRecord(logRecord{Op: logOpDelete, Path: relativePath})
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line)
+		}
+	}
+	for _, file := range manifest.Files {
+		line, changed, err := s.putLine(file)
+		if err != nil {
+			return err
+		}
+		if changed {
+			lines = append(lines, line)
+		}
+	}
+	lastSync := manifest.LastSync
+	line, err := encodeRecord(logRecord{Op: logOpMeta, Version: manifest.Version, Bucket: manifest.Bucket, Prefix: manifest.Prefix, LastSync: &lastSync})
+	if err != nil {
+		return err
+	}
+	lines = append(lines, line)
+
+	if err := s.append(lines); err != nil {
+		return err
+	}
+	if s.records > 2*len(s.index)+compactMinRecords {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *logStore) Reset() error {
+	if err := backupFile(s.path); err != nil {
+		return err
+	}
+	s.loaded = false
+	return nil
+}
+
+// putline encodes the put record of a file and reports whether it differs from the stored one
+func (s *logStore) putLine(file fileutils.FileInfo) ([]byte, bool, error) {
+	line, err := encodeRecord(logRecord{Op: logOpPut, File: &file})
+	if err != nil {
+		return nil, false, err
+	}
+	entry, ok := s.index[file.RelativePath]
+	return line, !ok || entry.digest != digest(line), nil
+}
+
+// replay reads the log once to index it, handing each record to visit if set. a loaded store
+// is not read again unless visit is set.
+func (s *logStore) replay(visit func(record logRecord)) error {
+	if s.loaded && visit == nil {
+		return nil
+	}
+	s.index = make(map[string]logEntry)
+	s.meta = logRecord{Op: logOpMeta, Version: ManifestVersion}
+	s.size = 0
+	s.records = 0
+
+	// logs written before schema versioning carry no version in their meta records, which
+	// replace this one
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		s.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read manifest log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// a final line without newline is a torn write from an interrupted run; cut it
+				// off so the next append starts on a fresh line
+				if err := os.Truncate(s.path, s.size); err != nil {
+					return fmt.Errorf("failed to repair manifest log: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read manifest log: %w", err)
+		}
+		offset := s.size
+		s.size += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record logRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("failed to parse manifest log line %d: %w", lineNumber, err)
+		}
+		s.apply(record, line, offset)
+		if visit != nil {
+			visit(record)
+		}
+		s.records++
+	}
+
+	s.loaded = true
+	return nil
+}
+
+// apply updates the index with one record found at offset
+func (s *logStore) apply(record logRecord, line []byte, offset int64) {
+	switch record.Op {
+	case logOpPut:
+		if record.File != nil {
+			s.index[record.File.RelativePath] = logEntry{offset: offset, length: int64(len(line)), digest: digest(line)}
+		}
+	case logOpDelete:
+		delete(s.index, record.Path)
+	case logOpMeta:
+		s.meta = record
+	}
+}
+
+// append writes encoded records to the end of the log and syncs it to disk
+func (s *logStore) append(lines [][]byte) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	if err := fileutils.CreateDirIfNotExists(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest log: %w", err)
+	}
+	defer file.Close()
+
+	if err := writeLines(file, lines); err != nil {
+		return fmt.Errorf("failed to write manifest log: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest log: %w", err)
+	}
+
+	for _, line := range lines {
+		var record logRecord
+		// lines are encoded by this store, so they always parse
+		json.Unmarshal(line, &record)
+		s.apply(record, line, s.size)
+		s.size += int64(len(line))
+		s.records++
+	}
+	return nil
+}
+
+// compact rewrites the log with one record per live entry, replacing it atomically. entries
+// are streamed from the old log into the new one.
+func (s *logStore) compact() error {
+	tempFile, err := os.CreateTemp(filepath.Dir(s.path), "manifest-*.log")
+	if err != nil {
+		return fmt.Errorf("failed to compact manifest log: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+	defer tempFile.Close()
+
+	index := make(map[string]logEntry, len(s.index))
+	writer := bufio.NewWriter(tempFile)
+	var size int64
+	write := func(record logRecord) error {
+		line, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		if record.File != nil {
+			index[record.File.RelativePath] = logEntry{offset: size, length: int64(len(line)), digest: digest(line)}
+		}
+		size += int64(len(line))
+		_, err = writer.Write(line)
+		return err
+	}
+
+	if err := write(s.meta); err != nil {
+		return fmt.Errorf("failed to compact manifest log: %w", err)
+	}
+	for file, err := range s.Entries() {
+		if err == nil {
+			err = write(logRecord{Op: logOpPut, File: &file})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to compact manifest log: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to compact manifest log: %w", err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		return fmt.Errorf("failed to compact manifest log: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to compact manifest log: %w", err)
+	}
+	if err := os.Rename(tempPath, s.path); err != nil {
+		return fmt.Errorf("failed to compact manifest log: %w", err)
+	}
+
+	s.index, s.size, s.records = index, size, len(index)+1
+	return nil
+}
+
+// encoderecord encodes a record as a json line
+func encodeRecord(record logRecord) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest log record: %w", err)
+	}
+	return append(line, '\n'), nil
+}
+
+// writelines writes encoded records and flushes them to stable storage
+func writeLines(file *os.File, lines [][]byte) error {
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// digest hashes an encoded record; equal entries encode to equal lines
+func digest(line []byte) uint64 {
+	hash := fnv.New64a()
+	hash.Write(bytes.TrimSpace(line))
+	return hash.Sum64()
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// storedpaths returns the paths streamed by a store's entries
+func storedPaths(t *testing.T, store ManifestStore) []string {
+	t.Helper()
+	var paths []string
+	for file, err := range store.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, file.RelativePath)
+	}
+	return paths
+}
+
+// loglines counts the records of a manifest log
+func logLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lines++
+	}
+	return lines
+}
+
+func TestLogStoreIncrementalUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.log")
+	store := NewLogStore(path)
+
+	manifest := emptyManifest()
+	manifest.Bucket = "bucket"
+	for _, relativePath := range []string{"b.txt", "a.txt", "c.txt"} {
+		manifest.Files[relativePath] = fileutils.FileInfo{RelativePath: relativePath, Size: 1, Checksum: "x"}
+	}
+	if err := store.Save(manifest); err != nil {
+		t.Fatal(err)
+	}
+	written := logLines(t, path)
+
+	// saving the same manifest again only appends the meta record
+	if err := store.Save(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if lines := logLines(t, path); lines != written+1 {
+		t.Errorf("saving an unchanged manifest wrote %d records, want 1", lines-written)
+	}
+
+	if err := store.Put(fileutils.FileInfo{RelativePath: "d.txt", Size: 2, Checksum: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("b.txt", "missing.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if paths, want := storedPaths(t, store), []string{"a.txt", "c.txt", "d.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("entries %v, want %v", paths, want)
+	}
+
+	// a fresh store replays the log to the same state
+	reopened := NewLogStore(path)
+	loaded, err := reopened.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Bucket != "bucket" || len(loaded.Files) != 3 || loaded.Files["d.txt"].Size != 2 {
+		t.Errorf("reloaded %+v", loaded)
+	}
+	if paths, want := storedPaths(t, reopened), []string{"a.txt", "c.txt", "d.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("reopened entries %v, want %v", paths, want)
+	}
+}
+
+func TestLogStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.log")
+	store := NewLogStore(path)
+
+	for i := 0; i < compactMinRecords+10; i++ {
+		if err := store.Put(fileutils.FileInfo{RelativePath: "a.txt", Size: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Put(fileutils.FileInfo{RelativePath: "b.txt", Size: 7}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := logLines(t, path); lines != 3 {
+		t.Errorf("compacted log has %d records, want 3", lines)
+	}
+	loaded, err := NewLogStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Files["a.txt"].Size != compactMinRecords+9 || loaded.Files["b.txt"].Size != 7 {
+		t.Errorf("compacted log lost entries: %+v", loaded.Files)
+	}
+	if paths, want := storedPaths(t, store), []string{"a.txt", "b.txt"}; !slices.Equal(paths, want) {
+		t.Errorf("entries after compaction %v, want %v", paths, want)
+	}
+}
@@ -1,7 +1,7 @@
 package sync
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,6 +12,7 @@ import (
 
 // manifest represents the sync state of files
 type Manifest struct {
+	Version  int                           `json:"version"` // schema version, see manifestversion
 	LastSync time.Time                     `json:"last_sync"`
 	Files    map[string]fileutils.FileInfo `json:"files"`
 	Bucket   string                        `json:"bucket"`
@@ -20,84 +21,316 @@ type Manifest struct {
 
 // manifestmanager handles manifest operations
 type ManifestManager struct {
-	manifestPath string
+	stateDir  string               // the .s3sync directory of the synced tree
+	store     ManifestStore        // current manifest backend
+	hashCache *fileutils.HashCache // loaded by scanlocal, saved again with the manifest
 }
 
-// newmanifestmanager creates a new manifest manager
+// newmanifestmanager creates a manifest manager using the append-only log store
 func NewManifestManager(localPath string) *ManifestManager {
-	manifestPath := filepath.Join(localPath, ".s3sync", "manifest.json")
+	return NewManifestManagerWithStore(localPath, NewLogStore(filepath.Join(localPath, ".s3sync", "manifest.log")))
+}
+
+// newmanifestmanagerwithstore creates a manifest manager using the given store
+func NewManifestManagerWithStore(localPath string, store ManifestStore) *ManifestManager {
 	return &ManifestManager{
-		manifestPath: manifestPath,
+		stateDir: filepath.Join(localPath, ".s3sync"),
+		store:    store,
 	}
 }
 
-// loadmanifest loads an existing manifest from disk
+// store returns the manifest backend
+func (m *ManifestManager) Store() ManifestStore {
+	return m.store
+}
+
+// loadmanifest loads an existing manifest from disk with all of its entries, moving a
+// manifest.json written by older versions into the current store first. older schema versions
+// are migrated in memory and written back by the next save.
 func (m *ManifestManager) LoadManifest() (*Manifest, error) {
-	if !fileutils.FileExists(m.manifestPath) {
-		// return empty manifest if none exists
-		return &Manifest{
-			Files: make(map[string]fileutils.FileInfo),
-		}, nil
+	if err := m.migrateLegacyManifest(); err != nil {
+		return nil, err
 	}
 
-	data, err := os.ReadFile(m.manifestPath)
+	manifest, err := m.store.Load()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read manifest file: %w", err)
+		return nil, err
+	}
+	if _, err := MigrateManifest(manifest, filepath.Dir(m.stateDir)); err != nil {
+		return nil, err
 	}
 
-	var manifest Manifest
-	if err := json.Unmarshal(data, &manifest); err != nil {
-		return nil, fmt.Errorf("failed to parse manifest file: %w", err)
+	return manifest, nil
+}
+
+// upgrademanifest migrates the stored manifest to the current schema version and writes it
+// back. it returns the version found and the migrations applied.
+func (m *ManifestManager) UpgradeManifest() (int, []string, error) {
+	if err := m.migrateLegacyManifest(); err != nil {
+		return 0, nil, err
 	}
 
-	if manifest.Files == nil {
-		manifest.Files = make(map[string]fileutils.FileInfo)
+	manifest, err := m.store.Load()
+	if err != nil {
+		return 0, nil, err
 	}
+	from := manifestSchemaVersion(manifest)
+	applied, err := MigrateManifest(manifest, filepath.Dir(m.stateDir))
+	if err != nil {
+		return from, nil, err
+	}
+	if len(applied) == 0 {
+		return from, nil, nil
+	}
+
+	if err := m.store.Save(manifest); err != nil {
+		return from, nil, err
+	}
+	return from, applied, nil
+}
 
-	return &manifest, nil
+// resetmanifest moves the stored manifest aside so it can be rebuilt
+func (m *ManifestManager) ResetManifest() error {
+	return m.store.Reset()
 }
 
 // savemanifest saves the manifest to disk
 func (m *ManifestManager) SaveManifest(manifest *Manifest) error {
-	// ensure manifest directory exists
-	manifestDir := filepath.Dir(m.manifestPath)
-	if err := fileutils.CreateDirIfNotExists(manifestDir); err != nil {
-		return fmt.Errorf("failed to create manifest directory: %w", err)
+	manifest.Version = ManifestVersion
+	manifest.LastSync = time.Now()
+
+	if err := m.store.Save(manifest); err != nil {
+		return err
 	}
 
-	manifest.LastSync = time.Now()
+	if m.hashCache != nil {
+		if err := m.hashCache.Save(); err != nil {
+			return err
+		}
+	}
 
-	data, err := json.MarshalIndent(manifest, "", "  ")
+	return nil
+}
+
+// recordresult writes the entry of a successful action to the store as soon as it completes, so
+// that an interrupted run keeps what it already synced. the manifest saved at the end of the run
+// then only appends the entries that changed otherwise.
+func (m *ManifestManager) RecordResult(result ActionResult, localManifest, remoteManifest *Manifest) error {
+	if result.Status != StatusOK {
+		return nil
+	}
+	file, removed := syncedEntry(result, localManifest, remoteManifest)
+	if file != nil {
+		if err := m.store.Put(*file); err != nil {
+			return err
+		}
+	}
+	if removed != "" {
+		return m.store.Delete(removed)
+	}
+	return nil
+}
+
+// migratelegacymanifest copies manifest.json into the store once and keeps the original as
+// manifest.json.bak
+func (m *ManifestManager) migrateLegacyManifest() error {
+	legacyPath := filepath.Join(m.stateDir, "manifest.json")
+	legacy := NewJSONStore(legacyPath)
+	if !legacy.Exists() || m.store.Exists() {
+		return nil
+	}
+	if _, isJSON := m.store.(*jsonStore); isJSON {
+		return nil
+	}
+
+	manifest, err := legacy.Load()
 	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %w", err)
+		return fmt.Errorf("failed to migrate manifest: %w", err)
+	}
+	if err := m.store.Save(manifest); err != nil {
+		return fmt.Errorf("failed to migrate manifest: %w", err)
+	}
+	if err := os.Rename(legacyPath, legacyPath+".bak"); err != nil {
+		return fmt.Errorf("failed to migrate manifest: %w", err)
 	}
 
-	if err := os.WriteFile(m.manifestPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write manifest file: %w", err)
+	return nil
+}
+
+// scanlocal streams the files of a directory to visit in path order, reusing checksums from the
+// hash cache kept next to the manifest. the cache is only written for directories that already
+// have a .s3sync directory; the first sync writes it together with the manifest.
+func (m *ManifestManager) ScanLocal(ctx context.Context, localPath string, opts fileutils.ScanOptions, visit func(fileutils.FileInfo)) error {
+	if opts.Cache == nil {
+		m.hashCache = fileutils.LoadHashCache(filepath.Join(m.stateDir, "hashcache.json"))
+		opts.Cache = m.hashCache
+	}
+
+	for file, err := range fileutils.ScanDirectorySeq(ctx, localPath, opts) {
+		if err != nil {
+			return err
+		}
+		visit(file)
+	}
+
+	if m.hashCache != nil && fileutils.FileExists(m.stateDir) {
+		if err := m.hashCache.Save(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
 // buildlocalmanifest creates a manifest from current local directory state
-func (m *ManifestManager) BuildLocalManifest(localPath string) (*Manifest, error) {
-	files, err := fileutils.ScanDirectoryWithInfo(localPath)
+func (m *ManifestManager) BuildLocalManifest(ctx context.Context, localPath string, opts fileutils.ScanOptions) (*Manifest, error) {
+	manifest := &Manifest{
+		Files:    make(map[string]fileutils.FileInfo),
+		LastSync: time.Now(),
+	}
+
+	err := m.ScanLocal(ctx, localPath, opts, func(file fileutils.FileInfo) {
+		manifest.Files[file.RelativePath] = file
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan directory: %w", err)
 	}
 
+	return manifest, nil
+}
+
+// localchanged reports whether a scanned local file differs from its last synced entry.
+// permission changes count when both sides recorded a mode, i.e. with --preserve.
+func LocalChanged(localFile, lastKnownFile fileutils.FileInfo) bool {
+	if localFile.Mode != 0 && lastKnownFile.Mode != 0 && localFile.Mode != lastKnownFile.Mode {
+		return true
+	}
+	return localFile.Checksum != lastKnownFile.Checksum
+}
+
+// remotechanged reports whether a listed s3 object differs from its last synced entry.
+// entries saved before etags were recorded fall back to comparing checksums.
+func RemoteChanged(remoteFile, lastKnownFile fileutils.FileInfo) bool {
+	if lastKnownFile.ETag != "" {
+		return remoteFile.ETag != lastKnownFile.ETag
+	}
+	return remoteFile.Checksum != lastKnownFile.Checksum
+}
+
+// syncedentry returns the entry a successful result records, if any, and the path whose entry
+// it removes, if any
+func syncedEntry(result ActionResult, localManifest, remoteManifest *Manifest) (*fileutils.FileInfo, string) {
+	switch result.EffectiveOp() {
+	case SyncOpUpload, SyncOpCopy:
+		file := localManifest.Files[result.RelativePath]
+		file.ETag = result.ETag
+		file.PartSize = result.PartSize
+		file.PartHashes = result.PartHashes
+		return &file, ""
+	case SyncOpMove:
+		file := localManifest.Files[result.RelativePath]
+		file.ETag = result.ETag
+		return &file, result.Source
+	case SyncOpDownload, SyncOpLocalCopy:
+		file := remoteManifest.Files[result.RelativePath]
+		file.Checksum = result.Checksum
+		file.ETag = result.ETag
+		return &file, ""
+	case SyncOpDelete:
+		return nil, result.RelativePath
+	case SyncOpLocalMove:
+		file := remoteManifest.Files[result.RelativePath]
+		file.Checksum = result.Checksum
+		file.ETag = result.ETag
+		return &file, result.Source
+	}
+	return nil, ""
+}
+
+// syncedmanifest builds the manifest to save after a sync run. files that are in sync on both
+// sides record their local checksum and remote etag; everything else keeps its last known entry
+// so that pending changes are still detected on the next run. files never synced are in sync
+// when matches finds the local content in the object, as when the actions were computed.
+func SyncedManifest(localManifest, remoteManifest, lastKnownManifest *Manifest, results []ActionResult, matches ETagMatcher) (*Manifest, error) {
 	manifest := &Manifest{
-		Files:    make(map[string]fileutils.FileInfo),
-		LastSync: time.Now(),
+		Files:  make(map[string]fileutils.FileInfo),
+		Bucket: remoteManifest.Bucket,
+		Prefix: remoteManifest.Prefix,
 	}
 
-	for _, file := range files {
-		manifest.Files[file.RelativePath] = file
+	for relativePath, lastKnownFile := range lastKnownManifest.Files {
+		manifest.Files[relativePath] = lastKnownFile
+	}
+
+	// files unchanged on both sides since the last sync
+	for relativePath, localFile := range localManifest.Files {
+		remoteFile, remoteExists := remoteManifest.Files[relativePath]
+		if !remoteExists {
+			continue
+		}
+		lastKnownFile, wasKnown := lastKnownManifest.Files[relativePath]
+		var inSync bool
+		if wasKnown {
+			inSync = !LocalChanged(localFile, lastKnownFile) && !RemoteChanged(remoteFile, lastKnownFile)
+		} else {
+			var err error
+			if inSync, err = sameContent(localFile, remoteFile, matches); err != nil {
+				return nil, err
+			}
+		}
+		if inSync {
+			localFile.ETag = remoteFile.ETag
+			if wasKnown && localFile.Checksum == lastKnownFile.Checksum {
+				localFile.PartSize = lastKnownFile.PartSize
+				localFile.PartHashes = lastKnownFile.PartHashes
+			}
+			manifest.Files[relativePath] = localFile
+		}
+	}
+
+	// files transferred during this run
+	for _, result := range results {
+		if result.Status != StatusOK {
+			continue
+		}
+		file, removed := syncedEntry(result, localManifest, remoteManifest)
+		if file != nil {
+			manifest.Files[result.RelativePath] = *file
+		}
+		if removed != "" {
+			delete(manifest.Files, removed)
+		}
+	}
+
+	// forget files that are gone from both sides
+	for relativePath := range manifest.Files {
+		_, localExists := localManifest.Files[relativePath]
+		_, remoteExists := remoteManifest.Files[relativePath]
+		if !localExists && !remoteExists {
+			delete(manifest.Files, relativePath)
+		}
 	}
 
 	return manifest, nil
 }
 
+// samecontent reports whether a file that was never synced has the same content locally and
+// remotely. a listing only carries etags, so without a matcher only entries recorded with the
+// same checksum compare equal.
+func sameContent(localFile, remoteFile fileutils.FileInfo, matches ETagMatcher) (bool, error) {
+	if localFile.Checksum == remoteFile.Checksum {
+		return true, nil
+	}
+	if matches == nil {
+		return false, nil
+	}
+	same, err := matches(localFile, remoteFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to compare %s: %w", localFile.RelativePath, err)
+	}
+	return same, nil
+}
+
 // syncop represents a sync operation type
 type SyncOp string
 
@@ -106,18 +339,67 @@ const (
 	SyncOpDownload SyncOp = "download"
 	SyncOpDelete   SyncOp = "delete"
 	SyncOpSkip     SyncOp = "skip"
+	SyncOpConflict SyncOp = "conflict"
+	SyncOpMove     SyncOp = "move" // server-side copy from Source, then delete Source
+	SyncOpCopy     SyncOp = "copy" // server-side copy from Source
+
+	SyncOpLocalMove SyncOp = "local-move" // rename the local file at Source
+	SyncOpLocalCopy SyncOp = "local-copy" // copy the local file at Source
 )
 
 // syncaction represents an action to be taken during sync
 type SyncAction struct {
-	Operation    SyncOp             `json:"operation"`
-	File         fileutils.FileInfo `json:"file"`
-	RelativePath string             `json:"relative_path"`
-	Reason       string             `json:"reason"`
+	Operation     SyncOp              `json:"operation"`
+	File          fileutils.FileInfo  `json:"file"`
+	RemoteFile    *fileutils.FileInfo `json:"remote_file,omitempty"` // remote side of a conflict
+	RelativePath  string              `json:"relative_path"`
+	Source        string              `json:"source,omitempty"`         // existing object copied by moves and copies
+	SourcePending bool                `json:"source_pending,omitempty"` // source is uploaded earlier in the same run
+	Base          *fileutils.FileInfo `json:"base,omitempty"`           // last synced version reused by delta uploads
+	Reason        string              `json:"reason"`
+	Resolution    ConflictPolicy      `json:"resolution,omitempty"` // chosen resolution of a conflict
+}
+
+// effectiveop returns the transfer an action performs, resolving conflicts to their chosen side
+func (a SyncAction) EffectiveOp() SyncOp {
+	if a.Operation != SyncOpConflict {
+		return a.Operation
+	}
+	switch a.Resolution {
+	case ConflictLocal:
+		return SyncOpUpload
+	case ConflictRemote, ConflictKeepBoth:
+		return SyncOpDownload
+	}
+	return SyncOpSkip
 }
 
-// computesyncactions compares local and remote manifests to determine sync actions
+// computesyncactions compares local and remote manifests to determine sync actions. files never
+// synced that exist on both sides are identical only if their entries have the same checksum.
 func ComputeSyncActions(localManifest, remoteManifest, lastKnownManifest *Manifest) []SyncAction {
+	actions, _ := ComputeSharedSyncActions(localManifest, remoteManifest, lastKnownManifest, nil, nil)
+	return actions
+}
+
+// computesharedsyncactions determines sync actions for a prefix with a shared state object, if
+// state is not nil. files another client deleted are skipped before moves, copies and duplicate
+// uploads are detected, so that they are not copied back from an object with the same content.
+// files never synced that exist on both sides are compared with matches.
+func ComputeSharedSyncActions(localManifest, remoteManifest, lastKnownManifest *Manifest, state *RemoteState, matches ETagMatcher) ([]SyncAction, error) {
+	actions, err := compareManifests(localManifest, remoteManifest, lastKnownManifest, matches)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		applyTombstones(actions, remoteManifest, lastKnownManifest, state)
+	}
+	actions = detectRemoteMoves(actions, localManifest, remoteManifest, lastKnownManifest)
+	actions = deduplicateUploads(actions)
+	return detectLocalMoves(actions, localManifest, remoteManifest, lastKnownManifest), nil
+}
+
+// comparemanifests determines the transfer of each file from its local, remote and last known state
+func compareManifests(localManifest, remoteManifest, lastKnownManifest *Manifest, matches ETagMatcher) ([]SyncAction, error) {
 	actions := make([]SyncAction, 0)
 
 	// create maps for efficient lookup
@@ -149,74 +431,77 @@ func ComputeSyncActions(localManifest, remoteManifest, lastKnownManifest *Manife
 					Reason:       "deleted remotely, exists locally",
 				})
 			}
-		} else {
-			// file exists both locally and remotely
-			if localFile.Checksum != remoteFile.Checksum {
-				// files differ - check which one is newer
-				if wasKnown {
-					localChanged := localFile.Checksum != lastKnownFile.Checksum
-					remoteChanged := remoteFile.Checksum != lastKnownFile.Checksum
-
-					if localChanged && !remoteChanged {
-						// only local changed - upload
-						actions = append(actions, SyncAction{
-							Operation:    SyncOpUpload,
-							File:         localFile,
-							RelativePath: relativePath,
-							Reason:       "local file modified",
-						})
-					} else if !localChanged && remoteChanged {
-						// only remote changed - download
-						actions = append(actions, SyncAction{
-							Operation:    SyncOpDownload,
-							File:         remoteFile,
-							RelativePath: relativePath,
-							Reason:       "remote file modified",
-						})
-					} else {
-						// both changed - use modification time to resolve
-						if localFile.ModTime.After(remoteFile.ModTime) {
-							actions = append(actions, SyncAction{
-								Operation:    SyncOpUpload,
-								File:         localFile,
-								RelativePath: relativePath,
-								Reason:       "local file newer (conflict resolution)",
-							})
-						} else {
-							actions = append(actions, SyncAction{
-								Operation:    SyncOpDownload,
-								File:         remoteFile,
-								RelativePath: relativePath,
-								Reason:       "remote file newer (conflict resolution)",
-							})
-						}
-					}
-				} else {
-					// file not in last known state - use modification time
-					if localFile.ModTime.After(remoteFile.ModTime) {
-						actions = append(actions, SyncAction{
-							Operation:    SyncOpUpload,
-							File:         localFile,
-							RelativePath: relativePath,
-							Reason:       "local file newer",
-						})
-					} else {
-						actions = append(actions, SyncAction{
-							Operation:    SyncOpDownload,
-							File:         remoteFile,
-							RelativePath: relativePath,
-							Reason:       "remote file newer",
-						})
-					}
-				}
-			} else {
-				// files are identical - skip
+		} else if wasKnown {
+			// file exists on both sides and was synced before - compare each side to the last known state
+			localChanged := LocalChanged(localFile, lastKnownFile)
+			remoteChanged := RemoteChanged(remoteFile, lastKnownFile)
+
+			if !localChanged && !remoteChanged {
+				// nothing changed since the last sync - skip
 				actions = append(actions, SyncAction{
 					Operation:    SyncOpSkip,
 					File:         localFile,
 					RelativePath: relativePath,
 					Reason:       "files identical",
 				})
+			} else if localChanged && !remoteChanged {
+				// only local changed - upload, reusing unchanged parts of the synced version
+				action := SyncAction{
+					Operation:    SyncOpUpload,
+					File:         localFile,
+					RelativePath: relativePath,
+					Reason:       "local file modified",
+				}
+				if len(lastKnownFile.PartHashes) > 0 {
+					base := lastKnownFile
+					action.Base = &base
+				}
+				actions = append(actions, action)
+			} else if !localChanged && remoteChanged {
+				// only remote changed - download
+				actions = append(actions, SyncAction{
+					Operation:    SyncOpDownload,
+					File:         remoteFile,
+					RelativePath: relativePath,
+					Reason:       "remote file modified",
+				})
+			} else {
+				// both changed - resolved later according to the conflict policy
+				conflictingFile := remoteFile
+				actions = append(actions, SyncAction{
+					Operation:    SyncOpConflict,
+					File:         localFile,
+					RemoteFile:   &conflictingFile,
+					RelativePath: relativePath,
+					Reason:       "changed on both sides",
+				})
+			}
+		} else if same, err := sameContent(localFile, remoteFile, matches); err != nil {
+			return nil, err
+		} else if same {
+			// files are identical - skip
+			actions = append(actions, SyncAction{
+				Operation:    SyncOpSkip,
+				File:         localFile,
+				RelativePath: relativePath,
+				Reason:       "files identical",
+			})
+		} else {
+			// file not in last known state - use modification time
+			if localFile.ModTime.After(remoteFile.ModTime) {
+				actions = append(actions, SyncAction{
+					Operation:    SyncOpUpload,
+					File:         localFile,
+					RelativePath: relativePath,
+					Reason:       "local file newer",
+				})
+			} else {
+				actions = append(actions, SyncAction{
+					Operation:    SyncOpDownload,
+					File:         remoteFile,
+					RelativePath: relativePath,
+					Reason:       "remote file newer",
+				})
 			}
 		}
 	}
@@ -244,5 +529,5 @@ func ComputeSyncActions(localManifest, remoteManifest, lastKnownManifest *Manife
 		}
 	}
 
-	return actions
+	return actions, nil
 }
@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+func TestUnsyncedFilesMatchedByETag(t *testing.T) {
+	now := time.Now()
+	local := &Manifest{Files: map[string]fileutils.FileInfo{
+		"same.txt":  {RelativePath: "same.txt", Size: 4, Checksum: "sha-same", ModTime: now},
+		"other.txt": {RelativePath: "other.txt", Size: 4, Checksum: "sha-other", ModTime: now},
+	}}
+	remote := &Manifest{Files: map[string]fileutils.FileInfo{
+		"same.txt":  {RelativePath: "same.txt", Size: 4, Checksum: "etag-same", ETag: "etag-same", ModTime: now.Add(-time.Hour)},
+		"other.txt": {RelativePath: "other.txt", Size: 4, Checksum: "etag-other", ETag: "etag-other", ModTime: now.Add(-time.Hour)},
+	}}
+	matches := func(local, remote fileutils.FileInfo) (bool, error) {
+		return local.RelativePath == "same.txt" && remote.ETag == "etag-same", nil
+	}
+
+	actions, err := ComputeSharedSyncActions(local, remote, emptyManifest(), nil, matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := make(map[string]SyncOp)
+	for _, action := range actions {
+		ops[action.RelativePath] = action.Operation
+	}
+	if ops["same.txt"] != SyncOpSkip || ops["other.txt"] != SyncOpUpload {
+		t.Errorf("planned %v, want same.txt skipped and other.txt uploaded", ops)
+	}
+
+	manifest, err := SyncedManifest(local, remote, emptyManifest(), nil, matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := manifest.Files["same.txt"]; !ok || entry.Checksum != "sha-same" || entry.ETag != "etag-same" {
+		t.Errorf("same.txt recorded as %+v, want the local checksum and remote etag", entry)
+	}
+	if _, ok := manifest.Files["other.txt"]; ok {
+		t.Error("other.txt recorded as in sync without being transferred")
+	}
+
+	// status agrees with the plan
+	statuses, err := ComputeStatus(local, remote, emptyManifest(), matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].RelativePath != "other.txt" {
+		t.Errorf("status %+v, want only other.txt pending", statuses)
+	}
+}
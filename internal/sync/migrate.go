@@ -0,0 +1,186 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// manifestversion is the schema version written by this build.
+//
+//	1  original layout; checksum held either the local sha256 or, after downloads, the s3 etag
+//	2  entries keep the local sha256 in checksum and the remote etag in etag
+const ManifestVersion = 2
+
+// errmanifesttoonew is returned for manifests written by a newer version of s3sync
+var ErrManifestTooNew = errors.New("manifest was written by a newer version of s3sync")
+
+// sha256pattern matches a hex sha256 checksum
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// legacypartsize is the default chunk size of multipart uploads, used to reproduce the multipart
+// etags of entries that did not record a part size
+const legacyPartSize = 8 << 20
+
+// manifestmigration upgrades a manifest of the tree at localpath from one schema version to the next
+type manifestMigration struct {
+	from        int
+	description string
+	migrate     func(manifest *Manifest, localPath string)
+}
+
+// manifestmigrations is the migration chain, ordered by source version
+var manifestMigrations = []manifestMigration{
+	{
+		from:        1,
+		description: "move s3 etags recorded as checksums into the etag field and rehash files that still match them",
+		migrate: func(manifest *Manifest, localPath string) {
+			for relativePath, file := range manifest.Files {
+				if file.ETag == "" && file.Checksum != "" && !sha256Pattern.MatchString(file.Checksum) {
+					file.ETag = file.Checksum
+					file.Checksum = localChecksum(filepath.Join(localPath, relativePath), file)
+					manifest.Files[relativePath] = file
+				}
+			}
+		},
+	},
+}
+
+// localchecksum returns the sha256 of a local file that still has the content of the object its
+// entry recorded the etag of. a file that changed since, or cannot be read, gets no checksum and
+// counts as changed locally.
+func localChecksum(path string, file fileutils.FileInfo) string {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != file.Size {
+		return ""
+	}
+	partSize := file.PartSize
+	if partSize == 0 {
+		partSize = legacyPartSize
+	}
+	if same, err := fileutils.MatchesETag(path, file.ETag, partSize); err != nil || !same {
+		return ""
+	}
+	checksum, err := fileutils.FileChecksum(path)
+	if err != nil {
+		return ""
+	}
+	return checksum
+}
+
+// manifestschemaversion returns the schema version of a loaded manifest; manifests written
+// before versioning have none and are version 1
+func manifestSchemaVersion(manifest *Manifest) int {
+	if manifest.Version == 0 {
+		return 1
+	}
+	return manifest.Version
+}
+
+// migratemanifest upgrades a manifest of the tree at localpath to the current schema version in
+// place and returns the descriptions of the migrations applied
+func MigrateManifest(manifest *Manifest, localPath string) ([]string, error) {
+	version := manifestSchemaVersion(manifest)
+	if version > ManifestVersion {
+		return nil, fmt.Errorf("%w (version %d, supported %d)", ErrManifestTooNew, version, ManifestVersion)
+	}
+
+	var applied []string
+	for _, migration := range manifestMigrations {
+		if migration.from != version {
+			continue
+		}
+		migration.migrate(manifest, localPath)
+		applied = append(applied, fmt.Sprintf("v%d → v%d: %s", migration.from, migration.from+1, migration.description))
+		version++
+	}
+	if version != ManifestVersion {
+		return applied, fmt.Errorf("no migration from manifest version %d", version)
+	}
+
+	manifest.Version = ManifestVersion
+	return applied, nil
+}
+
+// manifestproblem is an inconsistency found by verifymanifest
+type ManifestProblem struct {
+	RelativePath string `json:"relative_path,omitempty"`
+	Problem      string `json:"problem"`
+}
+
+// verifymanifest checks a loaded manifest for entries that cannot be right
+func VerifyManifest(manifest *Manifest) []ManifestProblem {
+	problems := make([]ManifestProblem, 0)
+	if manifest.Bucket == "" && len(manifest.Files) > 0 {
+		problems = append(problems, ManifestProblem{Problem: "manifest has files but no bucket"})
+	}
+
+	keys := make([]string, 0, len(manifest.Files))
+	for key := range manifest.Files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		file := manifest.Files[key]
+		add := func(format string, args ...interface{}) {
+			problems = append(problems, ManifestProblem{RelativePath: key, Problem: fmt.Sprintf(format, args...)})
+		}
+
+		if file.RelativePath != key {
+			add("entry is stored under a different path (%s)", file.RelativePath)
+		}
+		if key == "" || filepath.IsAbs(key) || strings.HasPrefix(filepath.Clean(key), "..") {
+			add("relative path is empty or outside the sync root")
+		}
+		if file.Size < 0 {
+			add("negative size %d", file.Size)
+		}
+		if file.Checksum != "" && !sha256Pattern.MatchString(file.Checksum) {
+			add("checksum %q is not a sha256", file.Checksum)
+		}
+		if file.Checksum == "" && file.ETag == "" {
+			add("neither checksum nor etag recorded")
+		}
+	}
+
+	return problems
+}
+
+// etagmatcher reports whether a local file has the content of a remote object
+type ETagMatcher func(local, remote fileutils.FileInfo) (bool, error)
+
+// rebuildmanifest reconstructs a lost manifest from a local scan and a remote listing. files
+// whose content matches on both sides are recorded as synced; everything else is left out and
+// is treated as unknown by the next sync.
+func RebuildManifest(localManifest, remoteManifest *Manifest, matches ETagMatcher) (*Manifest, error) {
+	manifest := emptyManifest()
+	manifest.Bucket = remoteManifest.Bucket
+	manifest.Prefix = remoteManifest.Prefix
+
+	for localFile, err := range sortedEntries(localManifest.Files) {
+		if err != nil {
+			return nil, err
+		}
+		remoteFile, ok := remoteManifest.Files[localFile.RelativePath]
+		if !ok {
+			continue
+		}
+		same, err := matches(localFile, remoteFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s: %w", localFile.RelativePath, err)
+		}
+		if same {
+			localFile.ETag = remoteFile.ETag
+			manifest.Files[localFile.RelativePath] = localFile
+		}
+	}
+
+	return manifest, nil
+}
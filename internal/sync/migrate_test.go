@@ -0,0 +1,46 @@
+package sync
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+func TestMigrateV1RehashesPulledFiles(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("pulled under v1\n")
+	if err := os.WriteFile(filepath.Join(dir, "same.txt"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "edited.txt"), []byte("edited since\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	etag := fmt.Sprintf("%x", md5.Sum(content))
+	size := int64(len(content))
+
+	manifest := &Manifest{Files: map[string]fileutils.FileInfo{
+		"same.txt":   {RelativePath: "same.txt", Size: size, Checksum: etag},
+		"edited.txt": {RelativePath: "edited.txt", Size: size, Checksum: etag},
+	}}
+	if _, err := MigrateManifest(manifest, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	same := manifest.Files["same.txt"]
+	if want := fmt.Sprintf("%x", sha256.Sum256(content)); same.Checksum != want || same.ETag != etag {
+		t.Errorf("same.txt migrated to checksum %q etag %q, want %q and %q", same.Checksum, same.ETag, want, etag)
+	}
+	local := fileutils.FileInfo{RelativePath: "same.txt", Size: size, Checksum: same.Checksum}
+	if LocalChanged(local, same) {
+		t.Error("unchanged file pulled under v1 counts as changed locally")
+	}
+
+	if edited := manifest.Files["edited.txt"]; edited.Checksum != "" || edited.ETag != etag {
+		t.Errorf("edited.txt migrated to checksum %q etag %q, want no checksum and etag %q", edited.Checksum, edited.ETag, etag)
+	}
+}
@@ -0,0 +1,234 @@
+package sync
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// copysource is a synced file whose content a new file on the other side may reuse
+type copySource struct {
+	relativePath string
+	file         fileutils.FileInfo
+	moved        bool // the file is gone on the other side, so it can be moved instead of copied
+}
+
+// detectremotemoves turns uploads into server-side moves and copies. a file whose checksum
+// matches a synced file that was deleted locally is a rename: the object is moved and the
+// deletion is covered by the move. a match with a synced file that still exists unchanged
+// locally is a copy of the existing object. sources must be unchanged remotely since the last
+// sync; each deleted file is moved at most once, further matches are uploaded.
+func detectRemoteMoves(actions []SyncAction, localManifest, remoteManifest, lastKnownManifest *Manifest) []SyncAction {
+	sources := make(map[string][]*copySource)
+	for relativePath, lastKnownFile := range lastKnownManifest.Files {
+		if lastKnownFile.Checksum == "" {
+			continue
+		}
+		remoteFile, remoteExists := remoteManifest.Files[relativePath]
+		if !remoteExists || RemoteChanged(remoteFile, lastKnownFile) {
+			continue
+		}
+
+		localFile, localExists := localManifest.Files[relativePath]
+		if localExists && LocalChanged(localFile, lastKnownFile) {
+			continue
+		}
+		sources[lastKnownFile.Checksum] = append(sources[lastKnownFile.Checksum], &copySource{
+			relativePath: relativePath,
+			file:         lastKnownFile,
+			moved:        !localExists,
+		})
+	}
+	if len(sources) == 0 {
+		return actions
+	}
+
+	sortCopySources(sources)
+
+	uploads := pendingUploads(actions)
+	movedFrom := make(map[string]bool)
+	for _, index := range uploads {
+		action := &actions[index]
+		source := pickCopySource(sources[action.File.Checksum], action.File, movedFrom)
+		if source == nil {
+			continue
+		}
+
+		action.Source = source.relativePath
+		if source.moved {
+			action.Operation = SyncOpMove
+			action.Reason = fmt.Sprintf("moved from %s", source.relativePath)
+			movedFrom[source.relativePath] = true
+		} else {
+			action.Operation = SyncOpCopy
+			action.Reason = fmt.Sprintf("copy of %s", source.relativePath)
+		}
+	}
+
+	// the local deletion of a moved file is carried out by its move
+	return dropMovedSources(actions, movedFrom, SyncOpSkip)
+}
+
+// deduplicateuploads uploads content shared by several pending uploads only once. the first
+// path in order is uploaded and the others are copied from it on the server side afterwards.
+func deduplicateUploads(actions []SyncAction) []SyncAction {
+	uploaded := make(map[string]string)
+	for _, index := range pendingUploads(actions) {
+		action := &actions[index]
+		if action.File.Size == 0 {
+			continue
+		}
+
+		source, seen := uploaded[action.File.Checksum]
+		if !seen {
+			uploaded[action.File.Checksum] = action.RelativePath
+			continue
+		}
+		action.Operation = SyncOpCopy
+		action.Source = source
+		action.SourcePending = true
+		action.Reason = fmt.Sprintf("duplicate of %s", source)
+	}
+
+	return actions
+}
+
+// pendinguploads returns the indexes of the uploads with a known checksum, ordered by path
+func pendingUploads(actions []SyncAction) []int {
+	uploads := make([]int, 0)
+	for i, action := range actions {
+		if action.Operation == SyncOpUpload && action.File.Checksum != "" {
+			uploads = append(uploads, i)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return actions[uploads[i]].RelativePath < actions[uploads[j]].RelativePath
+	})
+	return uploads
+}
+
+// pickcopysource returns the first candidate with the size of file that is still available
+func pickCopySource(candidates []*copySource, file fileutils.FileInfo, movedFrom map[string]bool) *copySource {
+	for _, candidate := range candidates {
+		if candidate.file.Size != file.Size {
+			continue
+		}
+		if candidate.moved && movedFrom[candidate.relativePath] {
+			continue
+		}
+		return candidate
+	}
+	return nil
+}
+
+// detectlocalmoves turns downloads of new remote objects into local moves and copies. a new
+// object whose etag matches a synced file that was deleted remotely is a rename: the local
+// file is moved, and the upload planned for the deleted file is dropped by
+// droplocallymovedsources in directions that run the move. a match with a synced file that
+// still exists unchanged remotely is copied locally; copies are used rather than hard links so
+// later edits to one path do not change the other. sources must be unchanged locally since the
+// last sync; each deleted file is moved at most once, further matches are downloaded.
+func detectLocalMoves(actions []SyncAction, localManifest, remoteManifest, lastKnownManifest *Manifest) []SyncAction {
+	sources := make(map[string][]*copySource)
+	for relativePath, lastKnownFile := range lastKnownManifest.Files {
+		if lastKnownFile.ETag == "" {
+			continue
+		}
+		localFile, localExists := localManifest.Files[relativePath]
+		if !localExists || localFile.Checksum == "" || LocalChanged(localFile, lastKnownFile) {
+			continue
+		}
+
+		remoteFile, remoteExists := remoteManifest.Files[relativePath]
+		if remoteExists && RemoteChanged(remoteFile, lastKnownFile) {
+			continue
+		}
+		sources[lastKnownFile.ETag] = append(sources[lastKnownFile.ETag], &copySource{
+			relativePath: relativePath,
+			file:         localFile,
+			moved:        !remoteExists,
+		})
+	}
+	if len(sources) == 0 {
+		return actions
+	}
+	sortCopySources(sources)
+
+	downloads := make([]int, 0)
+	for i, action := range actions {
+		_, localExists := localManifest.Files[action.RelativePath]
+		_, wasKnown := lastKnownManifest.Files[action.RelativePath]
+		if action.Operation == SyncOpDownload && !localExists && !wasKnown && action.File.ETag != "" {
+			downloads = append(downloads, i)
+		}
+	}
+	sort.Slice(downloads, func(i, j int) bool {
+		return actions[downloads[i]].RelativePath < actions[downloads[j]].RelativePath
+	})
+
+	movedFrom := make(map[string]bool)
+	for _, index := range downloads {
+		action := &actions[index]
+		source := pickCopySource(sources[action.File.ETag], action.File, movedFrom)
+		if source == nil {
+			continue
+		}
+
+		action.Source = source.relativePath
+		action.File.Checksum = source.file.Checksum
+		if source.moved {
+			action.Operation = SyncOpLocalMove
+			action.Reason = fmt.Sprintf("moved remotely from %s", source.relativePath)
+			movedFrom[source.relativePath] = true
+		} else {
+			action.Operation = SyncOpLocalCopy
+			action.Reason = fmt.Sprintf("remote copy of %s", source.relativePath)
+		}
+	}
+
+	return actions
+}
+
+// droplocallymovedsources drops the uploads of files deleted remotely that a local move of the
+// plan carries out, if ops run local moves. push does not move local files, so it keeps
+// uploading them again.
+func DropLocallyMovedSources(actions []SyncAction, ops []SyncOp) []SyncAction {
+	if !ContainsOp(ops, SyncOpLocalMove) {
+		return actions
+	}
+	movedFrom := make(map[string]bool)
+	for _, action := range actions {
+		if action.Operation == SyncOpLocalMove {
+			movedFrom[action.Source] = true
+		}
+	}
+	return dropMovedSources(actions, movedFrom, SyncOpUpload)
+}
+
+// sortcopysources orders candidates by preferring moves, then the first path, so plans are stable
+func sortCopySources(sources map[string][]*copySource) {
+	for _, candidates := range sources {
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].moved != candidates[j].moved {
+				return candidates[i].moved
+			}
+			return candidates[i].relativePath < candidates[j].relativePath
+		})
+	}
+}
+
+// dropmovedsources removes the actions with operation op planned for files that were moved
+func dropMovedSources(actions []SyncAction, movedFrom map[string]bool, op SyncOp) []SyncAction {
+	if len(movedFrom) == 0 {
+		return actions
+	}
+	kept := actions[:0]
+	for _, action := range actions {
+		if action.Operation == op && movedFrom[action.RelativePath] {
+			continue
+		}
+		kept = append(kept, action)
+	}
+	return kept
+}
@@ -0,0 +1,279 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// synced returns a file as recorded by the last sync, with the same entry on both sides
+func synced(relativePath, content string) fileutils.FileInfo {
+	return fileutils.FileInfo{RelativePath: relativePath, Size: int64(len(content)), Checksum: "sha-" + content, ETag: "etag-" + content}
+}
+
+// manifestof builds a manifest from files keyed by their relative path
+func manifestOf(files ...fileutils.FileInfo) *Manifest {
+	manifest := &Manifest{Files: make(map[string]fileutils.FileInfo)}
+	for _, file := range files {
+		manifest.Files[file.RelativePath] = file
+	}
+	return manifest
+}
+
+// plannedmove is the operation and source planned for a path
+type plannedMove struct {
+	op     SyncOp
+	source string
+}
+
+// plannedmoves indexes actions by path
+func plannedMoves(actions []SyncAction) map[string]plannedMove {
+	planned := make(map[string]plannedMove)
+	for _, action := range actions {
+		planned[action.RelativePath] = plannedMove{action.Operation, action.Source}
+	}
+	return planned
+}
+
+func TestDetectRemoteMoves(t *testing.T) {
+	changed := synced("a.txt", "one")
+	changed.ETag = "etag-edited"
+
+	tests := []struct {
+		name      string
+		local     *Manifest
+		remote    *Manifest
+		lastKnown *Manifest
+		want      map[string]plannedMove
+		dropped   string // path whose deletion is carried out by a move
+	}{
+		{
+			name:      "rename",
+			local:     manifestOf(synced("b.txt", "one")),
+			remote:    manifestOf(synced("a.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"b.txt": {SyncOpMove, "a.txt"}},
+			dropped:   "a.txt",
+		},
+		{
+			name:      "copy",
+			local:     manifestOf(synced("a.txt", "one"), synced("b.txt", "one")),
+			remote:    manifestOf(synced("a.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"b.txt": {SyncOpCopy, "a.txt"}},
+		},
+		{
+			name:      "source changed remotely",
+			local:     manifestOf(synced("b.txt", "one")),
+			remote:    manifestOf(changed),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"b.txt": {SyncOpUpload, ""}},
+		},
+		{
+			name:      "source changed locally",
+			local:     manifestOf(synced("a.txt", "two"), synced("b.txt", "one")),
+			remote:    manifestOf(synced("a.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"a.txt": {SyncOpUpload, ""}, "b.txt": {SyncOpUpload, ""}},
+		},
+		{
+			name:      "moved once",
+			local:     manifestOf(synced("b.txt", "one"), synced("c.txt", "one")),
+			remote:    manifestOf(synced("a.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"b.txt": {SyncOpMove, "a.txt"}, "c.txt": {SyncOpUpload, ""}},
+		},
+		{
+			name:      "different size",
+			local:     manifestOf(fileutils.FileInfo{RelativePath: "b.txt", Size: 9, Checksum: "sha-one"}),
+			remote:    manifestOf(synced("a.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"a.txt": {SyncOpSkip, ""}, "b.txt": {SyncOpUpload, ""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions, err := compareManifests(test.local, test.remote, test.lastKnown, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := plannedMoves(detectRemoteMoves(actions, test.local, test.remote, test.lastKnown))
+			for relativePath, want := range test.want {
+				if got[relativePath] != want {
+					t.Errorf("%s planned as %+v, want %+v", relativePath, got[relativePath], want)
+				}
+			}
+			for relativePath, planned := range got {
+				if _, expected := test.want[relativePath]; !expected && planned.op != SyncOpSkip {
+					t.Errorf("unexpected %s of %s", planned.op, relativePath)
+				}
+			}
+			if _, planned := got[test.dropped]; test.dropped != "" && planned {
+				t.Errorf("%s is still planned after its move", test.dropped)
+			}
+		})
+	}
+}
+
+func TestDetectLocalMoves(t *testing.T) {
+	edited := synced("a.txt", "one")
+	edited.ETag = "etag-edited"
+
+	tests := []struct {
+		name      string
+		local     *Manifest
+		remote    *Manifest
+		lastKnown *Manifest
+		want      map[string]plannedMove
+	}{
+		{
+			name:      "rename",
+			local:     manifestOf(synced("a.txt", "one")),
+			remote:    manifestOf(synced("b.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"a.txt": {SyncOpUpload, ""}, "b.txt": {SyncOpLocalMove, "a.txt"}},
+		},
+		{
+			name:      "copy",
+			local:     manifestOf(synced("a.txt", "one")),
+			remote:    manifestOf(synced("a.txt", "one"), synced("b.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"b.txt": {SyncOpLocalCopy, "a.txt"}},
+		},
+		{
+			name:      "source changed locally",
+			local:     manifestOf(synced("a.txt", "two")),
+			remote:    manifestOf(synced("a.txt", "one"), synced("b.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"a.txt": {SyncOpUpload, ""}, "b.txt": {SyncOpDownload, ""}},
+		},
+		{
+			name:      "source changed remotely",
+			local:     manifestOf(synced("a.txt", "one")),
+			remote:    manifestOf(edited, synced("b.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"a.txt": {SyncOpDownload, ""}, "b.txt": {SyncOpDownload, ""}},
+		},
+		{
+			name:      "moved once",
+			local:     manifestOf(synced("a.txt", "one")),
+			remote:    manifestOf(synced("b.txt", "one"), synced("c.txt", "one")),
+			lastKnown: manifestOf(synced("a.txt", "one")),
+			want:      map[string]plannedMove{"b.txt": {SyncOpLocalMove, "a.txt"}, "c.txt": {SyncOpDownload, ""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions, err := compareManifests(test.local, test.remote, test.lastKnown, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions = detectLocalMoves(actions, test.local, test.remote, test.lastKnown)
+			got := plannedMoves(actions)
+			for relativePath, want := range test.want {
+				if got[relativePath] != want {
+					t.Errorf("%s planned as %+v, want %+v", relativePath, got[relativePath], want)
+				}
+			}
+			for _, action := range actions {
+				if action.Source != "" && action.File.Checksum != test.local.Files[action.Source].Checksum {
+					t.Errorf("%s of %s carries checksum %q, want the local source's", action.Operation, action.RelativePath, action.File.Checksum)
+				}
+			}
+		})
+	}
+}
+
+func TestDeduplicateUploads(t *testing.T) {
+	tests := []struct {
+		name    string
+		uploads []fileutils.FileInfo
+		want    map[string]plannedMove
+	}{
+		{
+			name:    "first path uploaded",
+			uploads: []fileutils.FileInfo{synced("c.txt", "one"), synced("a.txt", "one"), synced("b.txt", "one")},
+			want:    map[string]plannedMove{"a.txt": {SyncOpUpload, ""}, "b.txt": {SyncOpCopy, "a.txt"}, "c.txt": {SyncOpCopy, "a.txt"}},
+		},
+		{
+			name:    "different content",
+			uploads: []fileutils.FileInfo{synced("a.txt", "one"), synced("b.txt", "two")},
+			want:    map[string]plannedMove{"a.txt": {SyncOpUpload, ""}, "b.txt": {SyncOpUpload, ""}},
+		},
+		{
+			name:    "empty files",
+			uploads: []fileutils.FileInfo{synced("a.txt", ""), synced("b.txt", "")},
+			want:    map[string]plannedMove{"a.txt": {SyncOpUpload, ""}, "b.txt": {SyncOpUpload, ""}},
+		},
+		{
+			name:    "unknown checksum",
+			uploads: []fileutils.FileInfo{{RelativePath: "a.txt", Size: 3}, {RelativePath: "b.txt", Size: 3}},
+			want:    map[string]plannedMove{"a.txt": {SyncOpUpload, ""}, "b.txt": {SyncOpUpload, ""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions := make([]SyncAction, 0, len(test.uploads))
+			for _, file := range test.uploads {
+				actions = append(actions, SyncAction{Operation: SyncOpUpload, File: file, RelativePath: file.RelativePath})
+			}
+			actions = deduplicateUploads(actions)
+			got := plannedMoves(actions)
+			for relativePath, want := range test.want {
+				if got[relativePath] != want {
+					t.Errorf("%s planned as %+v, want %+v", relativePath, got[relativePath], want)
+				}
+			}
+			for _, action := range actions {
+				if pending := action.Operation == SyncOpCopy; action.SourcePending != pending {
+					t.Errorf("%s has source pending %v", action.RelativePath, action.SourcePending)
+				}
+			}
+		})
+	}
+}
+
+func TestDropLocallyMovedSources(t *testing.T) {
+	// another client renamed a.txt to b.txt
+	lastKnown := &Manifest{Files: map[string]fileutils.FileInfo{"a.txt": synced("a.txt", "one")}}
+	local := &Manifest{Files: map[string]fileutils.FileInfo{"a.txt": synced("a.txt", "one")}}
+	remote := &Manifest{Files: map[string]fileutils.FileInfo{"b.txt": synced("b.txt", "one")}}
+
+	tests := []struct {
+		direction  Direction
+		wantUpload bool
+	}{
+		{DirectionPush, true},
+		{DirectionPull, false},
+		{DirectionSync, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.direction), func(t *testing.T) {
+			ops, err := DirectionOps(test.direction)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions := DropLocallyMovedSources(ComputeSyncActions(local, remote, lastKnown), ops)
+
+			uploaded, moved := false, false
+			for _, action := range actions {
+				switch {
+				case action.RelativePath == "a.txt" && action.Operation == SyncOpUpload:
+					uploaded = true
+				case action.RelativePath == "b.txt" && action.Operation == SyncOpLocalMove && action.Source == "a.txt":
+					moved = true
+				}
+			}
+			if !moved {
+				t.Errorf("b.txt is not planned as a local move of a.txt: %+v", actions)
+			}
+			if uploaded != test.wantUpload {
+				t.Errorf("a.txt uploaded: %v, want %v", uploaded, test.wantUpload)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// planversion is the current format version of saved plan files
+const PlanVersion = 1
+
+// errplanstale is returned when local or remote state changed after a plan was created
+var ErrPlanStale = errors.New("plan is stale")
+
+// direction selects which side of a sync is updated
+type Direction string
+
+const (
+	DirectionPush Direction = "push"
+	DirectionPull Direction = "pull"
+	DirectionSync Direction = "sync"
+)
+
+// directionops returns the operations executed for a sync direction
+func DirectionOps(direction Direction) ([]SyncOp, error) {
+	switch direction {
+	case DirectionPush:
+		return []SyncOp{SyncOpUpload, SyncOpMove, SyncOpCopy, SyncOpDelete, SyncOpConflict}, nil
+	case DirectionPull:
+		return []SyncOp{SyncOpDownload, SyncOpLocalMove, SyncOpLocalCopy, SyncOpConflict}, nil
+	case DirectionSync:
+		return []SyncOp{SyncOpUpload, SyncOpMove, SyncOpCopy, SyncOpDownload, SyncOpLocalMove, SyncOpLocalCopy, SyncOpConflict}, nil
+	}
+	return nil, fmt.Errorf("invalid direction %q (expected push, pull or sync)", direction)
+}
+
+// fingerprints identify the inputs a plan was computed from
+type Fingerprints struct {
+	Local    string `json:"local"`
+	Remote   string `json:"remote"`
+	Manifest string `json:"manifest"`
+}
+
+// plan is a saved set of sync actions that can be applied later
+type Plan struct {
+	Version      int          `json:"version"`
+	CreatedAt    time.Time    `json:"created_at"`
+	Direction    Direction    `json:"direction"`
+	Bucket       string       `json:"bucket"`
+	Prefix       string       `json:"prefix,omitempty"`
+	LocalPath    string       `json:"local_path"`
+	Fingerprints Fingerprints `json:"fingerprints"`
+	Actions      []SyncAction `json:"actions"`
+	Summary      Summary      `json:"summary"`
+}
+
+// newplan creates a plan from computed actions and the manifests they were computed from
+func NewPlan(direction Direction, bucketName, localPath string, actions []SyncAction, localManifest, remoteManifest, lastKnownManifest *Manifest) *Plan {
+	// sort by path so saved plans are stable and easy to review
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].RelativePath < actions[j].RelativePath
+	})
+
+	return &Plan{
+		Version:      PlanVersion,
+		CreatedAt:    time.Now().UTC(),
+		Direction:    direction,
+		Bucket:       bucketName,
+		LocalPath:    localPath,
+		Fingerprints: ComputeFingerprints(localManifest, remoteManifest, lastKnownManifest),
+		Actions:      actions,
+		Summary:      Summarize(actions),
+	}
+}
+
+// computefingerprints hashes the content-relevant fields of each manifest
+func ComputeFingerprints(localManifest, remoteManifest, lastKnownManifest *Manifest) Fingerprints {
+	return Fingerprints{
+		Local:    fingerprintManifest(localManifest),
+		Remote:   fingerprintManifest(remoteManifest),
+		Manifest: fingerprintManifest(lastKnownManifest),
+	}
+}
+
+// fingerprintmanifest hashes paths, sizes and checksums in sorted order
+func fingerprintManifest(manifest *Manifest) string {
+	paths := make([]string, 0, len(manifest.Files))
+	for relativePath := range manifest.Files {
+		paths = append(paths, relativePath)
+	}
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	for _, relativePath := range paths {
+		file := manifest.Files[relativePath]
+		fmt.Fprintf(hash, "%s\x00%d\x00%s\n", relativePath, file.Size, file.Checksum)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// checkdrift verifies that the current manifests match the ones the plan was computed from
+func (p *Plan) CheckDrift(localManifest, remoteManifest, lastKnownManifest *Manifest) error {
+	current := ComputeFingerprints(localManifest, remoteManifest, lastKnownManifest)
+
+	var changed []string
+	if current.Local != p.Fingerprints.Local {
+		changed = append(changed, "local files")
+	}
+	if current.Remote != p.Fingerprints.Remote {
+		changed = append(changed, "remote objects")
+	}
+	if current.Manifest != p.Fingerprints.Manifest {
+		changed = append(changed, "sync manifest")
+	}
+
+	if len(changed) > 0 {
+		return fmt.Errorf("%w: %v changed since the plan was created", ErrPlanStale, changed)
+	}
+
+	return nil
+}
+
+// saveplan writes a plan file
+func SavePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+
+	return nil
+}
+
+// loadplan reads a plan file and checks its version
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file: %w", err)
+	}
+
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d (expected %d)", plan.Version, PlanVersion)
+	}
+	if _, err := DirectionOps(plan.Direction); err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
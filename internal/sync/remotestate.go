@@ -0,0 +1,356 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// remotestatekey is the object holding the shared sync state, relative to the synced prefix
+const RemoteStateKey = ".s3sync/state"
+
+// remotestateversion is the schema version of the shared state object
+const RemoteStateVersion = 1
+
+// remotestateobjectkey returns the key of the shared state object for a prefix
+func RemoteStateObjectKey(prefix string) string {
+	return path.Join(prefix, RemoteStateKey)
+}
+
+// isstatekey reports whether an object key belongs to s3sync's own remote state
+func IsStateKey(relativePath string) bool {
+	return strings.HasPrefix(filepath.ToSlash(relativePath), ".s3sync/")
+}
+
+// writeridentity identifies the client that last wrote the shared state or a file entry
+type WriterIdentity struct {
+	Host     string `json:"host"`
+	User     string `json:"user,omitempty"`
+	ClientID string `json:"client_id"` // random id kept in .s3sync/client-id
+}
+
+func (w WriterIdentity) String() string {
+	if w.User != "" {
+		return fmt.Sprintf("%s@%s (%s)", w.User, w.Host, w.ClientID)
+	}
+	return fmt.Sprintf("%s (%s)", w.Host, w.ClientID)
+}
+
+// remotefilestate is the latest known version of a file in the shared state
+type RemoteFileState struct {
+	ETag      string         `json:"etag,omitempty"`
+	VersionID string         `json:"version_id,omitempty"`
+	Checksum  string         `json:"sha256,omitempty"`
+	Size      int64          `json:"size"`
+	ModTime   time.Time      `json:"mod_time"`
+	Deleted   bool           `json:"deleted,omitempty"` // tombstone: the object was deleted
+	UpdatedAt time.Time      `json:"updated_at"`
+	UpdatedBy WriterIdentity `json:"updated_by"`
+}
+
+// remotestate is the shared state object of a synced prefix. it is replaced with conditional
+// writes so concurrent clients never overwrite each other's updates.
+type RemoteState struct {
+	Version   int                        `json:"version"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+	Writer    WriterIdentity             `json:"writer"`
+	Files     map[string]RemoteFileState `json:"files"`
+
+	etag string // etag the state was read with, empty if the object does not exist yet
+}
+
+// newremotestate returns an empty state for a prefix without a state object
+func NewRemoteState() *RemoteState {
+	return &RemoteState{Version: RemoteStateVersion, Files: make(map[string]RemoteFileState)}
+}
+
+// parseremotestate decodes a state object read with the given etag
+func ParseRemoteState(data []byte, etag string) (*RemoteState, error) {
+	state := NewRemoteState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse remote state: %w", err)
+	}
+	if state.Version > RemoteStateVersion {
+		return nil, fmt.Errorf("remote state version %d is newer than supported version %d", state.Version, RemoteStateVersion)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]RemoteFileState)
+	}
+	state.etag = etag
+	return state, nil
+}
+
+// etag returns the etag the state was read with, to be used as the if-match condition
+func (s *RemoteState) ETag() string {
+	return s.etag
+}
+
+// manifest returns the objects the state records below a prefix in the form a listing of the
+// prefix returns them, for runs that trust the state instead of listing the bucket
+func (s *RemoteState) Manifest(bucketName, prefix string) *Manifest {
+	manifest := &Manifest{Files: make(map[string]fileutils.FileInfo), Bucket: bucketName, Prefix: prefix}
+	for key, entry := range s.Files {
+		if entry.Deleted {
+			continue
+		}
+		manifest.Files[key] = fileutils.FileInfo{
+			Path:         ObjectKey(prefix, key),
+			RelativePath: key,
+			Size:         entry.Size,
+			ModTime:      entry.ModTime,
+			Checksum:     entry.ETag,
+			ETag:         entry.ETag,
+		}
+	}
+	return manifest
+}
+
+// marshal encodes the state for writing
+func (s *RemoteState) Marshal() ([]byte, error) {
+	s.Version = RemoteStateVersion
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// tombstone returns the tombstone of a file, if the shared state records it as deleted
+func (s *RemoteState) Tombstone(relativePath string) (RemoteFileState, bool) {
+	entry, ok := s.Files[filepath.ToSlash(relativePath)]
+	return entry, ok && entry.Deleted
+}
+
+// remotestateupdate is the set of changes a run makes to the shared state. it is computed
+// once and can be applied again to a fresher state after losing a conditional write.
+type RemoteStateUpdate struct {
+	files map[string]RemoteFileState
+	basis map[string]string // etag of the entry an observed change was based on
+}
+
+// newremotestateupdate records the uploads and deletions of a run, objects the listing shows but
+// the state does not know, and tombstones for objects the state knows but the listing no longer
+// shows
+func NewRemoteStateUpdate(localManifest, remoteManifest *Manifest, state *RemoteState, results []ActionResult, writer WriterIdentity, now time.Time) *RemoteStateUpdate {
+	update := &RemoteStateUpdate{
+		files: make(map[string]RemoteFileState),
+		basis: make(map[string]string),
+	}
+
+	uploaded := make(map[string]bool)
+	for _, result := range results {
+		if result.Status != StatusOK {
+			continue
+		}
+		key := filepath.ToSlash(result.RelativePath)
+		if result.Operation == SyncOpDelete {
+			tombstone, known := state.Files[key]
+			if !known {
+				remoteFile := remoteManifest.Files[result.RelativePath]
+				tombstone = RemoteFileState{ETag: remoteFile.ETag, Size: remoteFile.Size, ModTime: remoteFile.ModTime}
+			}
+			tombstone.Deleted = true
+			tombstone.UpdatedAt = now
+			tombstone.UpdatedBy = writer
+			update.files[key] = tombstone
+			uploaded[key] = true
+			continue
+		}
+		if !writesRemote(result.EffectiveOp()) {
+			continue
+		}
+		file := localManifest.Files[result.RelativePath]
+		update.files[key] = RemoteFileState{
+			ETag:      result.ETag,
+			VersionID: result.VersionID,
+			Checksum:  file.Checksum,
+			Size:      file.Size,
+			ModTime:   file.ModTime,
+			UpdatedAt: now,
+			UpdatedBy: writer,
+		}
+		uploaded[key] = true
+
+		if result.Operation == SyncOpMove {
+			sourceKey := filepath.ToSlash(result.Source)
+			tombstone := state.Files[sourceKey]
+			tombstone.Deleted = true
+			tombstone.UpdatedAt = now
+			tombstone.UpdatedBy = writer
+			update.files[sourceKey] = tombstone
+			uploaded[sourceKey] = true
+		}
+	}
+
+	// objects written by clients that do not maintain the shared state
+	for relativePath, remoteFile := range remoteManifest.Files {
+		key := filepath.ToSlash(relativePath)
+		if uploaded[key] {
+			continue
+		}
+		entry, known := state.Files[key]
+		if known && !entry.Deleted && entry.ETag == remoteFile.ETag {
+			continue
+		}
+		update.files[key] = RemoteFileState{
+			ETag:      remoteFile.ETag,
+			Size:      remoteFile.Size,
+			ModTime:   remoteFile.ModTime,
+			UpdatedAt: now,
+			UpdatedBy: writer,
+		}
+		update.basis[key] = entry.ETag
+	}
+
+	// objects deleted since the state was written
+	for key, entry := range state.Files {
+		if entry.Deleted || uploaded[key] {
+			continue
+		}
+		if _, exists := remoteManifest.Files[filepath.FromSlash(key)]; exists {
+			continue
+		}
+		tombstone := entry
+		tombstone.Deleted = true
+		tombstone.UpdatedAt = now
+		tombstone.UpdatedBy = writer
+		update.files[key] = tombstone
+		update.basis[key] = entry.ETag
+	}
+
+	return update
+}
+
+// writesremote reports whether an operation writes an object
+func writesRemote(op SyncOp) bool {
+	return op == SyncOpUpload || op == SyncOpMove || op == SyncOpCopy
+}
+
+// limitto drops the changes observed outside the given paths, for runs whose listing covered
+// only those paths. the writes of the run are kept.
+func (u *RemoteStateUpdate) LimitTo(paths []string) {
+	for key := range u.basis {
+		if !coveredBy(filepath.FromSlash(key), paths) {
+			delete(u.files, key)
+			delete(u.basis, key)
+		}
+	}
+}
+
+// empty reports whether the update changes nothing
+func (u *RemoteStateUpdate) Empty() bool {
+	return len(u.files) == 0
+}
+
+// applyto writes the update into a state. observed changes are dropped where another client
+// has changed the entry since it was observed; the uploads of this run always apply.
+func (u *RemoteStateUpdate) ApplyTo(state *RemoteState, writer WriterIdentity, now time.Time) {
+	for key, entry := range u.files {
+		if basis, observed := u.basis[key]; observed && state.Files[key].ETag != basis {
+			continue
+		}
+		state.Files[key] = entry
+	}
+	state.Writer = writer
+	state.UpdatedAt = now
+}
+
+// applytombstones keeps files deleted remotely by another client from being uploaded again.
+// an upload of a file that is gone from the bucket, unchanged locally since the last sync and
+// recorded as deleted in the shared state becomes a skip.
+func applyTombstones(actions []SyncAction, remoteManifest, lastKnownManifest *Manifest, state *RemoteState) {
+	for i := range actions {
+		action := &actions[i]
+		if action.Operation != SyncOpUpload {
+			continue
+		}
+		if _, remoteExists := remoteManifest.Files[action.RelativePath]; remoteExists {
+			continue
+		}
+		lastKnownFile, wasKnown := lastKnownManifest.Files[action.RelativePath]
+		if !wasKnown || LocalChanged(action.File, lastKnownFile) {
+			continue
+		}
+		tombstone, deleted := state.Tombstone(action.RelativePath)
+		if !deleted {
+			continue
+		}
+
+		action.Operation = SyncOpSkip
+		action.Reason = fmt.Sprintf("deleted remotely by %s at %s", tombstone.UpdatedBy, tombstone.UpdatedAt.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// writeridentity returns the identity this directory writes the shared state with, creating
+// its client id on first use
+func (m *ManifestManager) WriterIdentity() (WriterIdentity, error) {
+	identity := WriterIdentity{}
+	identity.Host, _ = os.Hostname()
+	if current, err := user.Current(); err == nil {
+		identity.User = current.Username
+	}
+
+	idPath := filepath.Join(m.stateDir, "client-id")
+	if data, err := os.ReadFile(idPath); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		identity.ClientID = strings.TrimSpace(string(data))
+		return identity, nil
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return identity, fmt.Errorf("failed to create client id: %w", err)
+	}
+	identity.ClientID = hex.EncodeToString(random)
+	if err := fileutils.CreateDirIfNotExists(m.stateDir); err != nil {
+		return identity, fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	if err := os.WriteFile(idPath, []byte(identity.ClientID+"\n"), 0644); err != nil {
+		return identity, fmt.Errorf("failed to save client id: %w", err)
+	}
+
+	return identity, nil
+}
+
+// remotestatemark is the shared state object this directory last saw recording every object
+// of its prefix
+type remoteStateMark struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	ETag   string `json:"etag"`
+}
+
+// completeremotestate returns the etag of the shared state object at key as this directory
+// last saw it recording every object of the prefix, empty if it never did. while the object
+// keeps that etag, no client maintaining the state has changed the prefix since.
+func (m *ManifestManager) CompleteRemoteState(bucketName, key string) string {
+	data, err := os.ReadFile(filepath.Join(m.stateDir, "state-etag.json"))
+	if err != nil {
+		return ""
+	}
+	var mark remoteStateMark
+	if err := json.Unmarshal(data, &mark); err != nil || mark.Bucket != bucketName || mark.Key != key {
+		return ""
+	}
+	return mark.ETag
+}
+
+// savecompleteremotestate records that the shared state object at key with etag records every
+// object of the prefix
+func (m *ManifestManager) SaveCompleteRemoteState(bucketName, key, etag string) error {
+	data, err := json.Marshal(remoteStateMark{Bucket: bucketName, Key: key, ETag: etag})
+	if err != nil {
+		return fmt.Errorf("failed to save remote state etag: %w", err)
+	}
+	if err := fileutils.CreateDirIfNotExists(m.stateDir); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.stateDir, "state-etag.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to save remote state etag: %w", err)
+	}
+	return nil
+}
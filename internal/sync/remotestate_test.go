@@ -0,0 +1,90 @@
+package sync
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+func TestTombstonedCopyIsNotResurrected(t *testing.T) {
+	file := func(relativePath string) fileutils.FileInfo {
+		return fileutils.FileInfo{RelativePath: relativePath, Size: 4, Checksum: "same", ETag: "etag"}
+	}
+	lastKnown := &Manifest{Files: map[string]fileutils.FileInfo{"a.txt": file("a.txt"), "b.txt": file("b.txt")}}
+	local := &Manifest{Files: map[string]fileutils.FileInfo{"a.txt": file("a.txt"), "b.txt": file("b.txt")}}
+	// another client deleted a.txt; b.txt with the same content is still there
+	remote := &Manifest{Files: map[string]fileutils.FileInfo{"b.txt": file("b.txt")}}
+	state := NewRemoteState()
+	state.Files["a.txt"] = RemoteFileState{ETag: "etag", Size: 4, Deleted: true}
+
+	actions, err := ComputeSharedSyncActions(local, remote, lastKnown, state, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range actions {
+		if action.RelativePath == "a.txt" && action.Operation != SyncOpSkip {
+			t.Errorf("a.txt was deleted by another client but is planned as %s (%s)", action.Operation, action.Reason)
+		}
+	}
+
+	// without the shared state the file is restored from its copy
+	for _, action := range ComputeSyncActions(local, remote, lastKnown) {
+		if action.RelativePath == "a.txt" && action.Operation != SyncOpCopy {
+			t.Errorf("a.txt is planned as %s, want a copy of b.txt", action.Operation)
+		}
+	}
+}
+
+func TestRemoteStateUpdateOfPartialRun(t *testing.T) {
+	writer := WriterIdentity{Host: "host", ClientID: "id"}
+	state := NewRemoteState()
+	state.Files["watched/gone.txt"] = RemoteFileState{ETag: "gone", Size: 1}
+	state.Files["other.txt"] = RemoteFileState{ETag: "other", Size: 1}
+
+	// a watch run looked up only watched/gone.txt and deleted its object
+	remote := &Manifest{Files: map[string]fileutils.FileInfo{
+		filepath.FromSlash("watched/gone.txt"): {RelativePath: filepath.FromSlash("watched/gone.txt"), ETag: "gone", Size: 1},
+	}}
+	results := []ActionResult{{Operation: SyncOpDelete, RelativePath: filepath.FromSlash("watched/gone.txt"), Status: StatusOK}}
+	update := NewRemoteStateUpdate(&Manifest{Files: map[string]fileutils.FileInfo{}}, remote, state, results, writer, time.Now())
+	update.LimitTo([]string{filepath.FromSlash("watched/gone.txt")})
+	update.ApplyTo(state, writer, time.Now())
+
+	if tombstone, deleted := state.Tombstone("watched/gone.txt"); !deleted || tombstone.ETag != "gone" {
+		t.Errorf("deleted object recorded as %+v, want a tombstone", tombstone)
+	}
+	if _, deleted := state.Tombstone("other.txt"); deleted {
+		t.Error("object outside the run was tombstoned")
+	}
+}
+
+func TestRemoteStateStandsInForListing(t *testing.T) {
+	state := NewRemoteState()
+	state.Files["dir/kept.txt"] = RemoteFileState{ETag: "kept", Size: 3}
+	state.Files["gone.txt"] = RemoteFileState{ETag: "gone", Size: 1, Deleted: true}
+
+	manifest := state.Manifest("bucket", "prefix")
+	if len(manifest.Files) != 1 {
+		t.Fatalf("manifest holds %d files, want only the live one", len(manifest.Files))
+	}
+	kept := manifest.Files["dir/kept.txt"]
+	if kept.Path != "prefix/dir/kept.txt" || kept.ETag != "kept" || kept.Checksum != "kept" || kept.Size != 3 {
+		t.Errorf("live object listed as %+v", kept)
+	}
+
+	manager := NewManifestManager(t.TempDir())
+	if etag := manager.CompleteRemoteState("bucket", "prefix/.s3sync/state"); etag != "" {
+		t.Errorf("fresh directory has complete state %q", etag)
+	}
+	if err := manager.SaveCompleteRemoteState("bucket", "prefix/.s3sync/state", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if etag := manager.CompleteRemoteState("bucket", "prefix/.s3sync/state"); etag != "v1" {
+		t.Errorf("complete state is %q, want v1", etag)
+	}
+	if etag := manager.CompleteRemoteState("other", "prefix/.s3sync/state"); etag != "" {
+		t.Errorf("complete state of another bucket is %q", etag)
+	}
+}
@@ -0,0 +1,107 @@
+package sync
+
+import "sort"
+
+// change describes how one side of a file changed since the last sync
+type Change string
+
+const (
+	ChangeNone     Change = ""
+	ChangeAdded    Change = "added"
+	ChangeModified Change = "modified"
+	ChangeDeleted  Change = "deleted"
+)
+
+// code returns the single-letter porcelain code for a change
+func (c Change) Code() string {
+	switch c {
+	case ChangeAdded:
+		return "A"
+	case ChangeModified:
+		return "M"
+	case ChangeDeleted:
+		return "D"
+	}
+	return " "
+}
+
+// filestatus describes the pending changes of a single file on each side
+type FileStatus struct {
+	RelativePath string `json:"relative_path"`
+	Local        Change `json:"local,omitempty"`
+	Remote       Change `json:"remote,omitempty"`
+	Conflict     bool   `json:"conflict"`
+}
+
+// computestatus compares local and remote state to the last known manifest and
+// returns the files that changed on either side, sorted by path. files never synced that
+// exist on both sides are compared with matches, since a remote listing only carries etags.
+func ComputeStatus(localManifest, remoteManifest, lastKnownManifest *Manifest, matches ETagMatcher) ([]FileStatus, error) {
+	paths := make(map[string]struct{})
+	for relativePath := range localManifest.Files {
+		paths[relativePath] = struct{}{}
+	}
+	for relativePath := range remoteManifest.Files {
+		paths[relativePath] = struct{}{}
+	}
+	for relativePath := range lastKnownManifest.Files {
+		paths[relativePath] = struct{}{}
+	}
+
+	statuses := make([]FileStatus, 0)
+	for relativePath := range paths {
+		localFile, localExists := localManifest.Files[relativePath]
+		remoteFile, remoteExists := remoteManifest.Files[relativePath]
+		lastKnownFile, wasKnown := lastKnownManifest.Files[relativePath]
+
+		status := FileStatus{RelativePath: relativePath}
+
+		if !wasKnown {
+			if localExists {
+				status.Local = ChangeAdded
+			}
+			if remoteExists {
+				status.Remote = ChangeAdded
+			}
+			// added on both sides with the same content is not a change
+			if localExists && remoteExists {
+				same, err := sameContent(localFile, remoteFile, matches)
+				if err != nil {
+					return nil, err
+				}
+				if same {
+					continue
+				}
+			}
+		} else {
+			switch {
+			case !localExists:
+				status.Local = ChangeDeleted
+			case LocalChanged(localFile, lastKnownFile):
+				status.Local = ChangeModified
+			}
+			switch {
+			case !remoteExists:
+				status.Remote = ChangeDeleted
+			case RemoteChanged(remoteFile, lastKnownFile):
+				status.Remote = ChangeModified
+			}
+		}
+
+		if status.Local == ChangeNone && status.Remote == ChangeNone {
+			continue
+		}
+
+		// a file deleted on both sides agrees; any other change on both sides conflicts
+		status.Conflict = status.Local != ChangeNone && status.Remote != ChangeNone &&
+			!(status.Local == ChangeDeleted && status.Remote == ChangeDeleted)
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].RelativePath < statuses[j].RelativePath
+	})
+
+	return statuses, nil
+}
@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+func TestComputeStatusMatchesETagsOfUnsyncedFiles(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("hello world\n")
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("hello there\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// md5 of "hello world\n", as s3 reports it for a single-part upload
+	etag := `"6f5902ac237024bdd0c176cb93063dc4"`
+	size := int64(len(content))
+
+	local := &Manifest{Files: map[string]fileutils.FileInfo{
+		"hello.txt": {RelativePath: "hello.txt", Size: size, Checksum: fmt.Sprintf("%x", sha256.Sum256(content))},
+		"other.txt": {RelativePath: "other.txt", Size: size, Checksum: "different"},
+	}}
+	remote := &Manifest{Files: map[string]fileutils.FileInfo{
+		"hello.txt": {RelativePath: "hello.txt", Size: size, Checksum: etag, ETag: etag},
+		"other.txt": {RelativePath: "other.txt", Size: size, Checksum: etag, ETag: etag},
+	}}
+	matches := func(local, remote fileutils.FileInfo) (bool, error) {
+		if local.Size != remote.Size {
+			return false, nil
+		}
+		return fileutils.MatchesETag(filepath.Join(dir, local.RelativePath), remote.ETag, 0)
+	}
+
+	statuses, err := ComputeStatus(local, remote, emptyManifest(), matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].RelativePath != "other.txt" || !statuses[0].Conflict {
+		t.Errorf("got %+v, want only other.txt added on both sides as a conflict", statuses)
+	}
+}
@@ -0,0 +1,163 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// manifeststore persists the sync manifest of a directory. backends differ in how much they
+// write per change, not in what a run keeps in memory: planning works on the manifest returned
+// by load, which holds every entry.
+type ManifestStore interface {
+	// exists reports whether a manifest has been saved
+	Exists() bool
+	// load returns the stored manifest with all of its entries, or an empty one if none was saved
+	Load() (*Manifest, error)
+	// entries streams the stored files in path order
+	Entries() iter.Seq2[fileutils.FileInfo, error]
+	// put adds or replaces files without rewriting the rest of the manifest where the backend allows
+	Put(files ...fileutils.FileInfo) error
+	// delete removes files by relative path
+	Delete(relativePaths ...string) error
+	// save replaces the stored manifest, writing only what changed where the backend allows
+	Save(manifest *Manifest) error
+	// reset moves the stored manifest aside to a .bak file so a new one can be written
+	Reset() error
+}
+
+// emptymanifest returns a manifest without files at the current schema version
+func emptyManifest() *Manifest {
+	return &Manifest{Version: ManifestVersion, Files: make(map[string]fileutils.FileInfo)}
+}
+
+// sortedfiles returns the files of a manifest in path order
+func (m *Manifest) SortedFiles() []fileutils.FileInfo {
+	files := make([]fileutils.FileInfo, 0, len(m.Files))
+	for file := range sortedEntries(m.Files) {
+		files = append(files, file)
+	}
+	return files
+}
+
+// backupfile renames a store file to path.bak, replacing an older backup
+func backupFile(path string) error {
+	if err := os.Rename(path, path+".bak"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move manifest aside: %w", err)
+	}
+	return nil
+}
+
+// sortedentries streams the files of a manifest in path order
+func sortedEntries(files map[string]fileutils.FileInfo) iter.Seq2[fileutils.FileInfo, error] {
+	return func(yield func(fileutils.FileInfo, error) bool) {
+		paths := make([]string, 0, len(files))
+		for relativePath := range files {
+			paths = append(paths, relativePath)
+		}
+		sort.Strings(paths)
+
+		for _, relativePath := range paths {
+			if !yield(files[relativePath], nil) {
+				return
+			}
+		}
+	}
+}
+
+// jsonstore keeps the manifest in a single json document that is rewritten on every change.
+// it is the original format, kept for reading manifests written by older versions.
+type jsonStore struct {
+	path string
+}
+
+// newjsonstore creates a store backed by a json document
+func NewJSONStore(path string) ManifestStore {
+	return &jsonStore{path: path}
+}
+
+func (s *jsonStore) Exists() bool {
+	return fileutils.FileExists(s.path)
+}
+
+func (s *jsonStore) Load() (*Manifest, error) {
+	if !s.Exists() {
+		// return empty manifest if none exists
+		return emptyManifest(), nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest file: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest file: %w", err)
+	}
+
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]fileutils.FileInfo)
+	}
+
+	return &manifest, nil
+}
+
+func (s *jsonStore) Reset() error {
+	return backupFile(s.path)
+}
+
+func (s *jsonStore) Entries() iter.Seq2[fileutils.FileInfo, error] {
+	manifest, err := s.Load()
+	if err != nil {
+		return func(yield func(fileutils.FileInfo, error) bool) {
+			yield(fileutils.FileInfo{}, err)
+		}
+	}
+	return sortedEntries(manifest.Files)
+}
+
+func (s *jsonStore) Put(files ...fileutils.FileInfo) error {
+	manifest, err := s.Load()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		manifest.Files[file.RelativePath] = file
+	}
+	return s.Save(manifest)
+}
+
+func (s *jsonStore) Delete(relativePaths ...string) error {
+	manifest, err := s.Load()
+	if err != nil {
+		return err
+	}
+	for _, relativePath := range relativePaths {
+		delete(manifest.Files, relativePath)
+	}
+	return s.Save(manifest)
+}
+
+func (s *jsonStore) Save(manifest *Manifest) error {
+	// ensure manifest directory exists
+	if err := fileutils.CreateDirIfNotExists(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest file: %w", err)
+	}
+
+	return nil
+}
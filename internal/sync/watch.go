@@ -0,0 +1,71 @@
+package sync
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/jvkec/aws-s3sync/internal/fileutils"
+)
+
+// subset returns the entries of a manifest at the given paths or below them
+func (m *Manifest) Subset(paths []string) *Manifest {
+	subset := &Manifest{
+		Files:  make(map[string]fileutils.FileInfo),
+		Bucket: m.Bucket,
+		Prefix: m.Prefix,
+	}
+	for relativePath, file := range m.Files {
+		if coveredBy(relativePath, paths) {
+			subset.Files[relativePath] = file
+		}
+	}
+	return subset
+}
+
+// replacesubset replaces the entries at the given paths or below them with those of updated
+func (m *Manifest) ReplaceSubset(paths []string, updated *Manifest) {
+	for relativePath := range m.Files {
+		if coveredBy(relativePath, paths) {
+			delete(m.Files, relativePath)
+		}
+	}
+	for relativePath, file := range updated.Files {
+		m.Files[relativePath] = file
+	}
+}
+
+// coveredby reports whether a path is one of paths or lies below one of them
+func coveredBy(relativePath string, paths []string) bool {
+	for _, path := range paths {
+		if relativePath == path || strings.HasPrefix(relativePath, path+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// propagatelocaldeletions turns the skipped deletions of local files into remote deletes for
+// files whose object is unchanged since the last sync; objects changed remotely are kept. it
+// returns the number of actions changed.
+func PropagateLocalDeletions(actions []SyncAction, localManifest, remoteManifest, lastKnownManifest *Manifest) int {
+	changed := 0
+	for i := range actions {
+		action := &actions[i]
+		if action.Operation != SyncOpSkip {
+			continue
+		}
+		if _, localExists := localManifest.Files[action.RelativePath]; localExists {
+			continue
+		}
+		remoteFile, remoteExists := remoteManifest.Files[action.RelativePath]
+		lastKnownFile, wasKnown := lastKnownManifest.Files[action.RelativePath]
+		if !remoteExists || !wasKnown || RemoteChanged(remoteFile, lastKnownFile) {
+			continue
+		}
+
+		action.Operation = SyncOpDelete
+		action.Reason = "deleted locally, removing remote copy"
+		changed++
+	}
+	return changed
+}